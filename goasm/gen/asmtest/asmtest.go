@@ -0,0 +1,413 @@
+// Package asmtest runs a generator and a test program against its output the way the
+// checksum tests need to, for test modes of a kernel that can't be committed: in a copy of
+// the module in a temporary directory, so that the working tree is never written to and any
+// number of runs can go in parallel.
+//
+//	h := &asmtest.Harness{
+//		Generator: strings.Fields(goGen),
+//		Asm:       "checksum_amd64.s",
+//		Origins:   "checksum_amd64.json",
+//	}
+//	res, err := h.Run(t, "early_fail", "checksum([]byte{0x5})")
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	if res.Failure != nil {
+//		t.Logf("failed at line %d, emitted by %s:\n%s", res.Failure.Line, res.Failure.Origin,
+//			strings.Join(res.Failure.Context, "\n"))
+//	}
+//
+// The program is a Go expression evaluated in a test of the package, the result carries
+// its value along with the exit status of go test and, when the toolchain rejected the
+// generated assembly, the line it rejected, the lines around it and the generator line
+// that emitted it.
+package asmtest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/build"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Colors and the banners the checksum tests report with.
+const (
+	Green  = "\033[32m"
+	Red    = "\033[31m"
+	Reset  = "\033[0m"
+	Passed = Green + "\n\ntest passed: " + Reset + "%v\n"
+	Failed = Red + "\n\ntest failed: " + Reset + "%v\n"
+)
+
+// DefaultTimeout bounds each command a Harness runs unless it says otherwise.
+// The first generation in a fresh module cache downloads avo.
+const DefaultTimeout = 2 * time.Minute
+
+// ContextLines is how many lines before and after the failing one a Failure shows.
+const ContextLines = 3
+
+// Harness generates the assembly of one package and runs programs against it.
+type Harness struct {
+	// Dir is the package directory, the working directory if empty.
+	Dir string
+	// Generator is the generator command line, run in the package directory of the copy.
+	// A test mode is passed to it in ASM_TEST_MODE and with an -asmtest flag.
+	Generator []string
+	// Asm is the assembly file Generator writes, relative to the package directory.
+	Asm string
+	// Origins, if set, is passed to Generator with an -origins flag as the name of the
+	// JSON map from lines of Asm to the generator lines that emitted them, the way
+	// gen.File writes it, and a Failure reports the origin of its line.
+	Origins string
+	// Timeout bounds each command, DefaultTimeout if zero.
+	Timeout time.Duration
+}
+
+// Copy is a copy of the module with freshly generated assembly.
+type Copy struct {
+	// Dir is the package directory within the copy.
+	Dir string
+	// Asm is the generated assembly.
+	Asm []byte
+
+	h       *Harness
+	pkg     string
+	origins map[int]origin
+}
+
+// origin is a gen.Origin, the JSON of which is all this package needs of it.
+type origin struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+	Func string `json:"func"`
+}
+
+// Result is the outcome of a program.
+type Result struct {
+	// Value is the program's value formatted with %v, empty if it never got to one.
+	Value string
+	// Exit is the exit status of go test, zero when the program ran and passed.
+	Exit int
+	// Output is everything go test printed.
+	Output []byte
+	// Asm is the assembly the program ran against.
+	Asm []byte
+	// Failure is the first line of Asm the toolchain complained about, if any.
+	Failure *Failure
+}
+
+// Failure is a line of generated assembly that failed to assemble.
+type Failure struct {
+	// Line is the line number in the assembly, counting from 1.
+	Line int
+	// Message is the line of output that pointed at it.
+	Message string
+	// Context is Line and up to ContextLines lines on either side of it, numbered,
+	// the failing one marked.
+	Context []string
+	// Origin is the generator line that emitted Line, like "/src/asm.go:437 (main.main)",
+	// if the Harness asked for Origins and the generator knew.
+	Origin string
+}
+
+// Run generates the assembly for mode in a copy of the module and runs expr against it.
+func (h *Harness) Run(tb testing.TB, mode, expr string) (*Result, error) {
+	c, err := h.Generate(tb, mode)
+	if err != nil {
+		return nil, err
+	}
+	return c.Run(expr)
+}
+
+// Generate copies the module into a temporary directory of tb and runs the generator
+// for mode there, an empty mode being the one that gets committed.
+func (h *Harness) Generate(tb testing.TB, mode string) (*Copy, error) {
+	tb.Helper()
+	if len(h.Generator) == 0 || h.Asm == "" {
+		return nil, errors.New("asmtest: harness needs a generator and its assembly")
+	}
+	src, err := filepath.Abs(h.Dir)
+	if err != nil {
+		return nil, err
+	}
+	bp, err := build.ImportDir(src, 0)
+	if err != nil {
+		return nil, fmt.Errorf("asmtest: %w", err)
+	}
+	root, err := h.module(src)
+	if err != nil {
+		return nil, err
+	}
+	rel, err := filepath.Rel(root, src)
+	if err != nil {
+		return nil, err
+	}
+	dst := tb.TempDir()
+	if err = copyModule(dst, root); err != nil {
+		return nil, fmt.Errorf("asmtest: copying %s: %w", root, err)
+	}
+	if err = h.relocate(dst, root); err != nil {
+		return nil, err
+	}
+
+	c := &Copy{Dir: filepath.Join(dst, rel), h: h, pkg: bp.Name}
+	args := h.Generator
+	if mode != "" {
+		args = append(args[:len(args):len(args)], "-asmtest")
+	}
+	if h.Origins != "" {
+		args = append(args[:len(args):len(args)], "-origins", h.Origins)
+	}
+	if out, err := h.run(c.Dir, args, "ASM_TEST_MODE="+mode); err != nil {
+		return nil, fmt.Errorf("asmtest: generating %q: %w\n%s", mode, err, out)
+	}
+	if c.Asm, err = os.ReadFile(filepath.Join(c.Dir, h.Asm)); err != nil {
+		return nil, fmt.Errorf("asmtest: reading generated assembly: %w", err)
+	}
+	if h.Origins != "" {
+		if c.origins, err = readOrigins(filepath.Join(c.Dir, h.Origins), dst, root); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// readOrigins reads the origins the generator wrote to name, pointing those in the copy
+// at dst back at the module at root.
+func readOrigins(name, dst, root string) (map[int]origin, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("asmtest: reading origins: %w", err)
+	}
+	var origins map[int]origin
+	if err = json.Unmarshal(b, &origins); err != nil {
+		return nil, fmt.Errorf("asmtest: reading origins: %w", err)
+	}
+	for line, o := range origins {
+		if rel, err := filepath.Rel(dst, o.File); err == nil && !strings.HasPrefix(rel, "..") {
+			o.File = filepath.Join(root, rel)
+			origins[line] = o
+		}
+	}
+	return origins, nil
+}
+
+// program is the test expr gets evaluated in, it hands the value back through a file.
+const program = `package %s
+
+import (
+	asmtestfmt "fmt"
+	asmtestos "os"
+	asmtesttesting "testing"
+)
+
+func TestAsmtestProgram(t *asmtesttesting.T) {
+	v := %s
+	if err := asmtestos.WriteFile(asmtestos.Getenv("ASMTEST_VALUE"), []byte(asmtestfmt.Sprint(v)), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+`
+
+// Run compiles a test evaluating expr in the package and runs it. expr sees the names of the
+// package and no imports. Programs run one after the other in a Copy, each replacing the last.
+func (c *Copy) Run(expr string) (*Result, error) {
+	src := fmt.Sprintf(program, c.pkg, expr)
+	if err := os.WriteFile(filepath.Join(c.Dir, "asmtest_program_test.go"), []byte(src), 0644); err != nil {
+		return nil, err
+	}
+	value := filepath.Join(c.Dir, "asmtest_value")
+	if err := os.Remove(value); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	out, err := c.h.run(c.Dir, []string{"go", "test", "-count=1", "-run", "^TestAsmtestProgram$", "."}, "ASMTEST_VALUE="+value)
+	res := &Result{Output: out, Asm: c.Asm}
+	var exit *exec.ExitError
+	switch {
+	case errors.As(err, &exit) && exit.ExitCode() >= 0:
+		res.Exit = exit.ExitCode()
+	case err != nil:
+		return nil, fmt.Errorf("asmtest: running %q: %w\n%s", expr, err, out)
+	}
+	if b, err := os.ReadFile(value); err == nil {
+		res.Value = string(b)
+	}
+	res.Failure = locate(filepath.Base(c.h.Asm), out, c.Asm)
+	if o, ok := c.origins[res.Failure.line()]; ok {
+		res.Failure.Origin = fmt.Sprintf("%s:%d (%s)", o.File, o.Line, o.Func)
+	}
+	return res, nil
+}
+
+// run runs args in dir, bounded by the timeout of h, and returns everything it printed.
+// Module requirements are updated as needed, the copy is thrown away anyway.
+func (h *Harness) run(dir string, args []string, env ...string) ([]byte, error) {
+	timeout := h.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOWORK=off", "GOFLAGS="+strings.TrimSpace(os.Getenv("GOFLAGS")+" -mod=mod"))
+	cmd.Env = append(cmd.Env, env...)
+	cmd.WaitDelay = time.Second
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	return out.Bytes(), err
+}
+
+// module returns the root directory of the module dir is in.
+func (h *Harness) module(dir string) (string, error) {
+	out, err := h.run(dir, []string{"go", "env", "GOMOD"})
+	if err != nil {
+		return "", fmt.Errorf("asmtest: finding the module of %s: %w", dir, err)
+	}
+	gomod := strings.TrimSpace(string(out))
+	if gomod == "" || gomod == os.DevNull {
+		return "", fmt.Errorf("asmtest: %s is not in a module", dir)
+	}
+	return filepath.Dir(gomod), nil
+}
+
+// relocate points the replacements of the copy in dst that are relative paths back at the
+// directories next to root they meant, like asm/gen => ../gen.
+func (h *Harness) relocate(dst, root string) error {
+	out, err := h.run(dst, []string{"go", "mod", "edit", "-json"})
+	if err != nil {
+		return fmt.Errorf("asmtest: reading go.mod: %w", err)
+	}
+	var mod struct {
+		Replace []struct {
+			Old, New struct{ Path, Version string }
+		}
+	}
+	if err = json.Unmarshal(out, &mod); err != nil {
+		return fmt.Errorf("asmtest: reading go.mod: %w", err)
+	}
+	var args []string
+	for _, r := range mod.Replace {
+		if r.New.Version != "" || !(strings.HasPrefix(r.New.Path, "./") || strings.HasPrefix(r.New.Path, "../")) {
+			continue
+		}
+		old := r.Old.Path
+		if r.Old.Version != "" {
+			old += "@" + r.Old.Version
+		}
+		args = append(args, "-replace", old+"="+filepath.Join(root, r.New.Path))
+	}
+	if args == nil {
+		return nil
+	}
+	if out, err = h.run(dst, append([]string{"go", "mod", "edit"}, args...)); err != nil {
+		return fmt.Errorf("asmtest: relocating replacements: %w\n%s", err, out)
+	}
+	return nil
+}
+
+// copyModule copies the files of the module at root into dst, leaving out the
+// directories the go command ignores and modules nested in it.
+func copyModule(dst, root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			if path == root {
+				return nil
+			}
+			if strings.HasPrefix(d.Name(), ".") || strings.HasPrefix(d.Name(), "_") {
+				return filepath.SkipDir
+			}
+			if _, err := os.Stat(filepath.Join(path, "go.mod")); err == nil {
+				return filepath.SkipDir
+			}
+			return os.Mkdir(target, 0755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		return copyFile(target, path)
+	})
+}
+
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// line is the line of f, zero without a failure.
+func (f *Failure) line() int {
+	if f == nil {
+		return 0
+	}
+	return f.Line
+}
+
+// locate finds the first line of output pointing into the assembly file name,
+// like "./checksum_amd64.s:12: unrecognized instruction", and what's at that line of asm.
+func locate(name string, output, asm []byte) *Failure {
+	re := regexp.MustCompile(regexp.QuoteMeta(name) + `:(\d+)`)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		m := re.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		line, err := strconv.Atoi(m[1])
+		if err != nil {
+			continue
+		}
+		return &Failure{Line: line, Message: strings.TrimSpace(scanner.Text()), Context: around(asm, line)}
+	}
+	return nil
+}
+
+// around returns line of asm with ContextLines lines on either side, numbered,
+// the line itself marked.
+func around(asm []byte, line int) []string {
+	lines := strings.Split(string(asm), "\n")
+	var context []string
+	for n := max(line-ContextLines, 1); n <= min(line+ContextLines, len(lines)); n++ {
+		l := fmt.Sprintf("%5d  %s", n, lines[n-1])
+		if n == line {
+			l += "; <------ FAILED HERE"
+		}
+		context = append(context, l)
+	}
+	return context
+}
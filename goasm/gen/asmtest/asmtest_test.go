@@ -0,0 +1,225 @@
+package asmtest
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// generator writes add_amd64.s, with an instruction the assembler rejects on line 6
+// in the broken mode, and fails outright in the fail mode. Like a gen.File it writes
+// where the instructions came from with -origins, all of them from line 42.
+const generator = `//go:build ignore
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const asm = "#include \"textflag.h\"\n\n// func add(x, y uint64) uint64\nTEXT ·add(SB), NOSPLIT, $0-24\n\tMOVQ x+0(FP), AX\n\tADDQ y+8(FP), AX\n\tMOVQ AX, ret+16(FP)\n\tRET\n"
+
+func main() {
+	flag.Bool("asmtest", false, "")
+	origins := flag.String("origins", "", "")
+	flag.Parse()
+	s := asm
+	switch os.Getenv("ASM_TEST_MODE") {
+	case "broken":
+		s = strings.Replace(s, "ADDQ", "ADDQQ", 1)
+	case "fail":
+		os.Exit(1)
+	}
+	if err := os.WriteFile("add_amd64.s", []byte(s), 0644); err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile("add_amd64.go", []byte("package fake\n\nfunc add(x, y uint64) uint64\n"), 0644); err != nil {
+		panic(err)
+	}
+	if *origins != "" {
+		wd, _ := os.Getwd()
+		o := fmt.Sprintf("{\"file\": %q, \"line\": 42, \"func\": \"main.main\"}", filepath.Join(wd, "gen.go"))
+		js := "{\"5\": " + o + ", \"6\": " + o + ", \"7\": " + o + ", \"8\": " + o + "}"
+		if err := os.WriteFile(*origins, []byte(js), 0644); err != nil {
+			panic(err)
+		}
+	}
+}
+`
+
+// module writes a module that takes a dependency from a directory next to it and
+// returns the directory of its package.
+func module(t *testing.T) string {
+	if runtime.GOARCH != "amd64" {
+		t.Skip("the test generator writes amd64 assembly")
+	}
+	root := t.TempDir()
+	for name, src := range map[string]string{
+		"dep/go.mod":       "module fake/dep\n\ngo 1.22\n",
+		"dep/dep.go":       "package dep\n\nfunc Two() uint64 { return 2 }\n",
+		"fake/go.mod":      "module fake\n\ngo 1.22\n\nrequire fake/dep v0.0.0\n\nreplace fake/dep => ../dep\n",
+		"fake/fake.go":     "package fake\n\nimport \"fake/dep\"\n\nvar two = dep.Two()\n",
+		"fake/gen.go":      generator,
+		"fake/.git/HEAD":   "ref: refs/heads/main\n",
+		"fake/sub/go.mod":  "module fake/sub\n\ngo 1.22\n",
+		"fake/sub/sub.go":  "package sub\n",
+		"fake/pkg/pkg.go":  "package pkg\n",
+		"fake/_old/old.go": "package old\n",
+	} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filepath.Join(root, "fake")
+}
+
+func harness(dir string) *Harness {
+	return &Harness{Dir: dir, Generator: []string{"go", "run", "gen.go"}, Asm: "add_amd64.s"}
+}
+
+func TestRun(t *testing.T) {
+	dir := module(t)
+	h := harness(dir)
+
+	tests := []struct {
+		name, expr, value string
+	}{
+		{name: "add", expr: "add(40, two)", value: "42"},
+		{name: "wrap", expr: "add(1<<64-1, 1)", value: "0"},
+		{name: "string", expr: `"no assembly"`, value: "no assembly"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			res, err := h.Run(t, "", tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Exit != 0 || res.Failure != nil {
+				t.Fatalf("exit %d, failure %+v:\n%s", res.Exit, res.Failure, res.Output)
+			}
+			if res.Value != tt.value {
+				t.Errorf("value %q, want %q", res.Value, tt.value)
+			}
+		})
+	}
+
+	t.Cleanup(func() {
+		for _, name := range []string{"add_amd64.s", "add_amd64.go", "asmtest_program_test.go", "asmtest_value"} {
+			if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+				t.Errorf("%s written to the working tree", name)
+			}
+		}
+	})
+}
+
+func TestCopy(t *testing.T) {
+	c, err := harness(module(t)).Generate(t, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{
+		"fake.go":     true,
+		"add_amd64.s": true,
+		"pkg/pkg.go":  true,
+		".git":        false,
+		"sub":         false,
+		"_old":        false,
+	} {
+		if _, err := os.Stat(filepath.Join(c.Dir, name)); (err == nil) != want {
+			t.Errorf("%s copied: %v, want %v", name, err == nil, want)
+		}
+	}
+
+	// programs replace each other
+	for _, expr := range []string{"add(1, 2)", "add(3, 4)"} {
+		res, err := c.Run(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := map[string]string{"add(1, 2)": "3", "add(3, 4)": "7"}[expr]; res.Value != want {
+			t.Errorf("%s = %q, want %q:\n%s", expr, res.Value, want, res.Output)
+		}
+	}
+}
+
+func TestFailure(t *testing.T) {
+	h := harness(module(t))
+
+	res, err := h.Run(t, "broken", "add(1, 2)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Exit == 0 || res.Value != "" {
+		t.Errorf("exit %d, value %q for assembly that doesn't assemble", res.Exit, res.Value)
+	}
+	if res.Failure == nil {
+		t.Fatalf("no failure located in:\n%s", res.Output)
+	}
+	if res.Failure.Line != 6 || !strings.Contains(res.Failure.Message, "add_amd64.s:6") {
+		t.Errorf("failure at line %d (%s), want 6", res.Failure.Line, res.Failure.Message)
+	}
+	var marked []string
+	for _, l := range res.Failure.Context {
+		if strings.HasSuffix(l, "<------ FAILED HERE") {
+			marked = append(marked, l)
+		}
+	}
+	if len(marked) != 1 || !strings.Contains(marked[0], "ADDQQ") {
+		t.Errorf("marked %q in:\n%s", marked, strings.Join(res.Failure.Context, "\n"))
+	}
+
+	if res.Failure.Origin != "" {
+		t.Errorf("origin %s without asking for origins", res.Failure.Origin)
+	}
+	h.Origins = "origins.json"
+	if res, err = h.Run(t, "broken", "add(1, 2)"); err != nil {
+		t.Fatal(err)
+	}
+	// the generator ran in the copy, the origin is in the working tree
+	if want := filepath.Join(h.Dir, "gen.go") + ":42 (main.main)"; res.Failure == nil || res.Failure.Origin != want {
+		t.Errorf("failure %+v, want origin %s", res.Failure, want)
+	}
+
+	if _, err = h.Run(t, "fail", "add(1, 2)"); err == nil {
+		t.Error("no error from a failing generator")
+	}
+	if _, err = (&Harness{Dir: h.Dir, Asm: h.Asm}).Run(t, "", "add(1, 2)"); err == nil {
+		t.Error("no error without a generator")
+	}
+}
+
+func TestAround(t *testing.T) {
+	asm := []byte("a\nb\nc\nd\ne\nf\ng\nh\ni\n")
+	tests := []struct {
+		line        int
+		first, last string
+	}{
+		{line: 1, first: "    1  a; <------ FAILED HERE", last: "    4  d"},
+		{line: 5, first: "    2  b", last: "    8  h"},
+		{line: 9, first: "    6  f", last: "   10  "},
+	}
+	for _, tt := range tests {
+		context := around(asm, tt.line)
+		if context[0] != tt.first || context[len(context)-1] != tt.last {
+			t.Errorf("line %d: %q", tt.line, context)
+		}
+	}
+
+	if f := locate("x.s", []byte("# pkg\n./x.s:3: unrecognized instruction \"QQ\"\n./x.s:5: other\n"), asm); f == nil || f.Line != 3 || f.Message != `./x.s:3: unrecognized instruction "QQ"` {
+		t.Errorf("located %+v", f)
+	}
+	if f := locate("x.s", []byte("ok\n"), asm); f != nil {
+		t.Errorf("located %+v in passing output", f)
+	}
+}
@@ -0,0 +1,91 @@
+// Command asmgen builds any number of generator targets in one process and writes each
+// one's assembly and stubs into a directory, the way go generate would have:
+//
+//	asmgen rfc1071=../rfc1071 rfc1071/early_fail=/tmp/early_fail
+//
+// A target is the name of a generated module, optionally followed by one of its test modes.
+// Every target gets a gen.File of its own, so the order and the number of them don't matter.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"asm/gen"
+	"asm/gen/rfc1071"
+)
+
+// target is a generator the way its module runs it with go generate.
+type target struct {
+	build func(mode string) (*gen.File, error)
+	modes []string
+	// argv is recorded in the "Code generated" headers, asm and stubs are the files it writes.
+	argv       []string
+	asm, stubs string
+}
+
+var targets = map[string]target{
+	"rfc1071": {
+		build: rfc1071.New,
+		modes: rfc1071.Modes,
+		argv:  strings.Fields("go run asm.go -out checksum_amd64.s -stubs checksum_amd64.go"),
+		asm:   "checksum_amd64.s",
+		stubs: "checksum_amd64.go",
+	},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stderr))
+}
+
+func run(args []string, stderr io.Writer) int {
+	if len(args) == 0 || slices.Contains(args, "-h") || slices.Contains(args, "-help") {
+		usage(stderr)
+		return 2
+	}
+	for _, arg := range args {
+		name, dir, ok := strings.Cut(arg, "=")
+		if !ok || dir == "" {
+			usage(stderr)
+			return 2
+		}
+		if err := generate(name, dir); err != nil {
+			fmt.Fprintf(stderr, "asmgen: %s: %v\n", name, err)
+			return 1
+		}
+	}
+	return 0
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: asmgen target[/mode]=dir ...")
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "\t%s, test modes: %s\n", name, strings.Join(targets[name].modes, ", "))
+	}
+}
+
+// generate builds the target called name, like "rfc1071" or "rfc1071/early_fail", into dir.
+func generate(name, dir string) error {
+	module, mode, _ := strings.Cut(name, "/")
+	t, ok := targets[module]
+	if !ok {
+		return errors.New("unknown target")
+	}
+	file, err := t.build(mode)
+	if err != nil {
+		return err
+	}
+	file.Argv = t.argv
+	return file.Generate(filepath.Join(dir, t.asm), filepath.Join(dir, t.stubs))
+}
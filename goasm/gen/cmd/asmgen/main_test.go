@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	// every mode of a target next to the real thing, in one process
+	dirs := map[string]string{}
+	var args []string
+	for _, name := range []string{"rfc1071", "rfc1071/early_fail", "rfc1071/handle_odd"} {
+		dirs[name] = t.TempDir()
+		args = append(args, name+"="+dirs[name])
+	}
+	var stderr bytes.Buffer
+	if status := run(args, &stderr); status != 0 {
+		t.Fatalf("Expected exit status 0, but got %d: %s", status, stderr.String())
+	}
+
+	read := func(name, file string) string {
+		b, err := os.ReadFile(filepath.Join(dirs[name], file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	header := "// Code generated by command: go run asm.go -out checksum_amd64.s -stubs checksum_amd64.go. DO NOT EDIT."
+	for name := range dirs {
+		asm, stubs := read(name, "checksum_amd64.s"), read(name, "checksum_amd64.go")
+		if !strings.HasPrefix(asm, header) || !strings.HasPrefix(stubs, header) {
+			t.Errorf("%s: unexpected headers:\n%s\n%s", name, asm[:strings.Index(asm, "\n")], stubs[:strings.Index(stubs, "\n")])
+		}
+		for _, fn := range []string{"checksum", "partialSum", "checksumADC", "checksumADX"} {
+			if !strings.Contains(asm, "TEXT ·"+fn+"(SB)") {
+				t.Errorf("%s: Expected %s in the assembly", name, fn)
+			}
+		}
+	}
+	if stubs := read("rfc1071/early_fail", "checksum_amd64.go"); stubs != read("rfc1071", "checksum_amd64.go") {
+		t.Errorf("the stubs of a test mode differ from the real ones:\n%s", stubs)
+	}
+	if strings.Contains(read("rfc1071/early_fail", "checksum_amd64.s"), "nextb:") {
+		t.Errorf("early_fail has the full checksum loop")
+	}
+	if !strings.Contains(read("rfc1071", "checksum_amd64.s"), "nextb:") {
+		t.Errorf("rfc1071 is missing the checksum loop")
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	for _, args := range [][]string{
+		nil,
+		{"rfc1071"},
+		{"rfc1071="},
+		{"-h"},
+	} {
+		var stderr bytes.Buffer
+		if status := run(args, &stderr); status != 2 || !strings.Contains(stderr.String(), "usage") {
+			t.Errorf("%q: Expected usage and exit status 2, but got %d: %s", args, status, stderr.String())
+		}
+	}
+	for _, arg := range []string{"simd=" + t.TempDir(), "rfc1071/nope=" + t.TempDir()} {
+		var stderr bytes.Buffer
+		if status := run([]string{arg}, &stderr); status != 1 {
+			t.Errorf("%s: Expected exit status 1, but got %d: %s", arg, status, stderr.String())
+		}
+	}
+}
@@ -0,0 +1,323 @@
+// Package emu runs avo functions in Go: an interpreter for the x86-64 instructions our
+// generators emit, working on the IR before or after register allocation. It exists so
+// that a kernel, or a single labeled block of one like handle_odd, can be run against
+// inputs in a plain Go test, without assembling anything or an amd64 machine.
+//
+//	m := emu.New()
+//	m.Slice("data", []byte("hello"))
+//	if err := m.Run(fn); err != nil {
+//		...
+//	}
+//	sum := m.Args["sum"]
+//
+// Only CF, ZF, SF and OF are modelled, PF and AF never come up in a checksum.
+// Memory is whatever was handed to Map, anything else faults.
+package emu
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mmcloughlin/avo/ir"
+	"github.com/mmcloughlin/avo/operand"
+	"github.com/mmcloughlin/avo/reg"
+)
+
+// DefaultLimit is how many instructions a run executes before giving up on it as a hang.
+const DefaultLimit = 1 << 22
+
+var (
+	// ErrFault is returned for memory accesses outside of the mapped memory.
+	ErrFault = errors.New("memory fault")
+	// ErrUnsupported is returned for instructions and operands the machine doesn't implement.
+	ErrUnsupported = errors.New("unsupported")
+	// ErrLimit is returned when a run takes more than Limit instructions.
+	ErrLimit = errors.New("instruction limit reached")
+)
+
+// Flags are the status flags the machine keeps track of.
+type Flags struct {
+	CF, ZF, SF, OF bool
+}
+
+// Machine is the state of an emulated CPU, registers both virtual and physical.
+// The zero value is not usable, see New.
+type Machine struct {
+	Flags
+
+	// Args holds the frame of the function by name, as avo names the parts of
+	// parameters and results: "data_base", "data_len", "sum", "ret" and so on.
+	// Results are stored zero extended.
+	Args map[string]uint64
+
+	// Limit bounds the number of instructions of a run, DefaultLimit if zero.
+	Limit int
+	// Steps is the number of instructions executed by the last run.
+	Steps int
+
+	regs    map[regKey]*[64]byte
+	regions []region
+	next    uint64
+}
+
+// regKey identifies the storage behind a register, its 8 bit view and its 64 bit
+// register share one.
+type regKey struct {
+	kind     reg.Kind
+	virtual  bool
+	index    uint16
+	highByte bool // AH, CH, DH and BH sit one byte up
+}
+
+type region struct {
+	addr uint64
+	b    []byte
+}
+
+// New returns a machine with zeroed registers and flags, and nothing mapped.
+func New() *Machine {
+	return &Machine{
+		Args: make(map[string]uint64),
+		regs: make(map[regKey]*[64]byte),
+		next: 0x10000,
+	}
+}
+
+// Map makes b addressable and returns its address. Writes go straight to b.
+// Every mapping gets a guard page after it, so reading past the end of b faults.
+func (m *Machine) Map(b []byte) uint64 {
+	addr := m.next
+	m.regions = append(m.regions, region{addr: addr, b: b})
+	m.next += (uint64(len(b)) + 0xFFF) &^ 0xFFF
+	m.next += 0x1000
+	return addr
+}
+
+// Slice maps b and sets the name_base, name_len and name_cap arguments to it.
+func (m *Machine) Slice(name string, b []byte) {
+	m.Args[name+"_base"] = m.Map(b)
+	m.Args[name+"_len"] = uint64(len(b))
+	m.Args[name+"_cap"] = uint64(cap(b))
+}
+
+// memory returns the n mapped bytes at addr.
+func (m *Machine) memory(addr uint64, n int) ([]byte, error) {
+	for _, r := range m.regions {
+		if addr >= r.addr && addr-r.addr <= uint64(len(r.b)) && uint64(len(r.b))-(addr-r.addr) >= uint64(n) {
+			off := addr - r.addr
+			return r.b[off : off+uint64(n)], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %d bytes at %#x", ErrFault, n, addr)
+}
+
+func key(r reg.Register) (regKey, error) {
+	switch r := r.(type) {
+	case reg.Virtual:
+		return regKey{kind: r.Kind(), virtual: true, index: uint16(r.VirtualIndex())}, nil
+	case reg.Physical:
+		k := regKey{kind: r.Kind(), index: uint16(r.PhysicalIndex())}
+		switch r.Asm() {
+		case "AH", "CH", "DH", "BH":
+			k.highByte = true
+		}
+		if k.kind != reg.KindGP && k.kind != reg.KindVector && k.kind != reg.KindOpmask {
+			return k, fmt.Errorf("%w: register %s", ErrUnsupported, r.Asm())
+		}
+		return k, nil
+	}
+	return regKey{}, fmt.Errorf("%w: register %s", ErrUnsupported, r.Asm())
+}
+
+func (m *Machine) storage(r reg.Register) *[64]byte {
+	k, err := key(r)
+	if err != nil {
+		panic(err)
+	}
+	k.highByte = false
+	s, ok := m.regs[k]
+	if !ok {
+		s = new([64]byte)
+		m.regs[k] = s
+	}
+	return s
+}
+
+// Reg returns the value of the general purpose or opmask register r, as wide as r is.
+func (m *Machine) Reg(r reg.Register) uint64 {
+	s := m.storage(r)
+	if k, _ := key(r); k.highByte {
+		return uint64(s[1])
+	}
+	return le(s[:r.Size()])
+}
+
+// SetReg sets the general purpose or opmask register r the way an instruction writing
+// it would: 8 and 16 bit writes leave the rest of the register alone, 32 bit writes
+// clear the upper half.
+func (m *Machine) SetReg(r reg.Register, v uint64) {
+	s := m.storage(r)
+	if k, _ := key(r); k.highByte {
+		s[1] = byte(v)
+		return
+	}
+	n := r.Size()
+	if n == 4 {
+		n = 8
+		v = uint64(uint32(v))
+	}
+	putLE(s[:n], v)
+}
+
+// Vec returns a copy of the vector register r, as wide as r is.
+func (m *Machine) Vec(r reg.Register) []byte {
+	return append([]byte(nil), m.storage(r)[:r.Size()]...)
+}
+
+// SetVec sets the vector register r to b, zeroing whatever is above r like
+// VEX encoded instructions do.
+func (m *Machine) SetVec(r reg.Register, b []byte) {
+	s := m.storage(r)
+	*s = [64]byte{}
+	copy(s[:r.Size()], b)
+}
+
+// Run executes fn from its first instruction up to its RET.
+func (m *Machine) Run(fn *ir.Function) error {
+	_, err := m.run(fn, 0, nil)
+	return err
+}
+
+// RunFrom executes fn from the label from until control reaches one of the stop labels,
+// or any label other than from if there are none, and returns the label it stopped at.
+// A RET stops it too, with an empty label.
+func (m *Machine) RunFrom(fn *ir.Function, from ir.Label, stop ...ir.Label) (ir.Label, error) {
+	start, ok := labels(fn)[from]
+	if !ok {
+		return "", fmt.Errorf("emu: %s has no label %s", fn.Name, from)
+	}
+	if stop == nil {
+		for l := range labels(fn) {
+			if l != from {
+				stop = append(stop, l)
+			}
+		}
+	}
+	return m.run(fn, start, stop)
+}
+
+// labels maps every label of fn to the index of the node following it.
+func labels(fn *ir.Function) map[ir.Label]int {
+	targets := make(map[ir.Label]int)
+	for i, n := range fn.Nodes {
+		if l, ok := n.(ir.Label); ok {
+			targets[l] = i + 1
+		}
+	}
+	return targets
+}
+
+func (m *Machine) run(fn *ir.Function, pc int, stop []ir.Label) (ir.Label, error) {
+	targets := labels(fn)
+	limit := m.Limit
+	if limit == 0 {
+		limit = DefaultLimit
+	}
+	m.Steps = 0
+	for pc < len(fn.Nodes) {
+		switch n := fn.Nodes[pc].(type) {
+		case ir.Label:
+			for _, l := range stop {
+				if l == n {
+					return n, nil
+				}
+			}
+			pc++
+			continue
+		case *ir.Instruction:
+			if m.Steps == limit {
+				return "", &Error{Func: fn.Name, Node: pc, Instruction: n, Err: ErrLimit}
+			}
+			m.Steps++
+			next, err := m.step(n)
+			if err != nil {
+				return "", &Error{Func: fn.Name, Node: pc, Instruction: n, Err: err}
+			}
+			switch {
+			case next == ret:
+				return "", nil
+			case next != "":
+				target, ok := targets[next]
+				if !ok {
+					return "", &Error{Func: fn.Name, Node: pc, Instruction: n, Err: fmt.Errorf("no label %s", next)}
+				}
+				// the jump lands on the label itself, so that it can stop the run
+				pc = target - 1
+				continue
+			}
+		}
+		pc++
+	}
+	return "", fmt.Errorf("emu: ran off the end of %s", fn.Name)
+}
+
+// ret is what step returns for RET, no label can be called that.
+const ret ir.Label = "<ret>"
+
+// Error is an error executing an instruction.
+type Error struct {
+	Func        string
+	Node        int // index of the instruction in the nodes of Func
+	Instruction *ir.Instruction
+	Err         error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("emu: %s: %s: %v", e.Func, Format(e.Instruction), e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Format returns i the way it would appear in the assembly.
+func Format(i *ir.Instruction) string {
+	var b strings.Builder
+	b.WriteString(i.Opcode)
+	for _, s := range i.Suffixes {
+		b.WriteString("." + s)
+	}
+	for n, op := range i.Operands {
+		if n == 0 {
+			b.WriteByte(' ')
+		} else {
+			b.WriteString(", ")
+		}
+		b.WriteString(op.Asm())
+	}
+	return b.String()
+}
+
+// frame returns the name of the argument m points at, if it is one.
+func frame(m operand.Mem) (string, bool) {
+	if m.Base == nil || m.Symbol.Name == "" || m.Base.Asm() != "FP" {
+		return "", false
+	}
+	return m.Symbol.Name, true
+}
+
+func le(b []byte) uint64 {
+	var v uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	return v
+}
+
+func putLE(b []byte, v uint64) {
+	for i := range b {
+		b[i] = byte(v)
+		v >>= 8
+	}
+}
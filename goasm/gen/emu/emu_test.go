@@ -0,0 +1,283 @@
+package emu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/mmcloughlin/avo/ir"
+	"github.com/mmcloughlin/avo/operand"
+	"github.com/mmcloughlin/avo/reg"
+
+	"asm/gen"
+)
+
+// function builds a function with body and returns its IR, registers still virtual.
+func function(t *testing.T, signature string, body func(f *gen.Func)) (*gen.File, *ir.Function) {
+	t.Helper()
+	file := gen.NewFile("emu", "")
+	f := file.Function("fn", signature, "", 0)
+	body(f)
+	built, err := file.IR()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file, gen.Lookup(built, "fn")
+}
+
+// rfc1071 is the checksum of data the textbook way.
+func rfc1071(data []byte) uint16 {
+	var sum uint32
+	for ; len(data) >= 2; data = data[2:] {
+		sum += uint32(binary.BigEndian.Uint16(data))
+	}
+	if len(data) == 1 {
+		sum += uint32(data[0]) << 8
+	}
+	for sum > 0xFFFF {
+		sum = sum>>16 + sum&0xFFFF
+	}
+	return ^uint16(sum)
+}
+
+// sum16 is the kernel of the gen tests, in little endian words all the way.
+func sum16(f *gen.Func) gen.Slice {
+	const (
+		loop      gen.Label = "loop"
+		odd       gen.Label = "odd"
+		done      gen.Label = "done"
+		earlyFail gen.Label = "early_fail"
+	)
+	data := f.LoadSlice("data")
+	f.JumpIfZero(data.Len, earlyFail)
+	sum := f.Reg("sum", 64)
+	f.Zero(sum)
+	word := f.Reg("word", 64)
+	f.Block(loop, func() {
+		f.JumpIfBelow(data.Len, 2, odd)
+		f.MOVWQZX(data.Data, word)
+		f.ADDQ(word, sum)
+		f.Advance(data, 2)
+		f.Jump(loop)
+	})
+	f.Block(odd, func() {
+		f.JumpIfZero(data.Len, done)
+		f.MOVBQZX(data.Data, word)
+		f.ADDQ(word, sum)
+	})
+	f.Label(done)
+	f.Fold16(sum, f.Reg("tmp", 64))
+	f.SwapComplement16(sum)
+	f.Return16(sum)
+	f.Label(earlyFail)
+	f.ReturnZero16(sum)
+	return data
+}
+
+func TestRun(t *testing.T) {
+	file, fn := function(t, "func(data []byte) uint16", func(f *gen.Func) { sum16(f) })
+	check := func(t *testing.T, fn *ir.Function) {
+		for n := 0; n < 300; n++ {
+			data := make([]byte, n)
+			rand.Read(data)
+			m := New()
+			m.Slice("data", data)
+			if err := m.Run(fn); err != nil {
+				t.Fatalf("%d bytes: %v", n, err)
+			}
+			expect := uint64(rfc1071(data))
+			if n == 0 {
+				expect = 0
+			}
+			if m.Args["ret"] != expect {
+				t.Fatalf("%x: expected %#04x, but got %#04x", data, expect, m.Args["ret"])
+			}
+		}
+	}
+	t.Run("virtual", func(t *testing.T) { check(t, fn) })
+	// and again once the registers are allocated
+	compiled, err := file.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("physical", func(t *testing.T) { check(t, gen.Lookup(compiled, "fn")) })
+}
+
+func TestFlags(t *testing.T) {
+	type want struct {
+		v   uint64
+		cf  bool
+		zf  bool
+		sf  bool
+		of  bool
+		reg string
+	}
+	tests := []struct {
+		name string
+		a, b uint64
+		op   func(f *gen.Func, a, b reg.GPVirtual)
+		want want
+	}{
+		{"ADDQ carry", 1<<63 | 1, 1 << 63, func(f *gen.Func, a, b reg.GPVirtual) { f.ADDQ(a, b) }, want{v: 1, cf: true, of: true, reg: "b"}},
+		{"ADDL zero extends", 0xFFFFFFFF_00000001, 0xFFFFFFFF_FFFFFFFF, func(f *gen.Func, a, b reg.GPVirtual) { f.ADDL(gen.View(a, 32), gen.View(b, 32)) }, want{v: 0, cf: true, zf: true, reg: "b"}},
+		{"ADDW keeps the rest", 0x8000, 0x1234_0000_8000, func(f *gen.Func, a, b reg.GPVirtual) { f.ADDW(gen.View(a, 16), gen.View(b, 16)) }, want{v: 0x1234_0000_0000, cf: true, zf: true, of: true, reg: "b"}},
+		{"ADCQ", 0xFFFFFFFF_FFFFFFFF, 0xFFFFFFFF_FFFFFFFF, func(f *gen.Func, a, b reg.GPVirtual) {
+			f.ADDQ(a, b) // b = ...FE, CF
+			f.ADCQ(operand.Imm(0), b)
+		}, want{v: 0xFFFFFFFF_FFFFFFFF, sf: true, reg: "b"}},
+		{"SUBQ borrow", 2, 1, func(f *gen.Func, a, b reg.GPVirtual) { f.SUBQ(a, b) }, want{v: 0xFFFFFFFF_FFFFFFFF, cf: true, sf: true, reg: "b"}},
+		{"CMPQ a, b is a - b", 1, 2, func(f *gen.Func, a, b reg.GPVirtual) { f.CMPQ(a, b) }, want{v: 1, cf: true, sf: true, reg: "a"}},
+		{"SHRQ", 0b110, 0, func(f *gen.Func, a, b reg.GPVirtual) { f.SHRQ(operand.Imm(2), a) }, want{v: 1, cf: true, reg: "a"}},
+		{"SHLW", 0xFF_80FF, 0, func(f *gen.Func, a, b reg.GPVirtual) { f.SHLW(operand.Imm(8), gen.View(a, 16)) }, want{v: 0xFF_FF00, sf: true, reg: "a"}},
+		{"ROLW", 0x1234, 0, func(f *gen.Func, a, b reg.GPVirtual) { f.ROLW(operand.Imm(8), gen.View(a, 16)) }, want{v: 0x3412, reg: "a"}},
+		{"NOTW", 0xFFFF_0F0F, 0, func(f *gen.Func, a, b reg.GPVirtual) { f.NOTW(gen.View(a, 16)) }, want{v: 0xFFFF_F0F0, reg: "a"}},
+		{"TESTQ", 0xF0, 0x0F, func(f *gen.Func, a, b reg.GPVirtual) { f.TESTQ(a, b) }, want{v: 0xF0, zf: true, reg: "a"}},
+		{"ADCXQ and ADOXQ", 0xFFFFFFFF_FFFFFFFF, 1, func(f *gen.Func, a, b reg.GPVirtual) {
+			f.XORQ(b, b)
+			f.ADCXQ(a, b) // b = a, no carry
+			f.ADOXQ(a, b) // b = 2a, OF
+			f.ADCXQ(a, b) // b = 3a, CF
+		}, want{v: 0xFFFFFFFF_FFFFFFFD, cf: true, zf: true, of: true, reg: "b"}}, // ZF is the XORQ's
+		{"BZHIQ", 3, 0xFF, func(f *gen.Func, a, b reg.GPVirtual) { f.BZHIQ(a, b, b) }, want{v: 0b111, reg: "b"}},
+		{"MOVBQZX", 0xABCD, 0xFFFF, func(f *gen.Func, a, b reg.GPVirtual) { f.MOVBQZX(gen.View(a, 8), b) }, want{v: 0xCD, reg: "b"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var regs map[string]reg.GPVirtual
+			_, fn := function(t, "func()", func(f *gen.Func) {
+				f.Label("start")
+				a, b := f.Reg("a", 64), f.Reg("b", 64)
+				regs = map[string]reg.GPVirtual{"a": a, "b": b}
+				tc.op(f, a, b)
+				f.RET()
+			})
+			m := New()
+			m.SetReg(regs["a"], tc.a)
+			m.SetReg(regs["b"], tc.b)
+			if _, err := m.RunFrom(fn, "start"); err != nil {
+				t.Fatal(err)
+			}
+			got := want{m.Reg(regs[tc.want.reg]), m.CF, m.ZF, m.SF, m.OF, tc.want.reg}
+			if got != tc.want {
+				t.Errorf("Expected %+v, but got %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestRunFrom(t *testing.T) {
+	var (
+		sum  reg.GPVirtual
+		data gen.Slice
+	)
+	_, fn := function(t, "func(data []byte) uint16", func(f *gen.Func) {
+		data = sum16(f)
+		sum = f.R("sum")
+	})
+
+	// done runs into the epilogue and returns
+	m := New()
+	m.SetReg(sum, 0x1_0001)
+	if l, err := m.RunFrom(fn, "done", "early_fail"); err != nil || l != "" {
+		t.Fatalf("Expected a RET, but got %q, %v", l, err)
+	}
+	if m.Args["ret"] != 0xFDFF {
+		t.Errorf("Expected 0x0002 swapped and complemented, but got %#x", m.Args["ret"])
+	}
+
+	// odd stops at the next label it reaches
+	m = New()
+	m.SetReg(sum, 1)
+	m.SetReg(data.Data.Base, m.Map([]byte{0x7F}))
+	m.SetReg(data.Len, 1)
+	if l, err := m.RunFrom(fn, "odd"); err != nil || l != "done" {
+		t.Fatalf("Expected to stop at done, but got %q, %v", l, err)
+	}
+	if m.Reg(sum) != 0x80 {
+		t.Errorf("Expected 0x80, but got %#x", m.Reg(sum))
+	}
+
+	if _, err := m.RunFrom(fn, "nope"); err == nil {
+		t.Errorf("Expected an error for a missing label")
+	}
+}
+
+func TestVector(t *testing.T) {
+	var acc, ones, tmp reg.VecVirtual
+	_, fn := function(t, "func(data []byte) uint32", func(f *gen.Func) {
+		data := f.LoadSlice("data")
+		acc, ones, tmp = f.YMM(), f.YMM(), f.YMM()
+		f.VPCMPEQD(ones, ones, ones)
+		f.VPSRLD(operand.Imm(16), ones, ones)
+		f.VPMOVZXWD(data.Data, acc)
+		f.VPMOVZXWD(data.Data.Offset(16), tmp)
+		f.VPADDD(tmp, acc, acc)
+		f.VPADDD(acc, acc, acc)
+		f.FoldLanes(acc, ones, tmp)
+		f.ReduceLanes(acc, tmp)
+		sum := f.Reg("sum", 32)
+		f.VMOVD(acc.AsX(), sum)
+		f.Store(sum, f.ReturnIndex(0))
+		f.VZEROUPPER()
+		f.RET()
+	})
+
+	data := make([]byte, 32)
+	rand.Read(data)
+	m := New()
+	m.Slice("data", data)
+	if err := m.Run(fn); err != nil {
+		t.Fatal(err)
+	}
+	var expect uint64
+	for i := 0; i < 16; i += 2 {
+		// lane i/2 gets word i/2 and word i/2 + 8, twice, and is folded once
+		lane := 2*uint64(binary.LittleEndian.Uint16(data[i:])) + 2*uint64(binary.LittleEndian.Uint16(data[i+16:]))
+		expect += lane>>16 + lane&0xFFFF
+	}
+	if got := m.Args["ret"]; got != expect {
+		t.Errorf("Expected %#x, but got %#x", expect, got)
+	}
+	if upper := m.Vec(acc)[16:]; !bytes.Equal(upper, make([]byte, 16)) {
+		t.Errorf("VZEROUPPER left %x", upper)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	_, fn := function(t, "func(data []byte) uint16", func(f *gen.Func) { sum16(f) })
+	m := New()
+	m.Args["data_base"] = 0x1234
+	m.Args["data_len"] = 2
+	err := m.Run(fn)
+	var e *Error
+	if !errors.Is(err, ErrFault) || !errors.As(err, &e) || e.Instruction.Opcode != "MOVWQZX" || e.Func != "fn" {
+		t.Errorf("Expected a fault at MOVWQZX in fn, but got %v", err)
+	}
+
+	m = New()
+	m.Slice("data", make([]byte, 2))
+	delete(m.Args, "data_len")
+	if err := m.Run(fn); err == nil {
+		t.Errorf("Expected an error for a missing argument")
+	}
+
+	_, spin := function(t, "func()", func(f *gen.Func) {
+		f.Label("spin")
+		f.Jump("spin")
+	})
+	m = New()
+	m.Limit = 100
+	if err := m.Run(spin); !errors.Is(err, ErrLimit) || m.Steps != 100 {
+		t.Errorf("Expected to give up after 100 instructions, but got %v after %d", err, m.Steps)
+	}
+
+	_, unsupported := function(t, "func()", func(f *gen.Func) {
+		f.CPUID()
+		f.RET()
+	})
+	if err := New().Run(unsupported); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Expected CPUID to be unsupported, but got %v", err)
+	}
+}
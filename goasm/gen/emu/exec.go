@@ -0,0 +1,521 @@
+package emu
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+
+	"github.com/mmcloughlin/avo/ir"
+	"github.com/mmcloughlin/avo/operand"
+	"github.com/mmcloughlin/avo/reg"
+)
+
+// conditions of the Jcc and CMOVcc instructions, by the suffixes Go's assembler uses.
+var conditions = map[string]func(f Flags) bool{
+	"CS": func(f Flags) bool { return f.CF },
+	"CC": func(f Flags) bool { return !f.CF },
+	"EQ": func(f Flags) bool { return f.ZF },
+	"NE": func(f Flags) bool { return !f.ZF },
+	"HI": func(f Flags) bool { return !f.CF && !f.ZF },
+	"LS": func(f Flags) bool { return f.CF || f.ZF },
+	"MI": func(f Flags) bool { return f.SF },
+	"PL": func(f Flags) bool { return !f.SF },
+	"OS": func(f Flags) bool { return f.OF },
+	"OC": func(f Flags) bool { return !f.OF },
+	"LT": func(f Flags) bool { return f.SF != f.OF },
+	"GE": func(f Flags) bool { return f.SF == f.OF },
+	"GT": func(f Flags) bool { return !f.ZF && f.SF == f.OF },
+	"LE": func(f Flags) bool { return f.ZF || f.SF != f.OF },
+}
+
+// jumps maps the aliases of the conditional jumps avo has to the conditions above.
+var jumps = map[string]string{
+	"CS": "CS", "B": "CS", "C": "CS", "LO": "CS", "NAE": "CS",
+	"CC": "CC", "AE": "CC", "NC": "CC", "HS": "CC", "NB": "CC",
+	"EQ": "EQ", "E": "EQ", "Z": "EQ",
+	"NE": "NE", "NZ": "NE",
+	"HI": "HI", "A": "HI", "NBE": "HI",
+	"LS": "LS", "BE": "LS", "NA": "LS",
+	"MI": "MI", "S": "MI",
+	"PL": "PL", "NS": "PL",
+	"OS": "OS", "O": "OS",
+	"OC": "OC", "NO": "OC",
+	"LT": "LT", "L": "LT", "NGE": "LT",
+	"GE": "GE", "NL": "GE",
+	"GT": "GT", "G": "GT", "NLE": "GT",
+	"LE": "LE", "NG": "LE",
+}
+
+// widths of the size suffixes of general purpose instructions.
+var widths = map[byte]int{'B': 1, 'W': 2, 'L': 4, 'Q': 8}
+
+// step executes i and returns the label it jumps to, ret for RET, or "" to carry on.
+func (m *Machine) step(i *ir.Instruction) (ir.Label, error) {
+	op := i.Opcode
+	switch {
+	case op == "RET":
+		return ret, nil
+	case op == "JMP":
+		return target(i)
+	case strings.HasPrefix(op, "J"):
+		cond, ok := jumps[op[1:]]
+		if !ok {
+			return "", ErrUnsupported
+		}
+		if conditions[cond](m.Flags) {
+			return target(i)
+		}
+		return "", nil
+	case vector(i):
+		return "", m.vector(i)
+	}
+	return "", m.scalar(i)
+}
+
+func target(i *ir.Instruction) (ir.Label, error) {
+	if len(i.Operands) != 1 {
+		return "", ErrUnsupported
+	}
+	l, ok := i.Operands[0].(operand.LabelRef)
+	if !ok {
+		return "", fmt.Errorf("%w: indirect jump", ErrUnsupported)
+	}
+	return ir.Label(l), nil
+}
+
+// scalar executes the general purpose instruction i.
+func (m *Machine) scalar(i *ir.Instruction) error {
+	op, ops := i.Opcode, i.Operands
+
+	// zero and sign extending moves, like MOVBQZX
+	if len(op) == 7 && op[:3] == "MOV" && (op[5:] == "ZX" || op[5:] == "SX") {
+		from, to := widths[op[3]], widths[op[4]]
+		if from == 0 || to == 0 || len(ops) != 2 {
+			return ErrUnsupported
+		}
+		v, err := m.read(ops[0], from)
+		if err != nil {
+			return err
+		}
+		if op[5] == 'S' {
+			v = signExtend(v, from)
+		}
+		return m.write(ops[1], to, v)
+	}
+	// conditional moves, like CMOVQLT
+	if strings.HasPrefix(op, "CMOV") && len(op) > 5 {
+		cond, ok := conditions[op[5:]]
+		w := widths[op[4]]
+		if !ok || w == 0 || len(ops) != 2 {
+			return ErrUnsupported
+		}
+		v, err := m.read(ops[0], w)
+		if err != nil {
+			return err
+		}
+		if !cond(m.Flags) {
+			// writing the destination back to itself, a 32 bit CMOV clears the upper half
+			// even when it doesn't move
+			if v, err = m.read(ops[1], w); err != nil {
+				return err
+			}
+		}
+		return m.write(ops[1], w, v)
+	}
+
+	w := widths[op[len(op)-1]]
+	if w == 0 {
+		return ErrUnsupported
+	}
+	base := op[:len(op)-1]
+	switch base {
+	case "MOV":
+		if len(ops) != 2 {
+			return ErrUnsupported
+		}
+		v, err := m.read(ops[0], w)
+		if err != nil {
+			return err
+		}
+		return m.write(ops[1], w, v)
+	case "LEA":
+		if len(ops) != 2 {
+			return ErrUnsupported
+		}
+		mem, ok := ops[0].(operand.Mem)
+		if !ok {
+			return ErrUnsupported
+		}
+		addr, err := m.address(mem)
+		if err != nil {
+			return err
+		}
+		return m.write(ops[1], w, addr)
+	case "ADD", "ADC", "ADCX", "ADOX":
+		return m.unary(ops, w, func(dst, src uint64) uint64 {
+			var carry uint64
+			switch {
+			case base == "ADC" && m.CF, base == "ADCX" && m.CF, base == "ADOX" && m.OF:
+				carry = 1
+			}
+			r, cf, of := add(dst, src, carry, w)
+			switch base {
+			case "ADCX":
+				m.CF = cf
+			case "ADOX":
+				m.OF = cf
+			default:
+				m.CF, m.OF = cf, of
+				m.result(r, w)
+			}
+			return r
+		})
+	case "SUB", "SBB":
+		return m.unary(ops, w, func(dst, src uint64) uint64 {
+			var borrow uint64
+			if base == "SBB" && m.CF {
+				borrow = 1
+			}
+			return m.sub(dst, src, borrow, w)
+		})
+	case "CMP":
+		// CMP is the one that's the other way around in Go's assembler: CMPQ a, b sets the
+		// flags of a - b
+		if len(ops) != 2 {
+			return ErrUnsupported
+		}
+		a, err := m.read(ops[0], w)
+		if err != nil {
+			return err
+		}
+		b, err := m.read(ops[1], w)
+		if err != nil {
+			return err
+		}
+		m.sub(a, b, 0, w)
+		return nil
+	case "AND", "OR", "XOR":
+		return m.unary(ops, w, func(dst, src uint64) uint64 {
+			r := logic(base, dst, src)
+			m.CF, m.OF = false, false
+			m.result(r, w)
+			return r
+		})
+	case "TEST":
+		if len(ops) != 2 {
+			return ErrUnsupported
+		}
+		a, err := m.read(ops[0], w)
+		if err != nil {
+			return err
+		}
+		b, err := m.read(ops[1], w)
+		if err != nil {
+			return err
+		}
+		m.CF, m.OF = false, false
+		m.result(a&b, w)
+		return nil
+	case "NOT", "NEG", "INC", "DEC", "BSWAP":
+		if len(ops) != 1 {
+			return ErrUnsupported
+		}
+		v, err := m.read(ops[0], w)
+		if err != nil {
+			return err
+		}
+		switch base {
+		case "NOT":
+			v = ^v
+		case "NEG":
+			v = m.sub(0, v, 0, w)
+		case "INC", "DEC":
+			cf := m.CF
+			if base == "INC" {
+				v, _, m.OF = add(v, 1, 0, w)
+				m.result(v, w)
+			} else {
+				v = m.sub(v, 1, 0, w)
+			}
+			m.CF = cf
+		case "BSWAP":
+			if w == 4 {
+				v = uint64(bits.ReverseBytes32(uint32(v)))
+			} else {
+				v = bits.ReverseBytes64(v)
+			}
+		}
+		return m.write(ops[0], w, v)
+	case "SHL", "SHR", "SAR", "ROL", "ROR":
+		return m.shift(base, ops, w)
+	case "BZHI":
+		if len(ops) != 3 {
+			return ErrUnsupported
+		}
+		index, err := m.read(ops[0], w)
+		if err != nil {
+			return err
+		}
+		v, err := m.read(ops[1], w)
+		if err != nil {
+			return err
+		}
+		n := index & 0xFF
+		if n < uint64(w*8) {
+			v &= 1<<n - 1
+		}
+		m.CF, m.OF = n > uint64(w*8-1), false
+		m.result(v, w)
+		return m.write(ops[2], w, v)
+	}
+	return ErrUnsupported
+}
+
+func logic(op string, a, b uint64) uint64 {
+	switch op {
+	case "AND":
+		return a & b
+	case "OR":
+		return a | b
+	}
+	return a ^ b
+}
+
+// unary runs dst = f(dst, src) for the two operand instructions.
+func (m *Machine) unary(ops []operand.Op, w int, f func(dst, src uint64) uint64) error {
+	if len(ops) != 2 {
+		return ErrUnsupported
+	}
+	src, err := m.read(ops[0], w)
+	if err != nil {
+		return err
+	}
+	dst, err := m.read(ops[1], w)
+	if err != nil {
+		return err
+	}
+	return m.write(ops[1], w, f(dst, src))
+}
+
+func (m *Machine) shift(op string, ops []operand.Op, w int) error {
+	if len(ops) != 2 {
+		// the three operand forms are SHLD and SHRD
+		return ErrUnsupported
+	}
+	count, err := m.read(ops[0], 1)
+	if err != nil {
+		return err
+	}
+	v, err := m.read(ops[1], w)
+	if err != nil {
+		return err
+	}
+	size := uint64(w * 8)
+	if w == 8 {
+		count &= 63
+	} else {
+		count &= 31
+	}
+	if count == 0 {
+		return nil
+	}
+	// OF is only defined for shifts and rotates by one, the others leave it alone
+	msb := func(v uint64) bool { return v>>(size-1)&1 == 1 }
+	var r uint64
+	switch op {
+	case "SHL":
+		r = mask(v<<count, w)
+		m.CF = count <= size && v>>(size-count)&1 == 1
+		if count == 1 {
+			m.OF = msb(r) != m.CF
+		}
+		m.result(r, w)
+	case "SHR":
+		r = v >> count
+		m.CF = count <= size && v>>(count-1)&1 == 1
+		if count == 1 {
+			m.OF = msb(v)
+		}
+		m.result(r, w)
+	case "SAR":
+		s := int64(signExtend(v, w))
+		if count >= 64 {
+			count = 63
+		}
+		r = mask(uint64(s>>count), w)
+		m.CF = uint64(s>>(count-1))&1 == 1
+		if count == 1 {
+			m.OF = false
+		}
+		m.result(r, w)
+	case "ROL", "ROR":
+		n := count % size
+		if op == "ROR" {
+			n = (size - n) % size
+		}
+		r = mask(v<<n|v>>(size-n), w)
+		if op == "ROL" {
+			m.CF = r&1 == 1
+		} else {
+			m.CF = msb(r)
+		}
+		if count == 1 && op == "ROL" {
+			m.OF = msb(r) != m.CF
+		} else if count == 1 {
+			m.OF = msb(r) != (r>>(size-2)&1 == 1)
+		}
+	}
+	return m.write(ops[1], w, r)
+}
+
+// add returns a + b + carry in w bytes, the carry out and the signed overflow.
+func add(a, b, carry uint64, w int) (r uint64, cf, of bool) {
+	size := uint(w * 8)
+	if w == 8 {
+		var c1, c2 uint64
+		r, c1 = bits.Add64(a, b, 0)
+		r, c2 = bits.Add64(r, carry, 0)
+		cf = c1|c2 == 1
+	} else {
+		full := a + b + carry
+		r = mask(full, w)
+		cf = full>>size != 0
+	}
+	sign := uint64(1) << (size - 1)
+	of = (a^r)&(b^r)&sign != 0
+	return r, cf, of
+}
+
+// sub sets the flags of a - b - borrow in w bytes and returns the result.
+func (m *Machine) sub(a, b, borrow uint64, w int) uint64 {
+	size := uint(w * 8)
+	r := mask(a-b-borrow, w)
+	m.CF = b > a || (borrow == 1 && b == a)
+	sign := uint64(1) << (size - 1)
+	m.OF = (a^b)&(a^r)&sign != 0
+	m.result(r, w)
+	return r
+}
+
+// result sets ZF and SF for r.
+func (m *Machine) result(r uint64, w int) {
+	m.ZF = mask(r, w) == 0
+	m.SF = r>>(w*8-1)&1 == 1
+}
+
+func mask(v uint64, w int) uint64 {
+	if w == 8 {
+		return v
+	}
+	return v & (1<<(w*8) - 1)
+}
+
+func signExtend(v uint64, w int) uint64 {
+	shift := 64 - w*8
+	return uint64(int64(v<<shift) >> shift)
+}
+
+// read returns the w bytes of op, zero extended.
+func (m *Machine) read(op operand.Op, w int) (uint64, error) {
+	switch op := op.(type) {
+	case reg.Register:
+		switch op.Kind() {
+		case reg.KindGP, reg.KindOpmask:
+			return m.Reg(op), nil
+		}
+		return 0, fmt.Errorf("%w: %s as an integer", ErrUnsupported, op.Asm())
+	case operand.Mem:
+		if name, ok := frame(op); ok {
+			v, ok := m.Args[name]
+			if !ok {
+				return 0, fmt.Errorf("argument %s not set", name)
+			}
+			return mask(v, w), nil
+		}
+		addr, err := m.address(op)
+		if err != nil {
+			return 0, err
+		}
+		b, err := m.memory(addr, w)
+		if err != nil {
+			return 0, err
+		}
+		return le(b), nil
+	case operand.Constant:
+		v, err := constant(op)
+		return mask(v, w), err
+	}
+	return 0, fmt.Errorf("%w: operand %s", ErrUnsupported, op.Asm())
+}
+
+// write stores the low w bytes of v to op.
+func (m *Machine) write(op operand.Op, w int, v uint64) error {
+	switch op := op.(type) {
+	case reg.Register:
+		switch op.Kind() {
+		case reg.KindGP, reg.KindOpmask:
+			m.SetReg(op, v)
+			return nil
+		}
+		return fmt.Errorf("%w: %s as an integer", ErrUnsupported, op.Asm())
+	case operand.Mem:
+		if name, ok := frame(op); ok {
+			m.Args[name] = mask(v, w)
+			return nil
+		}
+		addr, err := m.address(op)
+		if err != nil {
+			return err
+		}
+		b, err := m.memory(addr, w)
+		if err != nil {
+			return err
+		}
+		putLE(b, v)
+		return nil
+	}
+	return fmt.Errorf("%w: writing to %s", ErrUnsupported, op.Asm())
+}
+
+// address returns the effective address of mem.
+func (m *Machine) address(mem operand.Mem) (uint64, error) {
+	if mem.Symbol.Name != "" {
+		return 0, fmt.Errorf("%w: symbol %s", ErrUnsupported, mem.Symbol.Name)
+	}
+	addr := uint64(int64(mem.Disp))
+	if mem.Base != nil {
+		if _, err := key(mem.Base); err != nil {
+			return 0, err
+		}
+		addr += m.Reg(mem.Base)
+	}
+	if mem.Index != nil {
+		if _, err := key(mem.Index); err != nil {
+			return 0, err
+		}
+		addr += m.Reg(mem.Index) * uint64(mem.Scale)
+	}
+	return addr, nil
+}
+
+func constant(c operand.Constant) (uint64, error) {
+	switch c := c.(type) {
+	case operand.U8:
+		return uint64(c), nil
+	case operand.U16:
+		return uint64(c), nil
+	case operand.U32:
+		return uint64(c), nil
+	case operand.U64:
+		return uint64(c), nil
+	case operand.I8:
+		return uint64(int64(c)), nil
+	case operand.I16:
+		return uint64(int64(c)), nil
+	case operand.I32:
+		return uint64(int64(c)), nil
+	case operand.I64:
+		return uint64(c), nil
+	}
+	return 0, fmt.Errorf("%w: constant %s", ErrUnsupported, c.Asm())
+}
@@ -0,0 +1,429 @@
+package emu
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/mmcloughlin/avo/ir"
+	"github.com/mmcloughlin/avo/operand"
+	"github.com/mmcloughlin/avo/reg"
+)
+
+// sse are the legacy SSE instructions without a vector register operand to give them away,
+// AVX and AVX-512 ones start with V and the opmask ones with K.
+var sse = map[string]bool{"MOVO": true, "MOVOU": true, "MOVOA": true}
+
+// vector reports whether i is an SSE, AVX or AVX-512 instruction.
+func vector(i *ir.Instruction) bool {
+	if strings.HasPrefix(i.Opcode, "V") || strings.HasPrefix(i.Opcode, "K") || sse[i.Opcode] {
+		return true
+	}
+	for _, op := range i.Operands {
+		if r, ok := op.(reg.Register); ok && r.Kind() == reg.KindVector {
+			return true
+		}
+	}
+	return false
+}
+
+// vector executes the vector instruction i. The V ones (VEX and EVEX encoded) zero
+// everything above the register they write, the legacy SSE ones leave it alone.
+func (m *Machine) vector(i *ir.Instruction) error {
+	op, ops := i.Opcode, i.Operands
+	vex := strings.HasPrefix(op, "V")
+	name := strings.TrimPrefix(op, "V")
+
+	for _, o := range ops {
+		if r, ok := o.(reg.Register); ok && r.Kind() == reg.KindOpmask && op[0] != 'K' && !strings.HasPrefix(name, "MOVDQU") {
+			return fmt.Errorf("%w: masked %s", ErrUnsupported, op)
+		}
+	}
+
+	switch name {
+	case "ZEROUPPER", "ZEROALL":
+		for k, s := range m.regs {
+			if k.kind != reg.KindVector {
+				continue
+			}
+			if name == "ZEROALL" {
+				*s = [64]byte{}
+			} else {
+				clear(s[16:])
+			}
+		}
+		return nil
+
+	case "MOVO", "MOVOU", "MOVOA", "MOVDQU", "MOVDQA", "MOVUPS", "MOVAPS",
+		"MOVDQU8", "MOVDQU16", "MOVDQU32", "MOVDQU64", "MOVDQA32", "MOVDQA64":
+		if len(ops) == 3 {
+			return m.maskedMove(i)
+		}
+		if len(ops) != 2 {
+			return ErrUnsupported
+		}
+		n := width(ops...)
+		src, err := m.vec(ops[0], n)
+		if err != nil {
+			return err
+		}
+		return m.setVec(ops[1], src, vex)
+
+	case "MOVQ", "MOVL", "MOVD":
+		return m.moveScalar(name, ops, vex)
+
+	case "KMOVQ", "KMOVD", "KMOVW", "KMOVB":
+		w := widths[op[len(op)-1]]
+		if len(ops) != 2 {
+			return ErrUnsupported
+		}
+		v, err := m.read(ops[0], w)
+		if err != nil {
+			return err
+		}
+		return m.write(ops[1], w, v)
+
+	case "PADDB", "PADDW", "PADDL", "PADDD", "PADDQ", "PSUBB", "PSUBW", "PSUBL", "PSUBD", "PSUBQ",
+		"PAND", "PANDD", "PANDQ", "ANDPS", "POR", "PORD", "PORQ", "ORPS",
+		"PXOR", "PXORD", "PXORQ", "XORPS", "PCMPEQB", "PCMPEQW", "PCMPEQL", "PCMPEQD", "PCMPEQQ":
+		return m.lanes(name, ops, vex)
+
+	case "PSRLW", "PSRLL", "PSRLD", "PSRLQ", "PSLLW", "PSLLL", "PSLLD", "PSLLQ":
+		return m.shiftLanes(name, ops, vex)
+
+	case "PSHUFD":
+		if len(ops) != 3 {
+			return ErrUnsupported
+		}
+		imm, err := m.read(ops[0], 1)
+		if err != nil {
+			return err
+		}
+		n := width(ops[2])
+		src, err := m.vec(ops[1], n)
+		if err != nil {
+			return err
+		}
+		dst := make([]byte, n)
+		for lane := 0; lane < n; lane += 16 {
+			for d := 0; d < 4; d++ {
+				s := int(imm>>(2*d)) & 3
+				copy(dst[lane+4*d:lane+4*d+4], src[lane+4*s:])
+			}
+		}
+		return m.setVec(ops[2], dst, vex)
+
+	case "PUNPCKLWL", "PUNPCKHWL", "PUNPCKLWD", "PUNPCKHWD":
+		// interleave the words of the low or high half of every 16 byte lane,
+		// destination first
+		a, b, dst, err := m.operands3(ops, vex)
+		if err != nil {
+			return err
+		}
+		n := width(dst)
+		half := 0
+		if strings.HasPrefix(name, "PUNPCKH") {
+			half = 8
+		}
+		r := make([]byte, n)
+		for lane := 0; lane < n; lane += 16 {
+			for w := 0; w < 4; w++ {
+				copy(r[lane+4*w:lane+4*w+2], b[lane+half+2*w:])
+				copy(r[lane+4*w+2:lane+4*w+4], a[lane+half+2*w:])
+			}
+		}
+		return m.setVec(dst, r, vex)
+
+	case "PMOVZXWD", "PMOVZXBW", "PMOVZXDQ":
+		if len(ops) != 2 {
+			return ErrUnsupported
+		}
+		from := map[string]int{"PMOVZXWD": 2, "PMOVZXBW": 1, "PMOVZXDQ": 4}[name]
+		n := width(ops[1])
+		src, err := m.vec(ops[0], n/2)
+		if err != nil {
+			return err
+		}
+		dst := make([]byte, n)
+		for e := 0; e < n/(2*from); e++ {
+			copy(dst[2*from*e:], src[from*e:from*e+from])
+		}
+		return m.setVec(ops[1], dst, vex)
+
+	case "EXTRACTI128", "EXTRACTI32X4", "EXTRACTI64X4", "EXTRACTF128":
+		if len(ops) != 3 {
+			return ErrUnsupported
+		}
+		imm, err := m.read(ops[0], 1)
+		if err != nil {
+			return err
+		}
+		n := 16
+		if name == "EXTRACTI64X4" {
+			n = 32
+		}
+		src, err := m.vec(ops[1], width(ops[1]))
+		if err != nil {
+			return err
+		}
+		off := int(imm) * n % len(src)
+		return m.setVec(ops[2], src[off:off+n], vex)
+
+	case "PTERNLOGD", "PTERNLOGQ":
+		if len(ops) != 4 {
+			return ErrUnsupported
+		}
+		imm, err := m.read(ops[0], 1)
+		if err != nil {
+			return err
+		}
+		n := width(ops[3])
+		c, err := m.vec(ops[1], n)
+		if err != nil {
+			return err
+		}
+		b, err := m.vec(ops[2], n)
+		if err != nil {
+			return err
+		}
+		a, err := m.vec(ops[3], n)
+		if err != nil {
+			return err
+		}
+		r := make([]byte, n)
+		for i := range r {
+			for bit := 0; bit < 8; bit++ {
+				idx := (a[i]>>bit&1)<<2 | (b[i]>>bit&1)<<1 | c[i]>>bit&1
+				r[i] |= byte(imm>>idx&1) << bit
+			}
+		}
+		return m.setVec(ops[3], r, vex)
+	}
+	return ErrUnsupported
+}
+
+// lanes executes the element wise instructions, dst = b op a with b the destination
+// itself for the two operand SSE forms.
+func (m *Machine) lanes(name string, ops []operand.Op, vex bool) error {
+	a, b, dst, err := m.operands3(ops, vex)
+	if err != nil {
+		return err
+	}
+	size := map[byte]int{'B': 1, 'W': 2, 'L': 4, 'D': 4, 'Q': 8}[name[len(name)-1]]
+	switch {
+	case strings.HasSuffix(name, "PS"), strings.HasPrefix(name, "PAND"), strings.HasPrefix(name, "POR"), strings.HasPrefix(name, "PXOR"):
+		size = 8
+	}
+	r := make([]byte, len(b))
+	for e := 0; e < len(r); e += size {
+		x, y := le(b[e:e+size]), le(a[e:e+size])
+		var v uint64
+		switch {
+		case strings.HasPrefix(name, "PADD"):
+			v = x + y
+		case strings.HasPrefix(name, "PSUB"):
+			v = x - y
+		case strings.HasPrefix(name, "PAND"), name == "ANDPS":
+			v = x & y
+		case strings.HasPrefix(name, "POR"), name == "ORPS":
+			v = x | y
+		case strings.HasPrefix(name, "PXOR"), name == "XORPS":
+			v = x ^ y
+		case strings.HasPrefix(name, "PCMPEQ"):
+			if x == y {
+				v = ^uint64(0)
+			}
+		}
+		putLE(r[e:e+size], v)
+	}
+	return m.setVec(dst, r, vex)
+}
+
+// shiftLanes executes the shifts by an immediate, which clear lanes shifted by their
+// width or more.
+func (m *Machine) shiftLanes(name string, ops []operand.Op, vex bool) error {
+	if _, ok := ops[0].(operand.Constant); !ok {
+		return fmt.Errorf("%w: %s by a register", ErrUnsupported, name)
+	}
+	count, err := m.read(ops[0], 1)
+	if err != nil {
+		return err
+	}
+	dst := ops[len(ops)-1]
+	src, err := m.vec(ops[len(ops)-2], width(dst))
+	if err != nil {
+		return err
+	}
+	size := map[byte]int{'W': 2, 'L': 4, 'D': 4, 'Q': 8}[name[len(name)-1]]
+	r := make([]byte, len(src))
+	if count < uint64(size*8) {
+		for e := 0; e < len(r); e += size {
+			v := le(src[e : e+size])
+			if strings.HasPrefix(name, "PSRL") {
+				v >>= count
+			} else {
+				v <<= count
+			}
+			putLE(r[e:e+size], v)
+		}
+	}
+	return m.setVec(dst, r, vex)
+}
+
+// operands3 reads the sources of the three operand VEX forms and the two operand SSE
+// ones, where the destination is the second source.
+func (m *Machine) operands3(ops []operand.Op, vex bool) (a, b []byte, dst operand.Op, err error) {
+	switch {
+	case vex && len(ops) == 3, !vex && len(ops) == 2:
+	default:
+		return nil, nil, nil, ErrUnsupported
+	}
+	dst = ops[len(ops)-1]
+	n := width(dst)
+	if a, err = m.vec(ops[0], n); err != nil {
+		return nil, nil, nil, err
+	}
+	b, err = m.vec(ops[len(ops)-2], n)
+	return a, b, dst, err
+}
+
+// maskedMove executes the masked moves, like VMOVDQU8.Z (AX), K1, Z3: only the bytes
+// the mask selects are read, so a masked load past the end of a buffer doesn't fault.
+func (m *Machine) maskedMove(i *ir.Instruction) error {
+	ops := i.Operands
+	k, ok := ops[1].(reg.Register)
+	if !ok || k.Kind() != reg.KindOpmask || i.Opcode != "VMOVDQU8" {
+		return fmt.Errorf("%w: masked %s", ErrUnsupported, i.Opcode)
+	}
+	zeroing := false
+	for _, s := range i.Suffixes {
+		zeroing = zeroing || s == "Z"
+	}
+	mask := m.Reg(k)
+	n := width(ops[0], ops[2])
+	r, err := m.vec(ops[2], n)
+	if err != nil {
+		return err
+	}
+	mem, isMem := ops[0].(operand.Mem)
+	var src []byte
+	if !isMem {
+		if src, err = m.vec(ops[0], n); err != nil {
+			return err
+		}
+	}
+	for e := 0; e < n; e++ {
+		switch {
+		case mask>>e&1 == 1 && isMem:
+			addr, err := m.address(mem)
+			if err != nil {
+				return err
+			}
+			b, err := m.memory(addr+uint64(e), 1)
+			if err != nil {
+				return err
+			}
+			r[e] = b[0]
+		case mask>>e&1 == 1:
+			r[e] = src[e]
+		case zeroing:
+			r[e] = 0
+		}
+	}
+	if _, ok := ops[2].(operand.Mem); ok {
+		return fmt.Errorf("%w: masked store", ErrUnsupported)
+	}
+	return m.setVec(ops[2], r, true)
+}
+
+// moveScalar executes MOVQ, MOVL and their VEX forms between vector registers and
+// general purpose registers or memory.
+func (m *Machine) moveScalar(name string, ops []operand.Op, vex bool) error {
+	if len(ops) != 2 {
+		return ErrUnsupported
+	}
+	w := 8
+	if name != "MOVQ" {
+		w = 4
+	}
+	var v uint64
+	if r, ok := ops[0].(reg.Register); ok && r.Kind() == reg.KindVector {
+		b, err := m.vec(r, w)
+		if err != nil {
+			return err
+		}
+		v = le(b)
+	} else {
+		var err error
+		if v, err = m.read(ops[0], w); err != nil {
+			return err
+		}
+	}
+	if r, ok := ops[1].(reg.Register); ok && r.Kind() == reg.KindVector {
+		b := make([]byte, 16)
+		putLE(b[:w], v)
+		return m.setVec(r, b, vex)
+	}
+	return m.write(ops[1], w, v)
+}
+
+// width returns the size of the widest vector register among ops, 16 if there's none.
+func width(ops ...operand.Op) int {
+	n := 0
+	for _, op := range ops {
+		if r, ok := op.(reg.Register); ok && r.Kind() == reg.KindVector && int(r.Size()) > n {
+			n = int(r.Size())
+		}
+	}
+	if n == 0 {
+		n = 16
+	}
+	return n
+}
+
+// vec returns n bytes of the vector register or memory op, or an immediate.
+func (m *Machine) vec(op operand.Op, n int) ([]byte, error) {
+	switch op := op.(type) {
+	case reg.Register:
+		if op.Kind() != reg.KindVector {
+			return nil, fmt.Errorf("%w: %s as a vector", ErrUnsupported, op.Asm())
+		}
+		return bytes.Clone(m.storage(op)[:n]), nil
+	case operand.Mem:
+		addr, err := m.address(op)
+		if err != nil {
+			return nil, err
+		}
+		b, err := m.memory(addr, n)
+		return bytes.Clone(b), err
+	}
+	return nil, fmt.Errorf("%w: operand %s", ErrUnsupported, op.Asm())
+}
+
+// setVec writes b to the vector register or memory op.
+func (m *Machine) setVec(op operand.Op, b []byte, vex bool) error {
+	switch op := op.(type) {
+	case reg.Register:
+		if op.Kind() != reg.KindVector {
+			return fmt.Errorf("%w: %s as a vector", ErrUnsupported, op.Asm())
+		}
+		s := m.storage(op)
+		if vex {
+			*s = [64]byte{}
+		}
+		copy(s[:], b)
+		return nil
+	case operand.Mem:
+		addr, err := m.address(op)
+		if err != nil {
+			return err
+		}
+		dst, err := m.memory(addr, len(b))
+		if err != nil {
+			return err
+		}
+		copy(dst, b)
+		return nil
+	}
+	return fmt.Errorf("%w: writing to %s", ErrUnsupported, op.Asm())
+}
@@ -0,0 +1,74 @@
+package gen
+
+import (
+	"github.com/mmcloughlin/avo/operand"
+	"github.com/mmcloughlin/avo/reg"
+)
+
+// Fold32 folds the 64-bit ones' complement sum in sum into its lower 32 bits,
+// the carry out of ADDL wraps around via ADCL. tmp is clobbered.
+func (f *Func) Fold32(sum, tmp reg.GPVirtual) {
+	f.MOVQ(sum, tmp)
+	f.SHRQ(operand.Imm(32), tmp)
+	f.ADDL(View(tmp, 32), View(sum, 32))
+	f.ADCL(operand.Imm(0), View(sum, 32))
+}
+
+// Fold16 folds the 64-bit ones' complement sum in sum down to its lower 16 bits,
+// wrapping the carries back around. tmp is clobbered.
+func (f *Func) Fold16(sum, tmp reg.GPVirtual) {
+	// 64 --> 32
+	f.Fold32(sum, tmp)
+	// 32 --> 16
+	f.MOVL(View(sum, 32), View(tmp, 32))
+	f.SHRL(operand.Imm(16), View(tmp, 32))
+	f.ADDW(View(tmp, 16), View(sum, 16))
+	f.ADCW(operand.Imm(0), View(sum, 16))
+}
+
+// SwapComplement16 turns the folded little endian sum in the low 16 bits of sum into a
+// checksum: swap the bytes back into network byte order and complement.
+func (f *Func) SwapComplement16(sum reg.GPVirtual) {
+	f.ROLW(operand.Imm(8), View(sum, 16))
+	f.NOTW(View(sum, 16))
+}
+
+// FoldLanes folds the carries of every 32-bit lane of the YMM or ZMM register acc back
+// into its lower 16 bits. mask must hold 0x0000FFFF in every lane, tmp is clobbered.
+func (f *Func) FoldLanes(acc, mask, tmp reg.VecVirtual) {
+	f.VPSRLD(operand.Imm(16), acc, tmp)
+	if acc.Size() == 64 {
+		// there's no VEX encoded VPAND for ZMM registers
+		f.VPANDD(mask, acc, acc)
+	} else {
+		f.VPAND(mask, acc, acc)
+	}
+	f.VPADDD(tmp, acc, acc)
+}
+
+// FoldLanesSSE is FoldLanes for XMM registers with two operand SSE2 instructions.
+func (f *Func) FoldLanesSSE(acc, mask, tmp reg.VecVirtual) {
+	f.MOVO(acc, tmp)
+	f.PSRLL(operand.Imm(16), tmp)
+	f.PAND(mask, acc)
+	f.PADDL(tmp, acc)
+}
+
+// ReduceLanes adds up the 32-bit lanes of the YMM or ZMM register acc into its lowest
+// lane. The lanes have to be folded, so that the sum can't overflow. tmp is clobbered.
+func (f *Func) ReduceLanes(acc, tmp reg.VecVirtual) {
+	if acc.Size() == 64 {
+		// 512 --> 256
+		f.VEXTRACTI64X4(operand.Imm(1), acc, tmp.AsY())
+		f.VPADDD(tmp.AsY(), acc.AsY(), acc.AsY())
+	}
+	// 256 --> 128: add the high 128 bits to the low 128 bits
+	f.VEXTRACTI128(operand.Imm(1), acc.AsY(), tmp.AsX())
+	f.VPADDD(tmp.AsX(), acc.AsX(), acc.AsX())
+	// 128 --> 64: swap the quadwords and add
+	f.VPSHUFD(operand.Imm(0x4E), acc.AsX(), tmp.AsX())
+	f.VPADDD(tmp.AsX(), acc.AsX(), acc.AsX())
+	// 64 --> 32: swap the doublewords and add
+	f.VPSHUFD(operand.Imm(0xB1), acc.AsX(), tmp.AsX())
+	f.VPADDD(tmp.AsX(), acc.AsX(), acc.AsX())
+}
@@ -0,0 +1,180 @@
+package gen
+
+import (
+	"fmt"
+
+	"github.com/mmcloughlin/avo/build"
+	"github.com/mmcloughlin/avo/ir"
+	"github.com/mmcloughlin/avo/operand"
+	"github.com/mmcloughlin/avo/reg"
+)
+
+// Label is an assembly label. Labels are scoped to their function, so every kernel of a
+// file can have its own early_fail.
+type Label string
+
+// Ref returns the operand for jumping to l.
+func (l Label) Ref() operand.LabelRef {
+	return operand.LabelRef(l)
+}
+
+// Func is a function being built. The instruction methods of the embedded build.Context
+// emit into it, like f.ADDQ(word, sum), those Func shadows noting where they were called.
+type Func struct {
+	*build.Context
+	Name string
+
+	file *File
+	fn   *ir.Function // the function of the context, nil if it couldn't be found
+
+	// note that because we're in go generate,
+	// we don't need to worry about synchronization with regard to map access.
+	regs map[string]reg.GPVirtual
+}
+
+// Label places l here.
+func (f *Func) Label(l Label) {
+	f.Context.Label(string(l))
+}
+
+// Block places l and builds the code that follows it with body, returning l so that
+// loops can jump back to it.
+func (f *Func) Block(l Label, body func()) Label {
+	f.Label(l)
+	body()
+	return l
+}
+
+// Reg returns the general purpose register called name, allocating a virtual register
+// of bits (8, 16, 32 or 64) bits the first time the name comes up. Later calls return the
+// bits wide view of that same register.
+func (f *Func) Reg(name string, bits int) reg.GPVirtual {
+	if r, ok := f.regs[name]; ok {
+		return View(r, bits)
+	}
+	var r reg.GPVirtual
+	switch bits {
+	case 8:
+		r = f.GP8()
+	case 16:
+		r = f.GP16()
+	case 32:
+		r = f.GP32()
+	case 64:
+		r = f.GP64()
+	default:
+		panic(fmt.Sprintf("gen: %d bit register %q", bits, name))
+	}
+	f.regs[name] = r
+	return r
+}
+
+// Alias gives r the name name, for aliases and views of registers allocated elsewhere.
+func (f *Func) Alias(name string, r reg.GPVirtual) reg.GPVirtual {
+	f.regs[name] = r
+	return r
+}
+
+// R returns the register called name at the size it was allocated or aliased with.
+// It panics if there's no such register, a typo shouldn't make it into the assembly.
+func (f *Func) R(name string) reg.GPVirtual {
+	r, ok := f.regs[name]
+	if !ok {
+		panic(fmt.Sprintf("gen: %s has no register called %q", f.Name, name))
+	}
+	return r
+}
+
+// View returns the bits wide view of the register called name, see View.
+func (f *Func) View(name string, bits int) reg.GPVirtual {
+	return View(f.R(name), bits)
+}
+
+// View returns the 8, 16, 32 or 64 bit view of r: the low byte, word, doubleword or
+// the whole thing.
+func View(r reg.GPVirtual, bits int) reg.GPVirtual {
+	switch bits {
+	case 8:
+		return r.As8().(reg.GPVirtual)
+	case 16:
+		return r.As16().(reg.GPVirtual)
+	case 32:
+		return r.As32().(reg.GPVirtual)
+	case 64:
+		return r.As64().(reg.GPVirtual)
+	}
+	panic(fmt.Sprintf("gen: no %d bit view of a general purpose register", bits))
+}
+
+// Slice is a []byte parameter loaded into registers.
+type Slice struct {
+	// Data points at the first byte, add to Data.Base to move along.
+	Data operand.Mem
+	Len  reg.GPVirtual
+}
+
+// LoadSlice loads the pointer and the length of the []byte parameter called name into
+// two new 64-bit registers, in that order.
+func (f *Func) LoadSlice(name string) Slice {
+	p := f.Param(name)
+	base := f.Load(p.Base(), f.GP64())
+	length := f.Load(p.Len(), f.GP64())
+	return Slice{Data: operand.Mem{Base: base}, Len: length.(reg.GPVirtual)}
+}
+
+// Advance moves s forward by n bytes: data += n, len -= n.
+func (f *Func) Advance(s Slice, n int) {
+	f.ADDQ(operand.Imm(uint64(n)), s.Data.Base)
+	f.SUBQ(operand.Imm(uint64(n)), s.Len)
+}
+
+// Zero clears r, general purpose or vector.
+func (f *Func) Zero(r reg.Register) {
+	switch r.Size() * 8 {
+	case 8:
+		f.XORB(r, r)
+	case 16:
+		f.XORW(r, r)
+	case 32:
+		f.XORL(r, r)
+	case 64:
+		f.XORQ(r, r)
+	case 128, 256:
+		f.VXORPS(r, r, r)
+	case 512:
+		f.VPXORD(r, r, r)
+	default:
+		panic(fmt.Sprintf("gen: can't zero a %d byte register", r.Size()))
+	}
+}
+
+// JumpIfZero jumps to l if r is zero.
+func (f *Func) JumpIfZero(r reg.Register, l Label) {
+	f.TESTQ(r, r)
+	f.JZ(l.Ref())
+}
+
+// JumpIfBelow jumps to l if the unsigned r is less than n, the check at the top of
+// every loop over a remaining length.
+func (f *Func) JumpIfBelow(r reg.Register, n int, l Label) {
+	f.CMPQ(r, operand.Imm(uint64(n)))
+	f.JB(l.Ref())
+}
+
+// Jump jumps to l.
+func (f *Func) Jump(l Label) {
+	f.JMP(l.Ref())
+}
+
+// Return16 stores the low 16 bits of r as the first result and returns.
+func (f *Func) Return16(r reg.GPVirtual) {
+	f.Store(View(r, 16), f.ReturnIndex(0))
+	f.RET()
+}
+
+// ReturnZero16 is the early_fail block of the checksum kernels: rfc1071 says zero for
+// no data, not 0xFFFF. r is clobbered.
+func (f *Func) ReturnZero16(r reg.GPVirtual) {
+	f.Zero(View(r, 16))
+	f.Return16(r)
+}
@@ -0,0 +1,226 @@
+// Package gen is the avo toolkit shared by the kernel generators: a File per generated
+// assembly and stub pair, built on its own build.Context, and functions with typed labels,
+// named registers and the prologue, fold and epilogue pieces every checksum kernel repeats.
+//
+// A generator builds its functions one after the other and writes them out:
+//
+//	file := gen.NewFile("asm", "amd64,!purego")
+//	f := file.Function("checksumScalar", "func(data []byte) uint16", "", 0)
+//	data := f.LoadSlice("data")
+//	f.JumpIfZero(data.Len, earlyFail)
+//	...
+//	file.Main()
+//
+// Functions of one File share its context, so only the last one returned by Function
+// can be built on.
+package gen
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mmcloughlin/avo/attr"
+	"github.com/mmcloughlin/avo/build"
+	"github.com/mmcloughlin/avo/ir"
+	"github.com/mmcloughlin/avo/pass"
+	"github.com/mmcloughlin/avo/printer"
+	"github.com/mmcloughlin/avo/reg"
+)
+
+// File is one .s file and its Go stubs.
+type File struct {
+	// Argv is the command recorded in the "Code generated" header,
+	// Main defaults it to the go run command line of the generator.
+	Argv []string
+	// Annotate ends every instruction of the printed assembly with a comment naming the
+	// generator line that emitted it, see Origin.
+	Annotate bool
+
+	ctx      *build.Context
+	pkg      string
+	funcs    []*Func
+	compiled *ir.File
+	origins  map[*ir.Instruction]Origin
+}
+
+// NewFile returns an empty file for the Go package pkg, built only under the constraint
+// expression if it isn't empty. avo parses it in the "// +build" syntax, like "amd64,!purego",
+// and prints it as a //go:build line.
+func NewFile(pkg, constraint string) *File {
+	ctx := build.NewContext()
+	if constraint != "" {
+		ctx.ConstraintExpr(constraint)
+	}
+	return &File{ctx: ctx, pkg: pkg, origins: make(map[*ir.Instruction]Origin)}
+}
+
+// Function starts a new function in f, declared with the Go signature (like
+// "func(data []byte) uint16") and documented with doc in the stubs if it isn't empty.
+func (f *File) Function(name, signature, doc string, attrs attr.Attribute) *Func {
+	if f.compiled != nil {
+		panic("gen: function " + name + " added to a compiled file")
+	}
+	f.ctx.Function(name)
+	f.ctx.Attributes(attrs)
+	f.ctx.SignatureExpr(signature)
+	if doc != "" {
+		f.ctx.Doc(doc)
+	}
+	fn := &Func{Context: f.ctx, Name: name, regs: make(map[string]reg.GPVirtual), file: f}
+	if file, _ := f.ctx.Result(); file != nil && len(file.Functions()) > 0 {
+		fn.fn = file.Functions()[len(file.Functions())-1]
+	}
+	f.funcs = append(f.funcs, fn)
+	return fn
+}
+
+// Functions returns the functions of f in the order they were added.
+func (f *File) Functions() []*Func {
+	return f.funcs
+}
+
+// IR returns f as built so far. Until Compile its registers are virtual, which the emu
+// package runs just as well as physical ones; Compile works on the same IR in place.
+func (f *File) IR() (*ir.File, error) {
+	return f.ctx.Result()
+}
+
+// Lookup returns the function called name of file, or nil.
+func Lookup(file *ir.File, name string) *ir.Function {
+	for _, fn := range file.Functions() {
+		if fn.Name == name {
+			return fn
+		}
+	}
+	return nil
+}
+
+// Compile runs avo's compilation passes (register allocation among them) over f and
+// returns the result. Only the first call compiles, f can't be added to afterwards.
+func (f *File) Compile() (*ir.File, error) {
+	if f.compiled != nil {
+		return f.compiled, nil
+	}
+	file, err := f.ctx.Result()
+	if err != nil {
+		return nil, err
+	}
+	if err := pass.Compile.Execute(file); err != nil {
+		return nil, err
+	}
+	f.compiled = file
+	return file, nil
+}
+
+// Generate compiles f, then writes the assembly to asm and the Go declarations to stubs.
+// Either name may be empty to skip that file.
+func (f *File) Generate(asm, stubs string) error {
+	outputs, err := f.Print()
+	if err != nil {
+		return err
+	}
+	for _, out := range []struct {
+		name string
+		b    []byte
+	}{{asm, outputs.Asm}, {stubs, outputs.Stubs}} {
+		if out.name == "" {
+			continue
+		}
+		if err := os.WriteFile(out.name, out.b, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Output is a printed File.
+type Output struct {
+	Asm   []byte
+	Stubs []byte
+	// Origins is where each line of Asm was emitted, for the instructions that know.
+	Origins Origins
+}
+
+// Print compiles f and returns the assembly and stubs without writing them anywhere.
+func (f *File) Print() (Output, error) {
+	file, err := f.Compile()
+	if err != nil {
+		return Output{}, err
+	}
+	cfg := printer.Config{Argv: f.Argv, Pkg: f.pkg}
+	if cfg.Argv == nil {
+		cfg.Name = "gen"
+	}
+	var out Output
+	if out.Asm, err = printer.NewGoAsm(cfg).Print(file); err != nil {
+		return Output{}, err
+	}
+	if out.Asm, out.Origins, err = f.lineUp(file, out.Asm); err != nil {
+		return Output{}, err
+	}
+	if out.Stubs, err = printer.NewStubs(cfg).Print(file); err != nil {
+		return Output{}, err
+	}
+	return out, nil
+}
+
+// WriteOrigins writes the Origins of f printed to name as JSON.
+func (f *File) WriteOrigins(name string) error {
+	outputs, err := f.Print()
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(outputs.Origins, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(b, '\n'), 0644)
+}
+
+// Main is the body of a generator run by go generate: it writes f to the files named by
+// the -out and -stubs flags, and exits with status 1 if that fails. The -origins flag
+// names a JSON file to write where each line of the assembly was emitted to, -annotate
+// puts that at the end of the lines themselves.
+//
+// The flags are parsed from os.Args on a FlagSet of their own, avo's build package
+// already defines -out and -stubs on flag.CommandLine.
+func (f *File) Main() {
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	out := fs.String("out", "", "assembly output file")
+	stubs := fs.String("stubs", "", "go stub output file")
+	origins := fs.String("origins", "", "JSON output file mapping assembly lines to the generator lines that emitted them")
+	fs.BoolVar(&f.Annotate, "annotate", f.Annotate, "end every instruction with a comment naming the generator line that emitted it")
+	fs.Parse(os.Args[1:])
+	if f.Argv == nil {
+		f.Argv = withoutOrigins(printer.NewGoRunConfig().Argv)
+	}
+	err := f.Generate(*out, *stubs)
+	if err == nil && *origins != "" {
+		err = f.WriteOrigins(*origins)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, strings.TrimSpace(err.Error()))
+		os.Exit(1)
+	}
+}
+
+// withoutOrigins returns argv without its -origins flag, which writes neither of the
+// files the header goes in, so that asking for origins doesn't change them.
+func withoutOrigins(argv []string) []string {
+	var out []string
+	for i := 0; i < len(argv); i++ {
+		switch name := strings.TrimLeft(argv[i], "-"); {
+		case !strings.HasPrefix(argv[i], "-"):
+		case name == "origins":
+			i++
+			continue
+		case strings.HasPrefix(name, "origins="):
+			continue
+		}
+		out = append(out, argv[i])
+	}
+	return out
+}
@@ -0,0 +1,187 @@
+package gen
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// sum16 builds a scalar checksum of whole 16-bit words into file, the smallest kernel
+// that goes through every helper of a real one.
+func sum16(file *File, name string) *Func {
+	const (
+		loop      Label = "loop"
+		done      Label = "done"
+		earlyFail Label = "early_fail"
+	)
+	f := file.Function(name, "func(data []byte) uint16", "sums data as little endian words", 0)
+	data := f.LoadSlice("data")
+	f.JumpIfZero(data.Len, earlyFail)
+	sum := f.Reg("sum", 64)
+	f.Zero(sum)
+	word := f.Reg("word", 64)
+	f.Block(loop, func() {
+		f.JumpIfBelow(data.Len, 2, done)
+		f.MOVWQZX(data.Data, word)
+		f.ADDQ(word, sum)
+		f.Advance(data, 2)
+		f.Jump(loop)
+	})
+	f.Label(done)
+	f.Fold16(sum, f.Reg("tmp", 64))
+	f.SwapComplement16(sum)
+	f.Return16(sum)
+	f.Label(earlyFail)
+	f.ReturnZero16(sum)
+	return f
+}
+
+func TestPrint(t *testing.T) {
+	file := NewFile("kernels", "amd64,!purego")
+	sum16(file, "sumA")
+	sum16(file, "sumB")
+	if len(file.Functions()) != 2 {
+		t.Fatalf("Expected 2 functions, but got %d", len(file.Functions()))
+	}
+
+	out, err := file.Print()
+	if err != nil {
+		t.Fatal(err)
+	}
+	asm, stubs := string(out.Asm), string(out.Stubs)
+	for _, want := range []string{
+		"//go:build amd64 && !purego", "TEXT ·sumA(SB)", "TEXT ·sumB(SB)",
+		"early_fail:", "JB", "ROLW", "NOTW", "RET",
+	} {
+		if !strings.Contains(asm, want) {
+			t.Errorf("Expected %q in the assembly:\n%s", want, asm)
+		}
+	}
+	for _, want := range []string{
+		"package kernels", "// sums data as little endian words",
+		"func sumA(data []byte) uint16", "func sumB(data []byte) uint16",
+	} {
+		if !strings.Contains(stubs, want) {
+			t.Errorf("Expected %q in the stubs:\n%s", want, stubs)
+		}
+	}
+	if strings.Count(asm, "early_fail:") != 2 {
+		t.Errorf("Expected every function to get its own early_fail:\n%s", asm)
+	}
+}
+
+func TestIndependentFiles(t *testing.T) {
+	// files don't share a context, so one can be printed while the other is still being built
+	a, b := NewFile("a", ""), NewFile("b", "")
+	sum16(a, "sum")
+	sum16(b, "sum")
+	first, err := a.Print()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum16(b, "sumAgain")
+	second, err := b.Print()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(first.Asm), "go:build") {
+		t.Errorf("Expected no build constraint without one:\n%s", first.Asm)
+	}
+	if !strings.Contains(string(second.Stubs), "package b") {
+		t.Errorf("Expected the stubs of package b:\n%s", second.Stubs)
+	}
+	again, err := a.Print()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again.Asm) != string(first.Asm) {
+		t.Errorf("printing twice differs:\n%s\n%s", first.Asm, again.Asm)
+	}
+}
+
+func TestRegisters(t *testing.T) {
+	f := NewFile("a", "").Function("regs", "func()", "", 0)
+	r := f.Reg("r", 64)
+	if f.Reg("r", 16) != View(r, 16) || f.View("r", 8) != View(r, 8) || f.R("r") != r {
+		t.Errorf("the views of r aren't the views of the register it was allocated with")
+	}
+	f.Alias("low", View(r, 32))
+	if f.R("low") != View(r, 32) {
+		t.Errorf("the alias doesn't name the register it was given")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected a panic for an unknown register")
+		}
+	}()
+	f.R("typo")
+}
+
+func TestFileMain(t *testing.T) {
+	// Main runs as the generator would, in a process of its own with avo's flags
+	// registered on flag.CommandLine, the arguments after -- are its own
+	if i := slices.Index(os.Args, "--"); i >= 0 && os.Getenv("GEN_TEST_MAIN") == "1" {
+		os.Args = append(os.Args[:1:1], os.Args[i+1:]...)
+		file := NewFile("kernels", "amd64")
+		sum16(file, "sum")
+		file.Main()
+		return
+	}
+
+	main := func(args ...string) ([]byte, error) {
+		cmd := exec.Command(os.Args[0], append([]string{"-test.run=^TestFileMain$", "--"}, args...)...)
+		cmd.Env = append(os.Environ(), "GEN_TEST_MAIN=1")
+		return cmd.CombinedOutput()
+	}
+
+	dir := t.TempDir()
+	asm, stubs, origins := filepath.Join(dir, "sum_amd64.s"), filepath.Join(dir, "sum_amd64.go"), filepath.Join(dir, "origins.json")
+	if out, err := main("-out", asm, "-stubs", stubs, "-origins", origins, "-annotate"); err != nil {
+		t.Fatalf("%v:\n%s", err, out)
+	}
+	for name, want := range map[string]string{
+		asm:     "TEXT ·sum(SB)",
+		stubs:   "func sum(data []byte) uint16",
+		origins: `"file"`,
+	} {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(b), want) {
+			t.Errorf("Expected %q in %s:\n%s", want, filepath.Base(name), b)
+		}
+	}
+	if b, _ := os.ReadFile(asm); !strings.Contains(string(b), " // gen_test.go:") {
+		t.Errorf("Expected -annotate to annotate the instructions:\n%s", b)
+	}
+	var written Origins
+	if b, _ := os.ReadFile(origins); json.Unmarshal(b, &written) != nil || len(written) == 0 {
+		t.Errorf("Expected origins in %s:\n%s", filepath.Base(origins), b)
+	}
+
+	var exit *exec.ExitError
+	if out, err := main("-out", filepath.Join(dir, "missing", "sum_amd64.s")); !errors.As(err, &exit) || exit.ExitCode() != 1 {
+		t.Errorf("Expected exit status 1 for an unwritable -out, got %v:\n%s", err, out)
+	}
+}
+
+func TestWithoutOrigins(t *testing.T) {
+	// regenerating with origins gives the header of go generate
+	want := "go run asm.go -out a.s -stubs a.go"
+	for _, argv := range []string{
+		"go run asm.go -out a.s -stubs a.go",
+		"go run asm.go -out a.s -stubs a.go -origins a.json",
+		"go run asm.go -origins=a.json -out a.s -stubs a.go",
+		"go run asm.go -out a.s --origins a.json -stubs a.go",
+	} {
+		if got := strings.Join(withoutOrigins(strings.Fields(argv)), " "); got != want {
+			t.Errorf("%q: got %q", argv, got)
+		}
+	}
+}
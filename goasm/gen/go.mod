@@ -0,0 +1,12 @@
+module asm/gen
+
+go 1.22.3
+
+require github.com/mmcloughlin/avo v0.6.0
+
+require (
+	golang.org/x/arch v0.6.0 // indirect
+	golang.org/x/mod v0.14.0 // indirect
+	golang.org/x/sys v0.15.0 // indirect
+	golang.org/x/tools v0.16.1 // indirect
+)
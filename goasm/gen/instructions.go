@@ -0,0 +1,79 @@
+package gen
+
+import "github.com/mmcloughlin/avo/operand"
+
+// The instructions the generators use, shadowing those of the Context of a Func so that
+// each of them records where it was emitted, see File.Origin. An instruction missing
+// here still works through the Context, it just goes without an origin; add it when a
+// generator starts using it, keeping the list sorted.
+
+func (f *Func) ADCL(ops ...operand.Op)          { f.emit("ADCL", ops) }
+func (f *Func) ADCQ(ops ...operand.Op)          { f.emit("ADCQ", ops) }
+func (f *Func) ADCW(ops ...operand.Op)          { f.emit("ADCW", ops) }
+func (f *Func) ADCXQ(ops ...operand.Op)         { f.emit("ADCXQ", ops) }
+func (f *Func) ADDL(ops ...operand.Op)          { f.emit("ADDL", ops) }
+func (f *Func) ADDQ(ops ...operand.Op)          { f.emit("ADDQ", ops) }
+func (f *Func) ADDW(ops ...operand.Op)          { f.emit("ADDW", ops) }
+func (f *Func) ADOXQ(ops ...operand.Op)         { f.emit("ADOXQ", ops) }
+func (f *Func) ANDQ(ops ...operand.Op)          { f.emit("ANDQ", ops) }
+func (f *Func) BZHIQ(ops ...operand.Op)         { f.emit("BZHIQ", ops) }
+func (f *Func) CMOVQLT(ops ...operand.Op)       { f.emit("CMOVQLT", ops) }
+func (f *Func) CMPL(ops ...operand.Op)          { f.emit("CMPL", ops) }
+func (f *Func) CMPQ(ops ...operand.Op)          { f.emit("CMPQ", ops) }
+func (f *Func) DECQ(ops ...operand.Op)          { f.emit("DECQ", ops) }
+func (f *Func) INCQ(ops ...operand.Op)          { f.emit("INCQ", ops) }
+func (f *Func) JA(ops ...operand.Op)            { f.emit("JA", ops) }
+func (f *Func) JB(ops ...operand.Op)            { f.emit("JB", ops) }
+func (f *Func) JL(ops ...operand.Op)            { f.emit("JL", ops) }
+func (f *Func) JMP(ops ...operand.Op)           { f.emit("JMP", ops) }
+func (f *Func) JNC(ops ...operand.Op)           { f.emit("JNC", ops) }
+func (f *Func) JNE(ops ...operand.Op)           { f.emit("JNE", ops) }
+func (f *Func) JNZ(ops ...operand.Op)           { f.emit("JNZ", ops) }
+func (f *Func) JZ(ops ...operand.Op)            { f.emit("JZ", ops) }
+func (f *Func) KMOVQ(ops ...operand.Op)         { f.emit("KMOVQ", ops) }
+func (f *Func) MOVB(ops ...operand.Op)          { f.emit("MOVB", ops) }
+func (f *Func) MOVBQZX(ops ...operand.Op)       { f.emit("MOVBQZX", ops) }
+func (f *Func) MOVL(ops ...operand.Op)          { f.emit("MOVL", ops) }
+func (f *Func) MOVO(ops ...operand.Op)          { f.emit("MOVO", ops) }
+func (f *Func) MOVOU(ops ...operand.Op)         { f.emit("MOVOU", ops) }
+func (f *Func) MOVQ(ops ...operand.Op)          { f.emit("MOVQ", ops) }
+func (f *Func) MOVW(ops ...operand.Op)          { f.emit("MOVW", ops) }
+func (f *Func) MOVWQZX(ops ...operand.Op)       { f.emit("MOVWQZX", ops) }
+func (f *Func) NOTW(ops ...operand.Op)          { f.emit("NOTW", ops) }
+func (f *Func) ORW(ops ...operand.Op)           { f.emit("ORW", ops) }
+func (f *Func) PADDL(ops ...operand.Op)         { f.emit("PADDL", ops) }
+func (f *Func) PAND(ops ...operand.Op)          { f.emit("PAND", ops) }
+func (f *Func) PCMPEQL(ops ...operand.Op)       { f.emit("PCMPEQL", ops) }
+func (f *Func) PSHUFD(ops ...operand.Op)        { f.emit("PSHUFD", ops) }
+func (f *Func) PSRLL(ops ...operand.Op)         { f.emit("PSRLL", ops) }
+func (f *Func) PUNPCKHWL(ops ...operand.Op)     { f.emit("PUNPCKHWL", ops) }
+func (f *Func) PUNPCKLWL(ops ...operand.Op)     { f.emit("PUNPCKLWL", ops) }
+func (f *Func) PXOR(ops ...operand.Op)          { f.emit("PXOR", ops) }
+func (f *Func) RET(ops ...operand.Op)           { f.emit("RET", ops) }
+func (f *Func) ROLW(ops ...operand.Op)          { f.emit("ROLW", ops) }
+func (f *Func) SHLQ(ops ...operand.Op)          { f.emit("SHLQ", ops) }
+func (f *Func) SHLW(ops ...operand.Op)          { f.emit("SHLW", ops) }
+func (f *Func) SHRL(ops ...operand.Op)          { f.emit("SHRL", ops) }
+func (f *Func) SHRQ(ops ...operand.Op)          { f.emit("SHRQ", ops) }
+func (f *Func) SUBQ(ops ...operand.Op)          { f.emit("SUBQ", ops) }
+func (f *Func) TESTQ(ops ...operand.Op)         { f.emit("TESTQ", ops) }
+func (f *Func) VEXTRACTI128(ops ...operand.Op)  { f.emit("VEXTRACTI128", ops) }
+func (f *Func) VEXTRACTI64X4(ops ...operand.Op) { f.emit("VEXTRACTI64X4", ops) }
+func (f *Func) VMOVD(ops ...operand.Op)         { f.emit("VMOVD", ops) }
+func (f *Func) VMOVDQU(ops ...operand.Op)       { f.emit("VMOVDQU", ops) }
+func (f *Func) VMOVDQU8_Z(ops ...operand.Op)    { f.emit("VMOVDQU8_Z", ops) }
+func (f *Func) VPADDD(ops ...operand.Op)        { f.emit("VPADDD", ops) }
+func (f *Func) VPAND(ops ...operand.Op)         { f.emit("VPAND", ops) }
+func (f *Func) VPANDD(ops ...operand.Op)        { f.emit("VPANDD", ops) }
+func (f *Func) VPCMPEQD(ops ...operand.Op)      { f.emit("VPCMPEQD", ops) }
+func (f *Func) VPMOVZXWD(ops ...operand.Op)     { f.emit("VPMOVZXWD", ops) }
+func (f *Func) VPSHUFD(ops ...operand.Op)       { f.emit("VPSHUFD", ops) }
+func (f *Func) VPSRLD(ops ...operand.Op)        { f.emit("VPSRLD", ops) }
+func (f *Func) VPTERNLOGD(ops ...operand.Op)    { f.emit("VPTERNLOGD", ops) }
+func (f *Func) VPXORD(ops ...operand.Op)        { f.emit("VPXORD", ops) }
+func (f *Func) VXORPS(ops ...operand.Op)        { f.emit("VXORPS", ops) }
+func (f *Func) VZEROUPPER(ops ...operand.Op)    { f.emit("VZEROUPPER", ops) }
+func (f *Func) XORB(ops ...operand.Op)          { f.emit("XORB", ops) }
+func (f *Func) XORL(ops ...operand.Op)          { f.emit("XORL", ops) }
+func (f *Func) XORQ(ops ...operand.Op)          { f.emit("XORQ", ops) }
+func (f *Func) XORW(ops ...operand.Op)          { f.emit("XORW", ops) }
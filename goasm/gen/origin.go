@@ -0,0 +1,184 @@
+package gen
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/mmcloughlin/avo/build"
+	"github.com/mmcloughlin/avo/gotypes"
+	"github.com/mmcloughlin/avo/ir"
+	"github.com/mmcloughlin/avo/operand"
+	"github.com/mmcloughlin/avo/reg"
+	"github.com/mmcloughlin/avo/src"
+)
+
+// Origin is the generator line an instruction was emitted from.
+type Origin struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+	// Func is the function the line is in, like "main.main" or
+	// "asm/gen/rfc1071.(*kernel).handleOdd".
+	Func string `json:"func"`
+}
+
+func (o Origin) String() string {
+	return o.File + ":" + strconv.Itoa(o.Line)
+}
+
+// Origins maps lines of printed assembly, counting from 1, to where they were emitted.
+// It marshals to the JSON written by the -origins flag of Main.
+type Origins map[int]Origin
+
+// Origin returns where the instruction i of f was emitted, if it went through one of the
+// instructions of Func. Instructions added by compiling f have none.
+func (f *File) Origin(i *ir.Instruction) (Origin, bool) {
+	o, ok := f.origins[i]
+	return o, ok
+}
+
+// emit adds the instruction op with ops to the function through its context, the way
+// calling the method op of the context would, and notes where it was asked for.
+func (f *Func) emit(op string, ops []operand.Op) {
+	m := reflect.ValueOf(f.Context).MethodByName(op)
+	if !m.IsValid() {
+		panic("gen: avo has no instruction " + op)
+	}
+	if t := m.Type(); !t.IsVariadic() && t.NumIn() != len(ops) {
+		panic(fmt.Sprintf("gen: %s takes %d operands, not %d", op, t.NumIn(), len(ops)))
+	}
+	args := make([]reflect.Value, len(ops))
+	for n := range ops {
+		// through the pointer so that a nil operand is still an operand.Op
+		args[n] = reflect.ValueOf(&ops[n]).Elem()
+	}
+	f.record(func() { m.Call(args) })
+}
+
+// Load is Context.Load, noting where the moves it emits were asked for.
+func (f *Func) Load(src gotypes.Component, dst reg.Register) reg.Register {
+	var r reg.Register
+	f.record(func() { r = f.Context.Load(src, dst) })
+	return r
+}
+
+// Store is Context.Store, noting where the moves it emits were asked for.
+func (f *Func) Store(src reg.Register, dst gotypes.Component) {
+	f.record(func() { f.Context.Store(src, dst) })
+}
+
+// record runs emit and notes the caller as the origin of every instruction it adds.
+// avo records invalid operand combinations as errors of the context, adding nothing,
+// at the first caller outside of it, which through reflection is in package reflect.
+// The errors emit adds are moved to the caller as well.
+func (f *Func) record(emit func()) {
+	var n int
+	if f.fn != nil {
+		n = len(f.fn.Nodes)
+	}
+	errs := len(f.errs())
+	emit()
+	added := f.errs()[errs:]
+	if (f.fn == nil || len(f.fn.Nodes) == n) && len(added) == 0 {
+		return
+	}
+	o := caller()
+	for i := range added {
+		added[i].Position = src.Position{Filename: o.File, Line: o.Line}.Relwd()
+	}
+	if f.fn == nil {
+		return
+	}
+	for _, node := range f.fn.Nodes[n:] {
+		if i, ok := node.(*ir.Instruction); ok {
+			f.file.origins[i] = o
+		}
+	}
+}
+
+// errs returns the errors of the context so far, sharing their storage with it.
+func (f *Func) errs() build.ErrorList {
+	_, err := f.Context.Result()
+	var list build.ErrorList
+	errors.As(err, &list)
+	return list
+}
+
+// dir is the directory of this package, the functions in it are helpers on the way to
+// the generator line that asked for an instruction. Its tests are generators like any other.
+var dir = func() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Dir(file)
+}()
+
+// caller returns the innermost caller outside of this package.
+func caller() Origin {
+	for skip := 1; ; skip++ {
+		pc, file, line, ok := runtime.Caller(skip)
+		if !ok {
+			return Origin{}
+		}
+		if filepath.Dir(file) != dir || strings.HasSuffix(file, "_test.go") {
+			return Origin{File: file, Line: line, Func: runtime.FuncForPC(pc).Name()}
+		}
+	}
+}
+
+// lineUp lines the instructions of file up with asm, the way it was printed, and returns
+// the origin of each line, the lines ending in a comment naming it if f.Annotate is set.
+func (f *File) lineUp(file *ir.File, asm []byte) ([]byte, Origins, error) {
+	lines := strings.Split(string(asm), "\n")
+	origins := make(Origins)
+	fns := file.Functions()
+	var (
+		fn    = -1
+		insts []*ir.Instruction
+		next  int
+	)
+	done := func() error {
+		if fn >= 0 && next != len(insts) {
+			return fmt.Errorf("gen: %d instructions of %s printed, not %d", next, fns[fn].Name, len(insts))
+		}
+		return nil
+	}
+	for n, l := range lines {
+		if strings.HasPrefix(l, "TEXT ") {
+			if err := done(); err != nil {
+				return nil, nil, err
+			}
+			if fn++; fn == len(fns) {
+				return nil, nil, fmt.Errorf("gen: more functions printed than the %d of the file", len(fns))
+			}
+			insts = insts[:0]
+			for _, node := range fns[fn].Nodes {
+				if i, ok := node.(*ir.Instruction); ok {
+					insts = append(insts, i)
+				}
+			}
+			next = 0
+			continue
+		}
+		// labels aren't indented, comments are
+		if fn < 0 || !strings.HasPrefix(l, "\t") || strings.HasPrefix(l, "\t//") {
+			continue
+		}
+		if next == len(insts) {
+			return nil, nil, fmt.Errorf("gen: more instructions printed than the %d of %s", len(insts), fns[fn].Name)
+		}
+		if o, ok := f.origins[insts[next]]; ok {
+			origins[n+1] = o
+			if f.Annotate {
+				lines[n] += " // " + filepath.Base(o.File) + ":" + strconv.Itoa(o.Line)
+			}
+		}
+		next++
+	}
+	if err := done(); err != nil {
+		return nil, nil, err
+	}
+	return []byte(strings.Join(lines, "\n")), origins, nil
+}
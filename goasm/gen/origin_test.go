@@ -0,0 +1,151 @@
+package gen
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mmcloughlin/avo/build"
+	"github.com/mmcloughlin/avo/operand"
+)
+
+// instructions returns the numbers of the lines of asm holding an instruction.
+func instructions(asm []byte) []int {
+	var lines []int
+	for n, l := range strings.Split(string(asm), "\n") {
+		if strings.HasPrefix(l, "\t") && !strings.HasPrefix(l, "\t//") {
+			lines = append(lines, n+1)
+		}
+	}
+	return lines
+}
+
+func TestOrigins(t *testing.T) {
+	file := NewFile("kernels", "amd64")
+	sum16(file, "sum")
+	f := file.Function("add", "func(a, b uint64) uint64", "", 0)
+	a, b := f.Load(f.Param("a"), f.GP64()), f.Load(f.Param("b"), f.GP64())
+	f.ADDQ(a, b)
+	_, self, line, _ := runtime.Caller(0)
+	f.Store(b, f.ReturnIndex(0))
+	f.RET()
+
+	out, err := file.Print()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(out.Asm), "\n")
+	for _, n := range instructions(out.Asm) {
+		o, ok := out.Origins[n]
+		if !ok {
+			t.Errorf("no origin for line %d: %s", n, lines[n-1])
+			continue
+		}
+		// the helpers of sum16 are in this package, what called them isn't
+		if o.File != filepath.Join(filepath.Dir(self), "gen_test.go") && o.File != self {
+			t.Errorf("line %d: %s comes from %s", n, lines[n-1], o)
+		}
+	}
+	if len(out.Origins) != len(instructions(out.Asm)) {
+		t.Errorf("%d origins for %d instructions", len(out.Origins), len(instructions(out.Asm)))
+	}
+	var addq []string
+	for n, o := range out.Origins {
+		if o.File == self && o.Line == line-1 {
+			addq = append(addq, lines[n-1])
+			if !strings.HasSuffix(o.Func, ".TestOrigins") {
+				t.Errorf("line %d: %s comes from %s", n, lines[n-1], o.Func)
+			}
+		}
+	}
+	if len(addq) != 1 || !strings.HasPrefix(addq[0], "\tADDQ") {
+		t.Errorf("Expected the ADDQ from line %d, got %q", line-1, addq)
+	}
+
+	// annotating changes nothing but the ends of the instructions
+	file.Annotate = true
+	annotated, err := file.Print()
+	if err != nil {
+		t.Fatal(err)
+	}
+	comment := regexp.MustCompile(` // (gen|origin)_test\.go:\d+$`)
+	stripped := strings.Split(string(annotated.Asm), "\n")
+	for _, n := range instructions(annotated.Asm) {
+		if !comment.MatchString(stripped[n-1]) {
+			t.Errorf("line %d isn't annotated: %s", n, stripped[n-1])
+		}
+		if want := filepath.Base(out.Origins[n].File) + ":" + strconv.Itoa(out.Origins[n].Line); !strings.HasSuffix(stripped[n-1], want) {
+			t.Errorf("line %d: annotated with something other than %s: %s", n, want, stripped[n-1])
+		}
+		stripped[n-1] = comment.ReplaceAllString(stripped[n-1], "")
+	}
+	if strings.Join(stripped, "\n") != string(out.Asm) {
+		t.Errorf("annotating changed more than the ends of the lines:\n%s", annotated.Asm)
+	}
+
+	name := filepath.Join(t.TempDir(), "origins.json")
+	if err = file.WriteOrigins(name); err != nil {
+		t.Fatal(err)
+	}
+	js, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written Origins
+	if err = json.Unmarshal(js, &written); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(written, out.Origins) {
+		t.Errorf("written origins differ:\n%s", js)
+	}
+}
+
+func TestInvalidOperands(t *testing.T) {
+	file := NewFile("a", "")
+	f := file.Function("invalid", "func()", "", 0)
+	f.MOVQ(f.GP64(), f.GP64())
+	// avo has no MOVQ of a 16-bit immediate
+	f.MOVQ(operand.Imm(0x4000), f.GP64())
+	_, _, line, _ := runtime.Caller(0)
+	f.RET()
+
+	_, err := file.IR()
+	var list build.ErrorList
+	if !errors.As(err, &list) || len(list) != 1 {
+		t.Fatalf("Expected one error, got %v", err)
+	}
+	if want := "origin_test.go:" + strconv.Itoa(line-1) + ": "; !strings.HasPrefix(list[0].Error(), want) {
+		t.Errorf("Expected the error at %s, got %s", want, list[0])
+	}
+}
+
+func TestShadowedInstructions(t *testing.T) {
+	// the instructions of Func go through reflection, a typo would only show when used
+	ctx := reflect.TypeOf(&build.Context{})
+	fn := reflect.TypeOf(&Func{})
+	shadow := reflect.TypeOf((*Func).RET)
+	for i := 0; i < fn.NumMethod(); i++ {
+		name := fn.Method(i).Name
+		if fn.Method(i).Type != shadow {
+			continue
+		}
+		if _, ok := ctx.MethodByName(name); !ok {
+			t.Errorf("Func.%s shadows no instruction of build.Context", name)
+		}
+	}
+
+	f := NewFile("a", "").Function("wrong", "func()", "", 0)
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected a panic for an instruction with too many operands")
+		}
+	}()
+	f.RET(f.GP64())
+}
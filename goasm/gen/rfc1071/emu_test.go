@@ -0,0 +1,147 @@
+package rfc1071
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"testing"
+
+	"github.com/mmcloughlin/avo/ir"
+
+	"asm/gen"
+	"asm/gen/emu"
+)
+
+// reference is the textbook RFC 1071 sum of data: big endian words, the odd byte padded
+// with a zero, folded but not complemented.
+func reference(data []byte, initial uint32) uint16 {
+	sum := uint64(initial)
+	for ; len(data) >= 2; data = data[2:] {
+		sum += uint64(binary.BigEndian.Uint16(data))
+	}
+	if len(data) == 1 {
+		sum += uint64(data[0]) << 8
+	}
+	for sum > 0xFFFF {
+		sum = sum>>16 + sum&0xFFFF
+	}
+	return uint16(sum)
+}
+
+// function returns the IR of the function called name, registers still virtual.
+func function(t *testing.T, name string) (*ir.Function, *kernel) {
+	t.Helper()
+	file, f, err := newFile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	built, err := file.IR()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := gen.Lookup(built, name)
+	if fn == nil {
+		t.Fatalf("no function %s", name)
+	}
+	return fn, f
+}
+
+func TestEmulatedKernels(t *testing.T) {
+	for _, name := range []string{"checksum", "checksumADC", "checksumADX", "partialSum"} {
+		t.Run(name, func(t *testing.T) {
+			fn, _ := function(t, name)
+			for n := 0; n < 300; n++ {
+				data := make([]byte, n)
+				rand.Read(data)
+				if n%3 == 0 {
+					// runs of 0xFF are what carries are made of
+					for i := range data {
+						data[i] = 0xFF
+					}
+				}
+				initial := rand.Uint32()
+
+				m := emu.New()
+				m.Slice("data", data)
+				m.Args["initial"] = uint64(initial)
+				if err := m.Run(fn); err != nil {
+					t.Fatalf("%d bytes: %v", n, err)
+				}
+
+				var expect, actual uint16
+				switch name {
+				case "partialSum":
+					// the sum isn't folded down to 16 bits, which doesn't change its value
+					expect = reference(data, initial)
+					actual = reference(nil, uint32(m.Args["sum"]))
+				default:
+					expect, actual = ^reference(data, 0), uint16(m.Args["sum"])
+					if n == 0 {
+						expect = 0
+					}
+				}
+				if actual != expect {
+					t.Fatalf("%d bytes %x: expected %#04x, but got %#04x", n, data, expect, actual)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleOdd(t *testing.T) {
+	fn, f := function(t, "checksum")
+	sum, remaining := f.R("sum"), f.data.Len
+
+	tests := []struct {
+		name      string
+		sum       uint64
+		odd       byte
+		remaining uint64
+		stop      ir.Label
+		expect    uint64
+	}{
+		{"odd byte", 0x0010, 0x7F, 1, "adjust_sum", 0x7F10},
+		{"odd byte overflows", 0xFF00, 0x01, 1, "adjust_sum", 0x10000},
+		{"nothing left", 0x1234, 0x7F, 0, "fin", 0x1234},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			m := emu.New()
+			m.SetReg(f.data.Data.Base, m.Map([]byte{tc.odd}))
+			m.SetReg(remaining, tc.remaining)
+			m.SetReg(sum, tc.sum)
+			// garbage from the loop before, the SHLW shifts it out
+			m.SetReg(f.R("hi"), 0xAB00)
+
+			stop, err := m.RunFrom(fn, "handle_odd", "adjust_sum", "fin", "nextb")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stop != tc.stop || m.Reg(sum) != tc.expect {
+				t.Errorf("Expected to reach %s with %#x, but reached %s with %#x", tc.stop, tc.expect, stop, m.Reg(sum))
+			}
+			if overflow := m.Reg(sum) > 0xFFFF; tc.remaining == 1 && (m.CF || m.ZF) == overflow {
+				t.Errorf("CMPQ left CF=%v ZF=%v for %#x", m.CF, m.ZF, m.Reg(sum))
+			}
+		})
+	}
+}
+
+func TestAdjustSum(t *testing.T) {
+	fn, f := function(t, "checksum")
+	sum := f.R("sum")
+
+	for _, tc := range []struct{ sum, expect uint64 }{
+		{0x1_0000, 0x0001},
+		{0x2_FFFF, 0x1_0001}, // one round at a time, fin sends it back for the rest
+		{0xFFFF_FFFF, 0x1_FFFE},
+	} {
+		m := emu.New()
+		m.SetReg(sum, tc.sum)
+		if stop, err := m.RunFrom(fn, "adjust_sum", "nextb"); err != nil || stop != "nextb" {
+			t.Fatalf("%#x: Expected to jump to nextb, but got %q, %v", tc.sum, stop, err)
+		}
+		if m.Reg(sum) != tc.expect {
+			t.Errorf("%#x: expected %#x, but got %#x", tc.sum, tc.expect, m.Reg(sum))
+		}
+	}
+}
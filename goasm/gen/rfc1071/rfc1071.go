@@ -0,0 +1,492 @@
+// Package rfc1071 generates the assembly of the rfc1071 module, asm.go there runs New.
+// It builds on a gen.File of its own, so tests and asmgen can build it next to anything else.
+package rfc1071
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mmcloughlin/avo/build"
+	"github.com/mmcloughlin/avo/operand"
+	"github.com/mmcloughlin/avo/reg"
+
+	"asm/gen"
+)
+
+// Modes are the test modes of New, TestASMChecksumComponents picks one with ASM_TEST_MODE.
+var Modes = []string{"early_fail", "handle_odd"}
+
+// ===================================================================================================================
+
+/*
+# Avo notes
+
+because they are strictly for `go generate` functionality, the avo examples are not exactly idiomatic golang.
+
+ - when you write the generation functions you define a main package, but you add a build constraint to ignore it
+   -\ asm.go in the rfc1071 module is still that, but all it does is call New and write the result
+
+ - they use a global *build.Context as a primary way to pass around the state, along with an `init()`
+   -\ we don't, every gen.File has its own context so tests and asmgen can build as many as they like
+
+ - the input to build.TEXT is just a macro that instantiates the global context with the function name and signature
+   -\ gen.File.Function does the same for a file's own context
+
+// ===================================================================================================================
+
+/*
+
+# various asm notes for the gopher brained
+
+despite it's name, MOV copies
+
+(adr): *adr // dereference
+
+ADD(src, dest): +=
+SUB(src, dest): -=
+MOV(src, dest): =
+XOR(src, dest): ^=
+OR(src, dest): |=
+AND(src, dest): &=
+NOT(src, dest): ^=
+SHR(src, dest): >>=
+
+JGE: Jump if result of CMPx greater or equal
+
+movzx cx,ch  ; zero-extends ch into cx. the upper byte of cx will be filled with zeroes
+movsx cx,ch  ; sign-extends ch into cx. the upper byte of cx will be filled with the most significant bit of ch
+
+---
+
+| cmp a,b | test a,b | Description      |
+|---------|----------|------------------|
+| je      | b == a   | b&a == 0         | Equal              |
+| jne     | b != a   | b&a != 0         | Not equal          |
+| js      | b-a < 0  | b&a < 0          | Sign (negative)    |
+| jns     | b-a >= 0 | b&a >= 0         | Non-negative       |
+| jg      | b > a    | b&a > 0          | Greater            |
+| jge     | b >= a   | b&a >= 0         | Greater or equal   |
+| jl      | b < a    | b&a < 0          | Less               |
+| jle     | b <= a   | b&a <= 0         | Less or equal      |
+| ja      | b > a    | b&a > 0U         | Above (unsigned >) |
+| jb      | b < a    | b&a < 0U         | Below (unsigned <) |
+| jz	  | b == 0   | b == 0           | Zero               |
+
+	if (x < 3 && x == y) {
+		return 1;
+	} else {
+		return 2;
+	}
+
+## ~=
+
+    cmpq $3, %rdi  # Compare x with 3
+    jge T2         # If x >= 3, jump to T2
+    cmpq %rsi, %rdi # Compare x with y
+    jne T2         # If x != y, jump to T2
+	T1: # x < 3 && x == y:
+    	movq $1, %rax  # Return 1
+    	ret
+	T2: # else
+    	movq $2, %rax  # Return 2
+    	ret
+
+// --------------------------------------------------------
+
+ADD and SUB carries any overflow by setting the carry flag (CF) in the EFLAGS register.
+The carry flag indicates an overflow condition for unsigned-integer arithmetic.
+The next instruction can test the carry flag with the JC (jump if carry) instruction.
+
+ADC and SBB are similar to ADD and SUB, but they also add or subtract the value of the carry flag.
+
+	- If the carry flag was set by ADD, a subequent ADD will add the value of the carry flag to the result.
+	- If the carry flag was set by SUB, a subsequent SUB will subtract the value of the carry flag from the result.
+
+In both the above cases, after the second operation, the carry flag is cleared;
+assuming the operation did not set it again.
+
+check what operations modify flags and what oprations modify or undefine them here:
+	- ref: http://ref.x86asm.net/coder32.html
+*/
+
+// ===================================================================================================================
+
+// labels of the kernels, scoped to their function like every gen.Label
+const (
+	loop      gen.Label = "loop"
+	nextb     gen.Label = "nextb"
+	fin       gen.Label = "fin"
+	earlyFail gen.Label = "early_fail"
+	handleOdd gen.Label = "handle_odd"
+	adjustSum gen.Label = "adjust_sum"
+
+	partialLoop gen.Label = "partial_loop"
+	partialOdd  gen.Label = "partial_odd"
+	partialFold gen.Label = "partial_fold"
+
+	adcLoop gen.Label = "adc_loop"
+	adxLoop gen.Label = "adx_loop"
+
+	// wideTail is the same in checksumADC and checksumADX
+	tail      gen.Label = "tail"
+	tailQuads gen.Label = "tail_quads"
+	tailWords gen.Label = "tail_words"
+	tailOdd   gen.Label = "tail_odd"
+	tailFold  gen.Label = "tail_fold"
+)
+
+// kernel is a function of the file along with its []byte parameter, the registers it
+// needs beyond that go by name through Reg and R.
+type kernel struct {
+	*gen.Func
+	data gen.Slice
+}
+
+// newKernel starts the NOSPLIT function name in file.
+func newKernel(file *gen.File, name, signature, doc string) *kernel {
+	k := &kernel{Func: file.Function(name, signature, doc, build.NOSPLIT)}
+	k.Comment("initialize registers")
+	return k
+}
+
+func newChecksum(file *gen.File) *kernel {
+	k := newKernel(file, "checksum", "func(data []byte) (sum uint16)",
+		"calculate RFC 1071 internet checksum for a byte slice")
+	// sum: we accumulate in the low 16 bits, adjust_sum wraps whatever carries out of them
+	k.Zero(k.Reg("sum", 64))
+	// hi: the current 16 bit word, its first byte goes in the high byte
+	//  - mov a byte in, shift left by 8 bits to make room for the next one
+	//  - combine it with lo to form the word
+	// ADDQ adds all 64 bits of it, so it has to start out clean
+	k.Zero(k.Reg("hi", 64))
+	// lo: the second byte of the word, only ever written 8 bits at a time
+	k.Zero(k.Reg("lo", 64))
+	k.data = k.LoadSlice("data")
+	return k
+}
+
+// newPartialSum is the csum_partial flavored sibling of newChecksum.
+func newPartialSum(file *gen.File) *kernel {
+	k := newKernel(file, "partialSum", "func(data []byte, initial uint32) (sum uint32)",
+		"partialSum returns the unfolded and uncomplemented ones' complement sum of data added to initial")
+	// sum: 64 bit accumulator, we have 48 bits of headroom so we only fold once at the very end
+	sum := k.Reg("sum", 64)
+	// word: scratch register for the current 16 bit word
+	k.Reg("word", 64)
+	// MOVL zero extends, so the upper half of sum is clean
+	k.Load(k.Param("initial"), gen.View(sum, 32))
+	k.data = k.LoadSlice("data")
+	return k
+}
+
+// newWideChecksum sets up a checksum kernel that sums 64-bit little endian words, relying on
+// the byte order independence of RFC 1071 (section 2.B) to only swap into network byte order
+// once at the very end. the loop itself is up to the caller, wideTail does the rest.
+func newWideChecksum(file *gen.File, name, doc string) *kernel {
+	k := newKernel(file, name, "func(data []byte) (sum uint16)", doc)
+	// sum: 64 bit accumulator for the first (CF) carry chain
+	k.Zero(k.Reg("sum", 64))
+	// sum1: accumulator for the second (OF) carry chain, only ADOX uses it
+	k.Zero(k.Reg("sum1", 64))
+	// carry: counts the carries out of each unrolled chain.
+	//  - an ADCQ $0 on the sum itself would drop the carry out of 0xFFFFFFFFFFFFFFFF + CF
+	//  - a counter won't ever get close to overflowing
+	k.Zero(k.Reg("carry", 64))
+	// zero: ADCX/ADOX have no immediate form, XORing it also clears CF and OF
+	k.Zero(k.Reg("zero", 64))
+	// word: scratch register for the tail
+	k.Reg("word", 64)
+	k.data = k.LoadSlice("data")
+	return k
+}
+
+// adjust wraps the carries out of the low 16 bits of r back into them, one round of
+// folding: r = r&0xFFFF + r>>16.
+func (k *kernel) adjust(r reg.GPVirtual) {
+	r = gen.View(r, 64)
+	swap := k.GP64()
+	// save register contents before we mask, effectively storing the overflow in another register
+	k.MOVQ(r, swap)
+	// mask lower 16 of target
+	k.ANDQ(operand.U32(0xFFFF), r)
+	// shift work register right 16 bits so that the overflow is in the lower 16 bits
+	k.SHRQ(operand.U8(16), swap)
+	k.ADDQ(swap, r)
+}
+
+func (k *kernel) nextb() {
+	k.Advance(k.data, 2)
+	k.JNC(loop.Ref())
+	k.Jump(fin)
+}
+
+func (k *kernel) loop() {
+	k.JumpIfZero(k.data.Len, fin)
+	k.JumpIfBelow(k.data.Len, 2, handleOdd)
+
+	// load first byte into hi and shift left by 8 bits to make room for the next byte
+	k.MOVB(k.data.Data.Offset(0), k.View("hi", 8))
+	k.SHLW(operand.Imm(8), k.View("hi", 16))
+	// load second byte into lo
+	k.MOVB(k.data.Data.Offset(1), k.View("lo", 8))
+	// combine hi and lo to form a 16 bit word
+	k.ORW(k.View("lo", 16), k.View("hi", 16))
+	// add 16 bit word to the sum
+	k.ADDQ(k.R("hi"), k.R("sum"))
+
+	k.CMPL(k.View("sum", 32), operand.U32(0xFFFF))
+	k.JA(adjustSum.Ref())
+}
+
+func main_t(k *kernel, mode string) {
+	switch {
+	case strings.EqualFold("early_fail", mode):
+		main_tEarlyFail(k)
+	case strings.EqualFold("handle_odd", mode):
+		k.Block(adjustSum, func() {
+			k.adjust(k.R("sum"))
+		})
+		main_tCheckOdd(k)
+	default:
+		panic("unknown test mode: '" + mode + "'!")
+	}
+}
+
+func main_tEarlyFail(k *kernel) {
+	k.earlyCheck()
+
+	ret := k.GP16()
+	k.MOVW(operand.U16(5), ret)
+	k.Return16(ret)
+}
+
+func main_tCheckOdd(k *kernel) {
+	ret := k.Reg("ret", 32)
+	k.Zero(ret)
+	k.earlyCheck()
+
+	k.MOVB(k.data.Data, k.View("hi", 8))
+
+	k.adjust(k.R("hi"))
+
+	k.Label(fin)
+	k.Return16(ret)
+}
+
+func (k *kernel) earlyCheck() {
+	k.JumpIfZero(k.data.Len, earlyFail)
+}
+
+func (k *kernel) earlyFail() {
+	k.ReturnZero16(k.R("sum"))
+}
+
+func (k *kernel) handleOdd() {
+	hi, sum := k.R("hi"), k.R("sum")
+
+	k.CMPQ(k.data.Len, operand.Imm(1))
+	k.JNE(fin.Ref())
+
+	k.MOVB(k.data.Data.Offset(0), gen.View(hi, 8))
+	k.SHLW(operand.Imm(8), gen.View(hi, 16))
+	k.ADDQ(hi, sum)
+	k.CMPQ(sum, operand.U32(0xFFFF))
+	k.JA(adjustSum.Ref())
+}
+
+func (k *kernel) partialLoop() {
+	word := k.R("word")
+
+	k.JumpIfBelow(k.data.Len, 2, partialOdd)
+
+	// load a 16 bit word and swap it into network byte order
+	k.MOVWQZX(k.data.Data.Offset(0), word)
+	k.ROLW(operand.Imm(8), gen.View(word, 16))
+	k.ADDQ(word, k.R("sum"))
+
+	k.Advance(k.data, 2)
+	k.Jump(partialLoop)
+}
+
+func (k *kernel) partialOdd() {
+	word := k.R("word")
+
+	k.JumpIfZero(k.data.Len, partialFold)
+
+	// the odd byte is the high byte of a zero padded word
+	k.MOVBQZX(k.data.Data.Offset(0), word)
+	k.SHLQ(operand.Imm(8), word)
+	k.ADDQ(word, k.R("sum"))
+}
+
+func (k *kernel) partialFold() {
+	sum := k.R("sum")
+
+	k.Fold32(sum, k.R("word"))
+	k.Store(gen.View(sum, 32), k.ReturnIndex(0))
+	k.RET()
+}
+
+// adcLoop sums 32 bytes per iteration with a single ADCQ carry chain, unrolled 4x.
+func (k *kernel) adcLoop() {
+	sum := k.R("sum")
+
+	k.JumpIfBelow(k.data.Len, 32, tail)
+
+	k.ADDQ(k.data.Data.Offset(0), sum)
+	k.ADCQ(k.data.Data.Offset(8), sum)
+	k.ADCQ(k.data.Data.Offset(16), sum)
+	k.ADCQ(k.data.Data.Offset(24), sum)
+	// close the chain, the loop compare is about to clobber CF
+	k.ADCQ(operand.Imm(0), k.R("carry"))
+
+	k.Advance(k.data, 32)
+	k.Jump(adcLoop)
+}
+
+// adxLoop sums 64 bytes per iteration with two independent carry chains, unrolled 8x:
+// ADCX only reads and writes CF, ADOX only OF, so the CPU can run both chains side by side.
+func (k *kernel) adxLoop() {
+	sum, sum1, carry, zero := k.R("sum"), k.R("sum1"), k.R("carry"), k.R("zero")
+
+	k.JumpIfBelow(k.data.Len, 64, tail)
+
+	// CMPQ left CF and OF in whatever state, both chains have to start clean
+	k.Zero(zero)
+
+	for i := 0; i < 64; i += 16 {
+		k.ADCXQ(k.data.Data.Offset(i), sum)
+		k.ADOXQ(k.data.Data.Offset(i+8), sum1)
+	}
+
+	// close both chains into the carry counter
+	k.ADCXQ(zero, carry)
+	k.ADOXQ(zero, carry)
+
+	k.Advance(k.data, 64)
+	k.Jump(adxLoop)
+}
+
+// wideTail merges the chains, sums whatever the unrolled loop left behind 8, 2 and 1 byte(s)
+// at a time, then folds, swaps and complements. after ADDQ carries out the sum is smaller than
+// what we added, so the ADCQ $0 following each ADDQ here can't carry out again.
+func (k *kernel) wideTail() {
+	sum, word, carry := k.R("sum"), k.R("word"), k.R("carry")
+
+	k.Block(tail, func() {
+		k.ADDQ(k.R("sum1"), sum)
+		k.ADCQ(operand.Imm(0), carry)
+		k.ADDQ(carry, sum)
+		k.ADCQ(operand.Imm(0), sum)
+	})
+
+	k.Block(tailQuads, func() {
+		k.JumpIfBelow(k.data.Len, 8, tailWords)
+		k.ADDQ(k.data.Data.Offset(0), sum)
+		k.ADCQ(operand.Imm(0), sum)
+		k.Advance(k.data, 8)
+		k.Jump(tailQuads)
+	})
+
+	k.Block(tailWords, func() {
+		k.JumpIfBelow(k.data.Len, 2, tailOdd)
+		k.MOVWQZX(k.data.Data.Offset(0), word)
+		k.ADDQ(word, sum)
+		k.ADCQ(operand.Imm(0), sum)
+		k.Advance(k.data, 2)
+		k.Jump(tailWords)
+	})
+
+	k.Block(tailOdd, func() {
+		k.JumpIfZero(k.data.Len, tailFold)
+		// the odd byte is the low byte of a zero padded little endian word
+		k.MOVBQZX(k.data.Data.Offset(0), word)
+		k.ADDQ(word, sum)
+		k.ADCQ(operand.Imm(0), sum)
+	})
+
+	k.Block(tailFold, func() {
+		k.Fold16(sum, word)
+		// back to network byte order
+		k.SwapComplement16(sum)
+		k.Return16(sum)
+	})
+}
+
+// New builds checksum_amd64.s of the rfc1071 module: checksum, partialSum, checksumADC and
+// checksumADX. A mode other than "" builds the cut down checksum of that test mode instead
+// (see Modes), the other three functions are always there as the rest of the package needs them.
+func New(mode string) (*gen.File, error) {
+	file, _, err := newFile(mode)
+	return file, err
+}
+
+// newFile is New, also returning checksum so that tests can get at its registers.
+func newFile(mode string) (*gen.File, *kernel, error) {
+	if mode != "" && !slices.Contains(Modes, mode) {
+		return nil, nil, fmt.Errorf("rfc1071: unknown test mode %q", mode)
+	}
+
+	// we're using 64 bit registers so we're constrained to 64 bit architectures
+	// ...and i don't even know how other instructions sets work lmao so amd64 it is
+	// TODO: learn how to sign up for email
+	// the purego tag opts out of assembly altogether, see checksum_others.go
+	file := gen.NewFile("asm", "amd64,!purego")
+
+	k := newChecksum(file)
+
+	if mode != "" {
+		main_t(k, mode)
+		k.Block(earlyFail, k.earlyFail)
+		goto gen
+	}
+
+	k.earlyCheck()
+
+	k.Block(loop, k.loop)
+
+	k.Block(nextb, k.nextb) // jumps to loop if we're not done
+
+	k.Block(fin, func() {
+		sum := k.R("sum")
+		k.CMPQ(sum, operand.U32(0xFFFF))
+		k.JA(adjustSum.Ref())
+		k.NOTW(gen.View(sum, 16))
+		k.Return16(sum)
+	})
+
+	k.Block(earlyFail, k.earlyFail)
+
+	k.Block(handleOdd, k.handleOdd)
+
+	// handle overflow if we didn't jump
+	k.Block(adjustSum, func() {
+		k.adjust(k.R("sum"))
+		k.Jump(nextb)
+	})
+
+gen:
+
+	// always emitted, even in test mode, as the rest of the package depends on it
+	p := newPartialSum(file)
+	p.Block(partialLoop, p.partialLoop)
+	p.Block(partialOdd, p.partialOdd)
+	p.Block(partialFold, p.partialFold)
+
+	adc := newWideChecksum(file, "checksumADC",
+		"checksumADC sums 64-bit words with an ADCQ carry chain, unrolled 4x")
+	adc.earlyCheck()
+	adc.Block(adcLoop, adc.adcLoop)
+	adc.wideTail()
+	adc.Block(earlyFail, adc.earlyFail)
+
+	adx := newWideChecksum(file, "checksumADX",
+		"checksumADX sums 64-bit words with two ADCX/ADOX carry chains, unrolled 8x. requires ADX")
+	adx.earlyCheck()
+	adx.Block(adxLoop, adx.adxLoop)
+	adx.wideTail()
+	adx.Block(earlyFail, adx.earlyFail)
+
+	return file, k, nil
+}
@@ -0,0 +1,87 @@
+package rfc1071
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	// two of them at once, which the global build.Context never allowed
+	files := map[string]string{}
+	for _, mode := range append([]string{""}, Modes...) {
+		file, err := New(mode)
+		if err != nil {
+			t.Fatalf("%q: %v", mode, err)
+		}
+		again, err := New(mode)
+		if err != nil {
+			t.Fatalf("%q: %v", mode, err)
+		}
+		out, err := file.Print()
+		if err != nil {
+			t.Fatalf("%q: %v", mode, err)
+		}
+		outAgain, err := again.Print()
+		if err != nil {
+			t.Fatalf("%q: %v", mode, err)
+		}
+		if string(out.Asm) != string(outAgain.Asm) {
+			t.Errorf("%q: building twice differs", mode)
+		}
+		files[mode] = string(out.Asm)
+	}
+
+	for mode, asm := range files {
+		if !strings.Contains(asm, "//go:build amd64 && !purego") {
+			t.Errorf("%q: Expected the amd64 && !purego constraint", mode)
+		}
+		for _, fn := range []string{"checksum", "partialSum", "checksumADC", "checksumADX"} {
+			if strings.Count(asm, "TEXT ·"+fn+"(SB)") != 1 {
+				t.Errorf("%q: Expected %s exactly once", mode, fn)
+			}
+		}
+	}
+	// avo drops the labels nothing jumps to, the test modes only jump to early_fail
+	for label, modes := range map[string][]string{
+		"handle_odd:": {""},
+		"adjust_sum:": {""},
+		"fin:":        {""},
+		"early_fail:": {"", "early_fail", "handle_odd"},
+	} {
+		for mode, asm := range files {
+			if expect := slices.Contains(modes, mode); strings.Contains(asm, label) != expect {
+				t.Errorf("%q: Expected %s in the assembly to be %v", mode, label, expect)
+			}
+		}
+	}
+}
+
+func TestNewUnknownMode(t *testing.T) {
+	if _, err := New("nope"); err == nil {
+		t.Errorf("Expected an error for an unknown test mode")
+	}
+}
+
+func TestOrigins(t *testing.T) {
+	// every instruction of the kernels can be traced back to its line in rfc1071.go
+	for _, mode := range append([]string{""}, Modes...) {
+		file, err := New(mode)
+		if err != nil {
+			t.Fatalf("%q: %v", mode, err)
+		}
+		out, err := file.Print()
+		if err != nil {
+			t.Fatalf("%q: %v", mode, err)
+		}
+		for n, l := range strings.Split(string(out.Asm), "\n") {
+			if !strings.HasPrefix(l, "\t") || strings.HasPrefix(l, "\t//") {
+				continue
+			}
+			if o, ok := out.Origins[n+1]; !ok || filepath.Base(o.File) != "rfc1071.go" {
+				t.Errorf("%q: line %d: %s comes from %q", mode, n+1, l, o)
+			}
+		}
+	}
+}
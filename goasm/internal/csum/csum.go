@@ -0,0 +1,115 @@
+// Package csum is the portable RFC 1071 internet checksum code the rfc1071 and simd
+// packages share: the byte at a time reference, the 64-bit word sum standing in for the
+// assembly kernels, and the running Digest.
+package csum
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// Reference returns the checksum of data two bytes at a time, folding after every add.
+// It's slow and obviously right, what the kernels are tested against.
+func Reference(data []byte) uint16 {
+	dataSize := len(data)
+
+	if dataSize == 0 {
+		return 0
+	}
+
+	var sum uint32
+
+	for offset := 0; offset < dataSize-1; offset += 2 {
+		r11 := uint16(data[offset]) << 8
+		r8 := uint16(data[offset+1])
+		r11 |= r8
+		sum += uint32(r11)
+		if sum > 0xFFFF { // 65535, max unsignd 16 bit integer
+			sum = (sum & 0xFFFF) + (sum >> 16)
+		}
+	}
+
+	if dataSize%2 != 0 {
+		r8 := uint32(data[dataSize-1]) << 8
+		sum += r8
+		if sum > 0xFFFF {
+			sum = (sum & 0xFFFF) + (sum >> 16)
+		}
+	}
+
+	if sum > 0xFFFF {
+		sum = (sum & 0xFFFF) + (sum >> 16)
+	}
+
+	return ^uint16(sum)
+}
+
+// Sum returns the ones' complement sum of data read as little endian 64-bit words.
+// Like the wide assembly kernels it relies on the byte order independence of RFC 1071
+// (section 2.B): the folded sum only has to be byte swapped once at the end.
+func Sum(data []byte) uint64 {
+	var sum, carries, carry uint64
+
+	// carries are counted separately, so unlike adding each one straight back into the sum
+	// we can't lose the carry out of 0xFFFFFFFFFFFFFFFF + 1
+	for len(data) >= 32 {
+		sum, carry = bits.Add64(sum, binary.LittleEndian.Uint64(data[0:8]), 0)
+		carries += carry
+		sum, carry = bits.Add64(sum, binary.LittleEndian.Uint64(data[8:16]), 0)
+		carries += carry
+		sum, carry = bits.Add64(sum, binary.LittleEndian.Uint64(data[16:24]), 0)
+		carries += carry
+		sum, carry = bits.Add64(sum, binary.LittleEndian.Uint64(data[24:32]), 0)
+		carries += carry
+		data = data[32:]
+	}
+
+	for len(data) >= 8 {
+		sum, carry = bits.Add64(sum, binary.LittleEndian.Uint64(data), 0)
+		carries += carry
+		data = data[8:]
+	}
+
+	// zero padding the last few bytes also takes care of the odd byte
+	if len(data) > 0 {
+		var tail [8]byte
+		copy(tail[:], data)
+		sum, carry = bits.Add64(sum, binary.LittleEndian.Uint64(tail[:]), 0)
+		carries += carry
+	}
+
+	// after a carry out the sum is smaller than carries, so adding the carry back can't overflow
+	sum, carry = bits.Add64(sum, carries, 0)
+	return sum + carry
+}
+
+// Fold folds the end-around carries of a 32 bit ones' complement sum into 16 bits.
+func Fold(sum uint32) uint16 {
+	for sum > 0xFFFF {
+		sum = (sum & 0xFFFF) + (sum >> 16)
+	}
+	return uint16(sum)
+}
+
+// Fold64 folds a 64-bit ones' complement sum into 16 bits.
+func Fold64(sum uint64) uint16 {
+	for sum > 0xFFFF {
+		sum = (sum & 0xFFFF) + (sum >> 16)
+	}
+	return uint16(sum)
+}
+
+// Checksum is the portable stand-in for the assembly checksum kernels.
+func Checksum(data []byte) uint16 {
+	if len(data) == 0 {
+		return 0
+	}
+	return ^bits.ReverseBytes16(Fold64(Sum(data)))
+}
+
+// PartialSum is the portable stand-in for the assembly partialSum kernel: the unfolded
+// and uncomplemented ones' complement sum of data added to initial.
+func PartialSum(data []byte, initial uint32) uint32 {
+	sum := uint64(bits.ReverseBytes16(Fold64(Sum(data)))) + uint64(initial)
+	return uint32(sum&0xFFFFFFFF + sum>>32)
+}
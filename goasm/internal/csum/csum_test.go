@@ -0,0 +1,96 @@
+package csum
+
+import (
+	"bytes"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestChecksum(t *testing.T) {
+	for n := 0; n <= 4096; n++ {
+		data := make([]byte, n)
+		rand.Read(data)
+		expect := Reference(data)
+		if actual := Checksum(data); actual != expect {
+			t.Fatalf("%d bytes: expected %v, but got %v", n, expect, actual)
+		}
+		if n == 0 {
+			continue
+		}
+		if actual := ^Fold(PartialSum(data, 0)); actual != expect {
+			t.Fatalf("%d bytes: expected partial sum to finalize to %v, but got %v", n, expect, actual)
+		}
+	}
+
+	// all ones makes every single add carry out
+	saturated := bytes.Repeat([]byte{0xFF}, 1<<16+37)
+	if expect, actual := Reference(saturated), Checksum(saturated); actual != expect {
+		t.Errorf("saturated: expected %v, but got %v", expect, actual)
+	}
+	if expect, actual := Reference(saturated), ^Fold(PartialSum(saturated, 0xFFFFFFFF)); actual != expect {
+		t.Errorf("saturated: expected partial sum to finalize to %v, but got %v", expect, actual)
+	}
+}
+
+// counting is a Kernel counting the bytes handed to it.
+type counting struct{}
+
+var counted int
+
+func (counting) PartialSum(data []byte, initial uint32) uint32 {
+	counted += len(data)
+	return PartialSum(data, initial)
+}
+
+func TestDigest(t *testing.T) {
+	t.Run("hello", func(t *testing.T) {
+		var c Digest[Portable]
+		if c.Sum16() != 0 {
+			t.Errorf("Expected 0, but got %v", c.Sum16())
+		}
+		for _, chunk := range []string{"h", "el", "l", "o"} {
+			_, _ = c.Write([]byte(chunk))
+		}
+		if c.Sum16() != 48173 {
+			t.Errorf("Expected 48173, but got %v", c.Sum16())
+		}
+		if c.Count() != 5 {
+			t.Errorf("Expected a count of 5, but got %d", c.Count())
+		}
+		if sum := c.Sum([]byte{0xFF}); !bytes.Equal(sum, []byte{0xFF, byte(48173 >> 8), byte(48173 & 0xFF)}) {
+			t.Errorf("unexpected Sum output: %x", sum)
+		}
+		c.Reset()
+		if c.Sum16() != 0 {
+			t.Errorf("Expected 0 after reset, but got %v", c.Sum16())
+		}
+	})
+
+	t.Run("kernel", func(t *testing.T) {
+		counted = 0
+		var c Digest[counting]
+		_, _ = c.Write([]byte("hello world"))
+		// the odd byte waits for the next write
+		if counted != 10 {
+			t.Errorf("Expected the kernel to get 10 bytes, but got %d", counted)
+		}
+	})
+
+	for i := 0; i < 64; i++ {
+		data := make([]byte, rand.Intn(4096))
+		rand.Read(data)
+		expect := Reference(data)
+		t.Run("split/"+strconv.Itoa(len(data))+"b", func(t *testing.T) {
+			var c Digest[Portable]
+			for rest := data; len(rest) > 0; {
+				n := rand.Intn(len(rest)) + 1
+				_, _ = c.Write(rest[:n])
+				rest = rest[n:]
+			}
+			if actual := c.Sum16(); actual != expect {
+				t.Errorf("Expected %v, but got %v", expect, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package csum
+
+import "hash"
+
+var _ hash.Hash = (*Digest[Portable])(nil)
+
+// Kernel is the partial sum kernel a Digest hands its chunks to, a type of its own so
+// that the zero Digest already has it.
+type Kernel interface {
+	// PartialSum adds the ones' complement sum of data to initial, like PartialSum.
+	PartialSum(data []byte, initial uint32) uint32
+}
+
+// Portable is the Kernel of PartialSum.
+type Portable struct{}
+
+// PartialSum returns PartialSum(data, initial).
+func (Portable) PartialSum(data []byte, initial uint32) uint32 {
+	return PartialSum(data, initial)
+}
+
+// Digest is the running state of an RFC 1071 internet checksum.
+//
+// Data can be fed to it in any number of Write calls and Sum16 will return the same
+// value as a checksum of the concatenation of every write. An odd-length write
+// leaves its last byte dangling until the next write, so chunks don't need to be
+// aligned to 16 bit words. Each (even) chunk is handed to the kernel K.
+type Digest[K Kernel] struct {
+	sum      uint32
+	dangling byte
+	odd      bool
+	size     uint64
+}
+
+func (c *Digest[K]) add(word uint16) {
+	c.sum = uint32(Fold(c.sum)) + uint32(word)
+}
+
+// Write adds p to the running checksum. It never returns an error.
+func (c *Digest[K]) Write(p []byte) (n int, err error) {
+	n = len(p)
+	if n == 0 {
+		return 0, nil
+	}
+	c.size += uint64(n)
+
+	// pair the byte left over from the previous write with our first byte
+	if c.odd {
+		c.add(uint16(c.dangling)<<8 | uint16(p[0]))
+		c.odd = false
+		p = p[1:]
+	}
+
+	// hold on to the last byte if we'd otherwise misalign the next write
+	if len(p)%2 != 0 {
+		c.dangling = p[len(p)-1]
+		c.odd = true
+		p = p[:len(p)-1]
+	}
+
+	if len(p) > 0 {
+		var k K
+		c.sum = k.PartialSum(p, c.sum)
+	}
+
+	return n, nil
+}
+
+// Sum16 returns the checksum of everything written so far.
+// It does not change the underlying state.
+func (c *Digest[K]) Sum16() uint16 {
+	if c.size == 0 {
+		return 0
+	}
+	sum := uint32(Fold(c.sum))
+	if c.odd {
+		sum += uint32(c.dangling) << 8
+	}
+	return ^Fold(sum)
+}
+
+// Count returns the number of bytes written so far.
+func (c *Digest[K]) Count() uint64 {
+	return c.size
+}
+
+// Sum appends the big endian checksum to b and returns the resulting slice.
+func (c *Digest[K]) Sum(b []byte) []byte {
+	s := c.Sum16()
+	return append(b, byte(s>>8), byte(s))
+}
+
+// Reset clears the state so that it can be reused.
+func (c *Digest[K]) Reset() {
+	*c = Digest[K]{}
+}
+
+// Size returns the number of bytes Sum will append.
+func (c *Digest[K]) Size() int {
+	return 2
+}
+
+// BlockSize returns the size of the words the checksum operates on.
+func (c *Digest[K]) BlockSize() int {
+	return 2
+}
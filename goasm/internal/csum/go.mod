@@ -0,0 +1,3 @@
+module asm/internal/csum
+
+go 1.22.3
@@ -1,35 +1,25 @@
-//go:build amd64
+//go:build amd64 && !purego
 
 package asm
 
 import (
-	"bufio"
 	"bytes"
-	"context"
 	"fmt"
 	"os"
-	"os/exec"
-	"path/filepath"
+	"reflect"
 	"runtime"
 	"strconv"
 	"strings"
-	"syscall"
 	"testing"
-	"time"
 
 	"git.tcp.direct/kayos/common/entropy"
-)
+	"golang.org/x/sys/cpu"
 
-const (
-	goGen         = "go run asm.go -out checksum_amd64.s -stubs checksum_amd64.go"
-	testEarlyFail = "early_fail"
-	green         = "\033[32m"
-	red           = "\033[31m"
-	reset         = "\033[0m"
-	testPassed    = green + "\n\ntest passed: " + reset + "%v\n"
-	testFailed    = red + "\n\ntest failed: " + reset + "%v\n"
+	"asm/gen/asmtest"
 )
 
+const goGen = "go run asm.go -out checksum_amd64.s -stubs checksum_amd64.go"
+
 func TestRFC1071(t *testing.T) {
 	type test struct {
 		name   string
@@ -54,9 +44,9 @@ func TestRFC1071(t *testing.T) {
 		t.Run(testCase.name, func(t *testing.T) {
 			actual := rfc1071(testCase.input)
 			if actual != testCase.expect {
-				t.Errorf(testFailed, fmt.Sprintf("Expected %v, but got %s%v%s", testCase.expect, red, actual, reset))
+				t.Errorf(asmtest.Failed, fmt.Sprintf("Expected %v, but got %s%v%s", testCase.expect, asmtest.Red, actual, asmtest.Reset))
 			} else {
-				t.Logf(testPassed,
+				t.Logf(asmtest.Passed,
 					string(testCase.input)+": "+strconv.Itoa(int(testCase.expect))+"=="+strconv.Itoa(int(actual)))
 			}
 		})
@@ -64,65 +54,14 @@ func TestRFC1071(t *testing.T) {
 
 }
 
-func readPipe(ctx context.Context, pipePath string, u16bChan chan []byte, t *testing.T) {
-	tryRead := func() (goOn bool) {
-		f, err := os.OpenFile(pipePath, os.O_RDONLY, os.ModePerm)
-		if err != nil {
-			t.Errorf("%sfailed to open named pipe: %s%s", red, err, reset)
-			return true
-		}
-		defer func() {
-			if err = f.Close(); err != nil {
-				panic(err)
-			}
-		}()
-		b := make([]byte, 2)
-		if _, err = f.Read(b); err != nil {
-			t.Errorf("%sfailed to read from named pipe: %s%s", red, err, reset)
-			return true
-		}
-		u16bChan <- b
-		return false
-	}
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			if !tryRead() {
-				return
-			}
-		}
-	}
-}
-
-func setup(t *testing.T) {
-	for _, avo := range []string{"build", "reg", "operand"} {
-		cmd := exec.Command("go", "get", "-v", "github.com/mmcloughlin/avo/"+avo)
-		if cmdOut, err := cmd.CombinedOutput(); err != nil {
-			t.Fatalf("failed to get avo/%s: %s", avo, cmdOut)
-		}
-	}
+// harness generates test modes in a copy of the module, they never make it into the tree.
+var harness = &asmtest.Harness{
+	Generator: strings.Fields(goGen),
+	Asm:       "checksum_amd64.s",
+	Origins:   "checksum_amd64.json",
 }
 
 func TestASMChecksumComponents(t *testing.T) {
-	setup(t)
-
-	t.Cleanup(func() {
-		t.Setenv("ASM_TEST_MODE", "")
-		cmdFields := strings.Fields(goGen)
-		cmd := exec.Command(cmdFields[0], cmdFields[1:]...)
-		cmd.Env = append(cmd.Environ(), `ASM_TEST_MODE=`)
-		t.Logf("cleaning up with: %s", cmd.String())
-		if cmdOut, err := cmd.CombinedOutput(); err != nil {
-			t.Errorf("%scleanup failed: %s%s", red, cmdOut, reset)
-		}
-		cmd = exec.Command("go", "mod", "tidy", "-v")
-		if cmdOut, err := cmd.CombinedOutput(); err != nil {
-			t.Errorf("failed to tidy go modules: %s", cmdOut)
-		}
-	})
-
 	type asmTest struct {
 		name   string
 		mode   string
@@ -153,141 +92,50 @@ func TestASMChecksumComponents(t *testing.T) {
 
 	for _, mode := range testModes {
 		t.Run(mode.mode+"/"+mode.name, func(t *testing.T) {
-			t.Setenv("ASM_TEST_MODE", mode.mode)
-			cmdFields := append(strings.Fields(goGen), "-asmtest")
-			cmd := exec.Command(cmdFields[0], cmdFields[1:]...)
-			t.Logf("generating test ASM with: %s", cmd.String())
-			cmdOut, err := cmd.CombinedOutput()
-			if err != nil {
-				t.Fatalf("%sgeneration failed:\n %s%s", red, cmdOut, reset)
-			}
-			asmOut, err := os.ReadFile("checksum_amd64.s")
+			t.Parallel()
+			res, err := harness.Run(t, mode.mode, "checksum("+mode.input+")")
 			if err != nil {
-				t.Fatalf("failed to read generated ASM: %s", err)
+				t.Fatalf("%s%s%s", asmtest.Red, err, asmtest.Reset)
 			}
-			t.Logf("generated test ASM: \n%s", asmOut)
-
-			tmpDir := t.TempDir()
-			pipePath := filepath.Join(tmpDir, "checksum")
-			if err = syscall.Mkfifo(pipePath, 0777); err != nil {
-				t.Fatalf("failed to create named pipe: %s", err)
+			t.Logf("generated test ASM: \n%s", res.Asm)
+			if res.Exit != 0 {
+				t.Errorf(asmtest.Failed+"\n%s", fmt.Sprintf("exit status %d", res.Exit), res.Output)
 			}
-
-			test := `
-package asm
-import "testing"
-import "os"
-import "fmt"
-import "strings"
-func TestChecksumTmp(t *testing.T) {
-	data := ` + mode.input + `
-	expected := uint16(` + strconv.Itoa(int(mode.expect)) + `)
-	actual := checksum(data)
-	if actual != expected {
-		t.Errorf("` + red + `Expected %v, but got %v` + reset + `", expected, actual)
-	}
-	f, err := os.OpenFile("` + pipePath + `", os.O_WRONLY, os.ModePerm)
-	if err != nil {
-		t.Fatalf("` + red + `failed to open named pipe: %s` + reset + `", err)
-	}
-	actualS := strings.TrimSpace(fmt.Sprintf("%d", actual))
-	if _, err = f.WriteString(actualS+"\n"); err != nil {
-		t.Fatalf("` + red + `failed to write to named pipe: %s` + reset + `", err)
-	}
-	_ = f.Close()
-}
-`
-
-			if err = os.WriteFile("checksum_tmp_test.go", []byte(test), 0644); err != nil {
-				t.Fatalf("%sfailed to write test file: %s%s", red, err, reset)
-			}
-			defer func() {
-				if err = os.Remove("checksum_tmp_test.go"); err != nil {
-					panic(err)
+			if res.Failure != nil {
+				t.Logf("test failed at line %d: %s\n%s", res.Failure.Line, res.Failure.Message, strings.Join(res.Failure.Context, "\n"))
+				if res.Failure.Origin != "" {
+					t.Logf("emitted by %s", res.Failure.Origin)
 				}
-			}()
-
-			cmd = exec.Command("go", "test", "-run", "TestChecksumTmp")
-			var actualB []byte
-			var actual64 uint64
-			var actual uint16
-
-			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-			defer cancel()
-			u16bChan := make(chan []byte, 1)
-			go readPipe(ctx, pipePath, u16bChan, t)
-			var tOut []byte
-			var xerox *bufio.Scanner
-			if tOut, err = cmd.CombinedOutput(); err == nil {
-				goto breakout
-			}
-			cancel()
-			t.Errorf(testFailed+"\n%s", err, string(tOut))
-			if !strings.Contains(string(tOut), "checksum_amd64.s:") {
-				goto breakout
-			}
-			xerox = bufio.NewScanner(strings.NewReader(string(tOut)))
-			for xerox.Scan() {
-				if !strings.Contains(xerox.Text(), "checksum_amd64.s:") {
-					continue
-				}
-				split := strings.Split(xerox.Text(), ":")
-				lineStr := strings.TrimSpace(strings.Fields(split[1])[0])
-				line, _ := strconv.Atoi(lineStr)
-				t.Logf("test failed at line %d", line)
-				testXerox := bufio.NewScanner(strings.NewReader(string(asmOut)))
-				lc := 0
-				for testXerox.Scan() {
-					l := testXerox.Text()
-					lc++
-					if lc == line {
-						l += "; <------ FAILED HERE"
-					}
-					t.Logf("%s", l)
-				}
-				break
-
 			}
-		breakout:
-			select {
-			case <-ctx.Done():
-				t.Fatalf("timed out waiting for test to complete")
-			case actualB = <-u16bChan:
+			if res.Value == "" {
+				t.Fatalf(asmtest.Failed, "the test program never got to a checksum")
 			}
-
-			actualS := strings.TrimSpace(string(actualB))
-			if actual64, err = strconv.ParseUint(actualS, 10, 16); err != nil {
-				t.Errorf(testFailed+" failed to parse output: ", err)
+			actual, err := strconv.ParseUint(res.Value, 10, 16)
+			if err != nil {
+				t.Fatalf(asmtest.Failed+" failed to parse output: %s", err, res.Value)
 			}
-			actual = uint16(actual64)
-			if actual != mode.expect {
-				t.Errorf(testFailed, fmt.Sprintf("Expected %v, but got %v", mode.expect, actual))
+			if uint16(actual) != mode.expect {
+				t.Errorf(asmtest.Failed, fmt.Sprintf("Expected %v, but got %v", mode.expect, actual))
 			} else {
-				t.Logf(testPassed, actual)
+				t.Logf(asmtest.Passed, actual)
 			}
 		})
 	}
 }
 
 func TestASMChecksum(t *testing.T) {
-	setup(t)
-	t.Cleanup(func() {
-		cmd := exec.Command("go", "mod", "tidy", "-v")
-		if cmdOut, err := cmd.CombinedOutput(); err != nil {
-			t.Errorf("failed to tidy go modules: %s", cmdOut)
-		}
-	})
-	cmd := exec.Command(strings.Fields(goGen)[0], strings.Fields(goGen)[1:]...)
-	t.Logf("generating test ASM with: %s", cmd.String())
-	cmdOut, err := cmd.CombinedOutput()
+	generated, err := harness.Generate(t, "")
 	if err != nil {
-		t.Fatalf("generation failed: %s", cmdOut)
+		t.Fatalf("%s%s%s", asmtest.Red, err, asmtest.Reset)
 	}
-	asmOut, err := os.ReadFile("checksum_amd64.s")
+	t.Logf("generated ASM: \n%s", generated.Asm)
+	committed, err := os.ReadFile(harness.Asm)
 	if err != nil {
-		t.Fatalf("failed to read generated ASM: %s", err)
+		t.Fatal(err)
+	}
+	if !bytes.Equal(generated.Asm, committed) {
+		t.Errorf("%s isn't what %q generates, run go generate", harness.Asm, goGen)
 	}
-	t.Logf("generated ASM: \n%s", asmOut)
 	type test struct {
 		name   string
 		input  []byte
@@ -343,15 +191,60 @@ func TestASMChecksum(t *testing.T) {
 		t.Run(testCase.name, func(t *testing.T) {
 			actual := checksum(testCase.input)
 			if actual != testCase.expect {
-				t.Errorf(testFailed, fmt.Sprintf("Expected %v, but got %s%v%s", testCase.expect, red, actual, reset))
+				t.Errorf(asmtest.Failed, fmt.Sprintf("Expected %v, but got %s%v%s", testCase.expect, asmtest.Red, actual, asmtest.Reset))
 			} else {
-				t.Logf(testPassed,
+				t.Logf(asmtest.Passed,
 					string(testCase.input)+": "+strconv.Itoa(int(testCase.expect))+"=="+strconv.Itoa(int(actual)))
 			}
 		})
 	}
 }
 
+func TestWideChecksum(t *testing.T) {
+	type kernel struct {
+		name      string
+		available bool
+		f         func([]byte) uint16
+	}
+
+	kernels := []kernel{
+		{name: "adc", available: true, f: checksumADC},
+		{name: "adx", available: cpu.X86.HasADX, f: checksumADX},
+	}
+
+	// ChecksumParallel goes through checksumWide, which should be the best of them
+	wide := kernels[0]
+	if cpu.X86.HasADX {
+		wide = kernels[1]
+	}
+	if reflect.ValueOf(checksumWide).Pointer() != reflect.ValueOf(wide.f).Pointer() {
+		t.Errorf("Expected checksumWide to be the %s kernel", wide.name)
+	}
+
+	for _, k := range kernels {
+		t.Run(k.name, func(t *testing.T) {
+			if !k.available {
+				t.Skipf("skipping %s: not supported by this CPU", k.name)
+			}
+			// every length up to 4096 bytes hits each combination of unrolled loop and tail
+			for i := 0; i <= 4096; i++ {
+				r := entropy.AcquireRand()
+				val := make([]byte, i)
+				r.Read(val)
+				entropy.ReleaseRand(r)
+				if expect, actual := rfc1071(val), k.f(val); actual != expect {
+					t.Fatalf(asmtest.Failed, fmt.Sprintf("%d bytes: expected %v, but got %s%v%s", i, expect, asmtest.Red, actual, asmtest.Reset))
+				}
+			}
+			// all ones makes every single add carry out
+			saturated := bytes.Repeat([]byte{0xFF}, 1<<16+37)
+			if expect, actual := rfc1071(saturated), k.f(saturated); actual != expect {
+				t.Errorf(asmtest.Failed, fmt.Sprintf("saturated: expected %v, but got %s%v%s", expect, asmtest.Red, actual, asmtest.Reset))
+			}
+		})
+	}
+}
+
 func BenchmarkChecksum(b *testing.B) {
 	btests := [][]byte{
 		[]byte("yeet"),
@@ -378,11 +271,19 @@ func BenchmarkChecksum(b *testing.B) {
 			name: "goasm",
 			f:    checksum,
 		},
+		{
+			name: "goasm/adc",
+			f:    checksumADC,
+		},
+	}
+
+	if cpu.X86.HasADX {
+		candidates = append(candidates, candidate{name: "goasm/adx", f: checksumADX})
 	}
 
 	for _, cn := range candidates {
 		for _, data := range btests {
-			if cn.name == "goasm" && runtime.GOARCH != "amd64" {
+			if strings.HasPrefix(cn.name, "goasm") && runtime.GOARCH != "amd64" {
 				continue
 			}
 			dlen := len(data)
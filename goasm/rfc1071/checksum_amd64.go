@@ -1,8 +1,17 @@
 // Code generated by command: go run asm.go -out checksum_amd64.s -stubs checksum_amd64.go. DO NOT EDIT.
 
-//go:build amd64
+//go:build amd64 && !purego
 
 package asm
 
 // calculate RFC 1071 internet checksum for a byte slice
 func checksum(data []byte) (sum uint16)
+
+// partialSum returns the unfolded and uncomplemented ones' complement sum of data added to initial
+func partialSum(data []byte, initial uint32) (sum uint32)
+
+// checksumADC sums 64-bit words with an ADCQ carry chain, unrolled 4x
+func checksumADC(data []byte) (sum uint16)
+
+// checksumADX sums 64-bit words with two ADCX/ADOX carry chains, unrolled 8x. requires ADX
+func checksumADX(data []byte) (sum uint16)
@@ -1,6 +1,6 @@
 // Code generated by command: go run asm.go -out checksum_amd64.s -stubs checksum_amd64.go. DO NOT EDIT.
 
-//go:build amd64
+//go:build amd64 && !purego
 
 #include "textflag.h"
 
@@ -9,12 +9,8 @@ TEXT ·checksum(SB), NOSPLIT, $0-26
 	// initialize registers
 	XORQ  AX, AX
 	XORQ  CX, CX
-	XORQ  CX, CX
 	XORQ  DX, DX
-	XORQ  BX, BX
-	XORQ  SI, SI
-	XORL  CX, CX
-	MOVQ  data_base+0(FP), CX
+	MOVQ  data_base+0(FP), BX
 	MOVQ  data_len+8(FP), SI
 	TESTQ SI, SI
 	JZ    early_fail
@@ -23,17 +19,17 @@ loop:
 	TESTQ SI, SI
 	JZ    fin
 	CMPQ  SI, $0x02
-	JL    handle_odd
-	MOVB  (CX), DL
-	SHLW  $0x08, DX
-	MOVB  1(CX), BL
-	ORW   BX, DX
-	ADDQ  DX, AX
+	JB    handle_odd
+	MOVB  (BX), CL
+	SHLW  $0x08, CX
+	MOVB  1(BX), DL
+	ORW   DX, CX
+	ADDQ  CX, AX
 	CMPL  AX, $0x0000ffff
 	JA    adjust_sum
 
 nextb:
-	ADDQ $0x02, CX
+	ADDQ $0x02, BX
 	SUBQ $0x02, SI
 	JNC  loop
 
@@ -46,23 +42,210 @@ fin:
 
 early_fail:
 	XORW AX, AX
-	MOVW $0x0000, AX
 	MOVW AX, sum+24(FP)
 	RET
 
 handle_odd:
 	CMPQ SI, $0x01
 	JNE  fin
-	MOVB (CX), DL
-	SHLW $0x08, DX
-	ADDQ DX, AX
+	MOVB (BX), CL
+	SHLW $0x08, CX
+	ADDQ CX, AX
 	CMPQ AX, $0x0000ffff
 	JA   adjust_sum
 
 adjust_sum:
-	XORQ DI, DI
 	MOVQ AX, DI
 	ANDQ $0x0000ffff, AX
 	SHRQ $0x10, DI
 	ADDQ DI, AX
 	JMP  nextb
+
+// func partialSum(data []byte, initial uint32) (sum uint32)
+TEXT ·partialSum(SB), NOSPLIT, $0-36
+	// initialize registers
+	MOVL initial+24(FP), AX
+	MOVQ data_base+0(FP), DX
+	MOVQ data_len+8(FP), BX
+
+partial_loop:
+	CMPQ    BX, $0x02
+	JB      partial_odd
+	MOVWQZX (DX), CX
+	ROLW    $0x08, CX
+	ADDQ    CX, AX
+	ADDQ    $0x02, DX
+	SUBQ    $0x02, BX
+	JMP     partial_loop
+
+partial_odd:
+	TESTQ   BX, BX
+	JZ      partial_fold
+	MOVBQZX (DX), CX
+	SHLQ    $0x08, CX
+	ADDQ    CX, AX
+
+partial_fold:
+	MOVQ AX, CX
+	SHRQ $0x20, CX
+	ADDL CX, AX
+	ADCL $0x00, AX
+	MOVL AX, sum+32(FP)
+	RET
+
+// func checksumADC(data []byte) (sum uint16)
+TEXT ·checksumADC(SB), NOSPLIT, $0-26
+	// initialize registers
+	XORQ  AX, AX
+	XORQ  CX, CX
+	XORQ  DX, DX
+	XORQ  BX, BX
+	MOVQ  data_base+0(FP), BX
+	MOVQ  data_len+8(FP), SI
+	TESTQ SI, SI
+	JZ    early_fail
+
+adc_loop:
+	CMPQ SI, $0x20
+	JB   tail
+	ADDQ (BX), AX
+	ADCQ 8(BX), AX
+	ADCQ 16(BX), AX
+	ADCQ 24(BX), AX
+	ADCQ $0x00, DX
+	ADDQ $0x20, BX
+	SUBQ $0x20, SI
+	JMP  adc_loop
+
+tail:
+	ADDQ CX, AX
+	ADCQ $0x00, DX
+	ADDQ DX, AX
+	ADCQ $0x00, AX
+
+tail_quads:
+	CMPQ SI, $0x08
+	JB   tail_words
+	ADDQ (BX), AX
+	ADCQ $0x00, AX
+	ADDQ $0x08, BX
+	SUBQ $0x08, SI
+	JMP  tail_quads
+
+tail_words:
+	CMPQ    SI, $0x02
+	JB      tail_odd
+	MOVWQZX (BX), CX
+	ADDQ    CX, AX
+	ADCQ    $0x00, AX
+	ADDQ    $0x02, BX
+	SUBQ    $0x02, SI
+	JMP     tail_words
+
+tail_odd:
+	TESTQ   SI, SI
+	JZ      tail_fold
+	MOVBQZX (BX), CX
+	ADDQ    CX, AX
+	ADCQ    $0x00, AX
+
+tail_fold:
+	MOVQ AX, CX
+	SHRQ $0x20, CX
+	ADDL CX, AX
+	ADCL $0x00, AX
+	MOVL AX, CX
+	SHRL $0x10, CX
+	ADDW CX, AX
+	ADCW $0x00, AX
+	ROLW $0x08, AX
+	NOTW AX
+	MOVW AX, sum+24(FP)
+	RET
+
+early_fail:
+	XORW AX, AX
+	MOVW AX, sum+24(FP)
+	RET
+
+// func checksumADX(data []byte) (sum uint16)
+// Requires: ADX
+TEXT ·checksumADX(SB), NOSPLIT, $0-26
+	// initialize registers
+	XORQ  AX, AX
+	XORQ  CX, CX
+	XORQ  DX, DX
+	XORQ  BX, BX
+	MOVQ  data_base+0(FP), SI
+	MOVQ  data_len+8(FP), DI
+	TESTQ DI, DI
+	JZ    early_fail
+
+adx_loop:
+	CMPQ  DI, $0x40
+	JB    tail
+	XORQ  BX, BX
+	ADCXQ (SI), AX
+	ADOXQ 8(SI), CX
+	ADCXQ 16(SI), AX
+	ADOXQ 24(SI), CX
+	ADCXQ 32(SI), AX
+	ADOXQ 40(SI), CX
+	ADCXQ 48(SI), AX
+	ADOXQ 56(SI), CX
+	ADCXQ BX, DX
+	ADOXQ BX, DX
+	ADDQ  $0x40, SI
+	SUBQ  $0x40, DI
+	JMP   adx_loop
+
+tail:
+	ADDQ CX, AX
+	ADCQ $0x00, DX
+	ADDQ DX, AX
+	ADCQ $0x00, AX
+
+tail_quads:
+	CMPQ DI, $0x08
+	JB   tail_words
+	ADDQ (SI), AX
+	ADCQ $0x00, AX
+	ADDQ $0x08, SI
+	SUBQ $0x08, DI
+	JMP  tail_quads
+
+tail_words:
+	CMPQ    DI, $0x02
+	JB      tail_odd
+	MOVWQZX (SI), CX
+	ADDQ    CX, AX
+	ADCQ    $0x00, AX
+	ADDQ    $0x02, SI
+	SUBQ    $0x02, DI
+	JMP     tail_words
+
+tail_odd:
+	TESTQ   DI, DI
+	JZ      tail_fold
+	MOVBQZX (SI), CX
+	ADDQ    CX, AX
+	ADCQ    $0x00, AX
+
+tail_fold:
+	MOVQ AX, CX
+	SHRQ $0x20, CX
+	ADDL CX, AX
+	ADCL $0x00, AX
+	MOVL AX, CX
+	SHRL $0x10, CX
+	ADDW CX, AX
+	ADCW $0x00, AX
+	ROLW $0x08, AX
+	NOTW AX
+	MOVW AX, sum+24(FP)
+	RET
+
+early_fail:
+	XORW AX, AX
+	MOVW AX, sum+24(FP)
+	RET
@@ -0,0 +1,10 @@
+package asm
+
+import "asm/internal/csum"
+
+// the portable code is shared with the simd package, see asm/internal/csum
+var (
+	rfc1071           = csum.Reference
+	checksumGeneric   = csum.Checksum
+	partialSumGeneric = csum.PartialSum
+)
@@ -0,0 +1,16 @@
+//go:build !amd64 || purego
+
+package asm
+
+// calculate RFC 1071 internet checksum for a byte slice
+func checksum(data []byte) (sum uint16) {
+	return checksumGeneric(data)
+}
+
+// partialSum returns the unfolded and uncomplemented ones' complement sum of data added to initial
+func partialSum(data []byte, initial uint32) (sum uint32) {
+	return partialSumGeneric(data, initial)
+}
+
+// checksumWide is the fastest kernel for a whole buffer, there are no wide ones here.
+var checksumWide = checksumGeneric
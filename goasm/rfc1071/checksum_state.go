@@ -0,0 +1,29 @@
+package asm
+
+import (
+	"hash"
+
+	"asm/internal/csum"
+)
+
+var _ hash.Hash = (*Checksum)(nil)
+
+// Checksum is the running state of an RFC 1071 internet checksum.
+//
+// Data can be fed to it in any number of Write calls and Sum16 will return the same
+// value as checksum would for the concatenation of every write. An odd-length write
+// leaves its last byte dangling until the next write, so chunks don't need to be
+// aligned to 16 bit words. Each (even) chunk is handed to the assembly partialSum kernel.
+type Checksum = csum.Digest[kernel]
+
+// kernel hands the chunks of a Checksum to partialSum.
+type kernel struct{}
+
+func (kernel) PartialSum(data []byte, initial uint32) uint32 {
+	return partialSum(data, initial)
+}
+
+// NewChecksum returns a new, zeroed Checksum state.
+func NewChecksum() *Checksum {
+	return &Checksum{}
+}
@@ -0,0 +1,192 @@
+package asm
+
+import (
+	"bytes"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestChecksumState(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		c := NewChecksum()
+		if c.Sum16() != 0 {
+			t.Errorf("Expected 0, but got %v", c.Sum16())
+		}
+		_, _ = c.Write(nil)
+		if c.Sum16() != 0 {
+			t.Errorf("Expected 0 after empty write, but got %v", c.Sum16())
+		}
+	})
+
+	t.Run("hello", func(t *testing.T) {
+		c := NewChecksum()
+		for _, chunk := range []string{"h", "el", "l", "o"} {
+			_, _ = c.Write([]byte(chunk))
+		}
+		if c.Sum16() != 48173 {
+			t.Errorf("Expected 48173, but got %v", c.Sum16())
+		}
+		if sum := c.Sum([]byte{0xFF}); !bytes.Equal(sum, []byte{0xFF, byte(48173 >> 8), byte(48173 & 0xFF)}) {
+			t.Errorf("unexpected Sum output: %x", sum)
+		}
+		c.Reset()
+		if c.Sum16() != 0 {
+			t.Errorf("Expected 0 after reset, but got %v", c.Sum16())
+		}
+	})
+
+	for i := 0; i < 64; i++ {
+		data := make([]byte, rand.Intn(4096))
+		rand.Read(data)
+		expect := rfc1071(data)
+		t.Run("split/"+strconv.Itoa(len(data))+"b", func(t *testing.T) {
+			c := NewChecksum()
+			for rest := data; len(rest) > 0; {
+				n := rand.Intn(len(rest)) + 1
+				_, _ = c.Write(rest[:n])
+				rest = rest[n:]
+			}
+			if actual := c.Sum16(); actual != expect {
+				t.Errorf("Expected %v, but got %v", expect, actual)
+			}
+		})
+	}
+}
+
+func TestChecksumParallel(t *testing.T) {
+	defer func(threshold int) { ParallelThreshold = threshold }(ParallelThreshold)
+	// split everything, so that small inputs end up in more chunks than there are workers
+	ParallelThreshold = 0
+
+	if actual := ChecksumParallel(nil, 4); actual != 0 {
+		t.Errorf("Expected 0 for no data, but got %v", actual)
+	}
+
+	for _, workers := range []int{0, 1, 2, 3, 7, 64} {
+		for _, n := range []int{1, 2, 3, 63, 64, 65, 1000, 4095, 1<<16 + 1} {
+			data := make([]byte, n)
+			rand.Read(data)
+			if expect, actual := rfc1071(data), ChecksumParallel(data, workers); actual != expect {
+				t.Errorf("%d workers, %d bytes: expected %v, but got %v", workers, n, expect, actual)
+			}
+		}
+	}
+
+	// enough 0xFF chunks for the combined sum to carry past 32 bits
+	saturated := bytes.Repeat([]byte{0xFF}, 1<<20+37)
+	if expect, actual := rfc1071(saturated), ChecksumParallel(saturated, 64); actual != expect {
+		t.Errorf("saturated: expected %v, but got %v", expect, actual)
+	}
+}
+
+func TestPartialSum(t *testing.T) {
+	if Finalize(partialSum(nil, 0)) != 0xFFFF {
+		t.Errorf("Expected 0xFFFF for an empty partial sum, but got %v", Finalize(partialSum(nil, 0)))
+	}
+
+	for i := 0; i < 64; i++ {
+		data := make([]byte, rand.Intn(4096)+1)
+		rand.Read(data)
+		expect := rfc1071(data)
+		t.Run(strconv.Itoa(len(data))+"b", func(t *testing.T) {
+			if actual := Finalize(partialSum(data, 0)); actual != expect {
+				t.Errorf("Expected %v, but got %v", expect, actual)
+			}
+
+			// compose the sum from even segments, carrying the unfolded partial sum along
+			var sum uint32
+			for rest := data; len(rest) > 0; {
+				n := (rand.Intn(len(rest)) + 2) &^ 1
+				if n > len(rest) {
+					n = len(rest)
+				}
+				sum = partialSum(rest[:n], sum)
+				rest = rest[n:]
+			}
+			if actual := Finalize(sum); actual != expect {
+				t.Errorf("Expected %v from segments, but got %v", expect, actual)
+			}
+		})
+	}
+}
+
+func TestFold(t *testing.T) {
+	for _, tc := range []struct {
+		sum  uint32
+		fold uint16
+	}{
+		{0, 0},
+		{0xFFFF, 0xFFFF},
+		{0x10000, 1},
+		{0x1FFFE, 0xFFFF},
+		{0xFFFFFFFF, 0xFFFF},
+		{0x12345678, 0x68AC},
+	} {
+		if actual := Fold(tc.sum); actual != tc.fold {
+			t.Errorf("Fold(%#x): expected %#x, but got %#x", tc.sum, tc.fold, actual)
+		}
+		if actual := Finalize(tc.sum); actual != ^tc.fold {
+			t.Errorf("Finalize(%#x): expected %#x, but got %#x", tc.sum, ^tc.fold, actual)
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	for i := 0; i < 256; i++ {
+		data := make([]byte, 20+rand.Intn(1480))
+		rand.Read(data)
+		old := rfc1071(data)
+		offset := rand.Intn(len(data)-4) &^ 1
+
+		switch i % 3 {
+		case 0:
+			oldField := uint16(data[offset])<<8 | uint16(data[offset+1])
+			newField := uint16(rand.Intn(0x10000))
+			data[offset], data[offset+1] = byte(newField>>8), byte(newField)
+			if expect, actual := rfc1071(data), Update16(old, oldField, newField); actual != expect {
+				t.Errorf("Update16: expected %v, but got %v", expect, actual)
+			}
+		case 1:
+			oldField := uint32(data[offset])<<24 | uint32(data[offset+1])<<16 | uint32(data[offset+2])<<8 | uint32(data[offset+3])
+			newField := rand.Uint32()
+			data[offset], data[offset+1], data[offset+2], data[offset+3] =
+				byte(newField>>24), byte(newField>>16), byte(newField>>8), byte(newField)
+			if expect, actual := rfc1071(data), Update32(old, oldField, newField); actual != expect {
+				t.Errorf("Update32: expected %v, but got %v", expect, actual)
+			}
+		case 2:
+			// odd lengths are fine too, as long as the replacement starts at an even offset
+			n := rand.Intn(len(data)-offset) + 1
+			oldBytes := append([]byte(nil), data[offset:offset+n]...)
+			rand.Read(data[offset : offset+n])
+			if expect, actual := rfc1071(data), UpdateBytes(old, oldBytes, data[offset:offset+n]); actual != expect {
+				t.Errorf("UpdateBytes: expected %v, but got %v", expect, actual)
+			}
+		}
+	}
+}
+
+func TestGeneric(t *testing.T) {
+	for n := 0; n <= 4096; n++ {
+		data := make([]byte, n)
+		rand.Read(data)
+		expect := rfc1071(data)
+		if actual := checksumGeneric(data); actual != expect {
+			t.Fatalf("%d bytes: expected %v, but got %v", n, expect, actual)
+		}
+		if n == 0 {
+			continue
+		}
+		initial := rand.Uint32()
+		if expect, actual := Fold(partialSum(data, initial)), Fold(partialSumGeneric(data, initial)); actual != expect {
+			t.Fatalf("%d bytes: expected partial sum %v, but got %v", n, expect, actual)
+		}
+	}
+
+	// all ones makes every single add carry out
+	saturated := bytes.Repeat([]byte{0xFF}, 1<<16+37)
+	if expect, actual := rfc1071(saturated), checksumGeneric(saturated); actual != expect {
+		t.Errorf("saturated: expected %v, but got %v", expect, actual)
+	}
+}
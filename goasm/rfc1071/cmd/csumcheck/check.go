@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+
+	"asm"
+	"asm/ipv4"
+	"asm/pcap"
+)
+
+// verdict is what we think of a checksum.
+type verdict int
+
+const (
+	verdictOK verdict = iota
+	verdictBad
+	// verdictOffload is a mismatch on a packet that most likely left the capturing host
+	// before the NIC filled the checksum in (TX checksum offload). Linux leaves the folded
+	// pseudo-header sum in the field for the NIC to finish, other stacks leave zeros.
+	verdictOffload
+)
+
+func (v verdict) String() string {
+	switch v {
+	case verdictBad:
+		return "bad"
+	case verdictOffload:
+		return "offload"
+	default:
+		return "ok"
+	}
+}
+
+// result is a single verified checksum.
+type result struct {
+	layer    string // ipv4, tcp, udp, icmp or icmpv6
+	checksum uint16 // what the packet carries
+	expected uint16 // what it should carry
+	verdict  verdict
+}
+
+// decoded is everything we got out of one packet.
+type decoded struct {
+	flow    string
+	results []result
+	// skipped says why some checksum couldn't be verified, if one couldn't
+	skipped string
+}
+
+// decode walks the link, network and transport headers of p and verifies every checksum on the way.
+func decode(p *pcap.Packet) decoded {
+	etherType, b, dir, err := p.Network()
+	if err != nil {
+		return decoded{skipped: err.Error()}
+	}
+
+	switch etherType {
+	case pcap.EtherTypeIPv4:
+		return decodeIPv4(b, dir)
+	case pcap.EtherTypeIPv6:
+		return decodeIPv6(b, dir)
+	default:
+		return decoded{skipped: fmt.Sprintf("ethertype %#04x", etherType)}
+	}
+}
+
+func decodeIPv4(b []byte, dir pcap.Direction) decoded {
+	if len(b) < ipv4.HeaderLen || b[0]>>4 != ipv4.Version {
+		return decoded{skipped: "truncated or malformed ipv4 header"}
+	}
+	ihl := int(b[0]&0x0F) * 4
+	total := int(binary.BigEndian.Uint16(b[2:4]))
+	if ihl < ipv4.HeaderLen || len(b) < ihl || total < ihl {
+		return decoded{skipped: "truncated or malformed ipv4 header"}
+	}
+	src, dst := netip.AddrFrom4([4]byte(b[12:16])), netip.AddrFrom4([4]byte(b[16:20]))
+	proto := b[9]
+
+	var d decoded
+	got := binary.BigEndian.Uint16(b[10:12])
+	if ipv4.Checksum(b[:ihl]) != 0 {
+		var hdr [ipv4.MaxHeaderLen]byte
+		copy(hdr[:], b[:ihl])
+		hdr[10], hdr[11] = 0, 0
+		expected := ipv4.Checksum(hdr[:ihl])
+		d.results = append(d.results, result{layer: "ipv4", checksum: got, expected: expected, verdict: judge(dir, got, 0)})
+	} else {
+		d.results = append(d.results, result{layer: "ipv4", checksum: got, expected: got})
+	}
+
+	payload := b[ihl:min(total, len(b))]
+	frag := binary.BigEndian.Uint16(b[6:8])
+	d.flow = flow(proto, src, dst, payload)
+	switch {
+	case frag&0x1FFF != 0 || frag&0x2000 != 0:
+		// the transport checksum covers the reassembled datagram
+		d.skipped = "fragment"
+	case len(b) < total:
+		d.skipped = "truncated capture"
+	default:
+		pseudo := asm.PseudoHeaderIPv4(src.As4(), dst.As4(), proto, uint16(len(payload)))
+		d.transport(proto, payload, pseudo, false, dir)
+	}
+	return d
+}
+
+func decodeIPv6(b []byte, dir pcap.Direction) decoded {
+	if len(b) < 40 || b[0]>>4 != 6 {
+		return decoded{skipped: "truncated or malformed ipv6 header"}
+	}
+	src, dst := netip.AddrFrom16([16]byte(b[8:24])), netip.AddrFrom16([16]byte(b[24:40]))
+	end := 40 + int(binary.BigEndian.Uint16(b[4:6]))
+	truncated := len(b) < end
+	b = b[:min(end, len(b))]
+
+	next, off := b[6], 40
+	// hop-by-hop and destination options
+	for next == 0 || next == 60 {
+		if len(b) < off+8 {
+			return decoded{flow: flow(next, src, dst, nil), skipped: "truncated extension header"}
+		}
+		next, off = b[off], off+(int(b[off+1])+1)*8
+	}
+	switch next {
+	case 44:
+		return decoded{flow: flow(next, src, dst, nil), skipped: "fragment"}
+	case 43, 50, 51:
+		// routing headers change the pseudo-header destination, ESP and AH hide the rest
+		return decoded{flow: flow(next, src, dst, nil), skipped: "unsupported extension header"}
+	}
+	if len(b) < off {
+		return decoded{flow: flow(next, src, dst, nil), skipped: "truncated extension header"}
+	}
+
+	payload := b[off:]
+	d := decoded{flow: flow(next, src, dst, payload)}
+	if truncated {
+		d.skipped = "truncated capture"
+		return d
+	}
+	pseudo := asm.PseudoHeaderIPv6(src.As16(), dst.As16(), next, uint32(len(payload)))
+	d.transport(next, payload, pseudo, true, dir)
+	return d
+}
+
+// transport verifies the TCP, UDP, ICMP or ICMPv6 checksum of payload.
+func (d *decoded) transport(proto uint8, payload []byte, pseudo uint32, v6 bool, dir pcap.Direction) {
+	var (
+		layer  string
+		off    int // of the checksum field
+		hdrLen int
+	)
+	switch {
+	case proto == asm.ProtocolTCP:
+		layer, off, hdrLen = "tcp", 16, 20
+	case proto == asm.ProtocolUDP:
+		layer, off, hdrLen = "udp", 6, 8
+	case proto == asm.ProtocolICMP && !v6:
+		// no pseudo-header for ICMP
+		layer, off, hdrLen, pseudo = "icmp", 2, 8, 0
+	case proto == asm.ProtocolICMPv6 && v6:
+		layer, off, hdrLen = "icmpv6", 2, 4
+	default:
+		d.skipped = fmt.Sprintf("protocol %d", proto)
+		return
+	}
+	if len(payload) < hdrLen {
+		d.skipped = "truncated " + layer + " header"
+		return
+	}
+
+	got := binary.BigEndian.Uint16(payload[off : off+2])
+	if layer == "udp" && got == 0 && !v6 {
+		// the sender didn't compute one, which IPv4 allows
+		d.skipped = "udp without checksum"
+		return
+	}
+	if asm.Finalize(asm.PartialSum(payload, pseudo)) == 0 {
+		d.results = append(d.results, result{layer: layer, checksum: got, expected: got})
+		return
+	}
+
+	// the field sits at an even offset, so we can sum around it
+	expected := asm.Finalize(asm.PartialSum(payload[off+2:], asm.PartialSum(payload[:off], pseudo)))
+	if layer == "udp" && expected == 0 {
+		expected = 0xFFFF
+	}
+	d.results = append(d.results, result{layer: layer, checksum: got, expected: expected, verdict: judge(dir, got, pseudo)})
+}
+
+// judge tells a broken checksum from a checksum offload artifact. A packet the capture says
+// was outbound gets the benefit of the doubt, one it says was inbound never does, and for
+// the rest it comes down to whether the field holds what an offloading stack leaves there.
+func judge(dir pcap.Direction, got uint16, pseudo uint32) verdict {
+	switch dir {
+	case pcap.DirectionOutbound:
+		return verdictOffload
+	case pcap.DirectionInbound:
+		return verdictBad
+	}
+	if got == 0 || (pseudo != 0 && (got == asm.Fold(pseudo) || got == ^asm.Fold(pseudo))) {
+		return verdictOffload
+	}
+	return verdictBad
+}
+
+// flow names the direction of a conversation, with ports for TCP and UDP.
+func flow(proto uint8, src, dst netip.Addr, payload []byte) string {
+	var name string
+	switch proto {
+	case asm.ProtocolTCP:
+		name = "tcp"
+	case asm.ProtocolUDP:
+		name = "udp"
+	case asm.ProtocolICMP:
+		name = "icmp"
+	case asm.ProtocolICMPv6:
+		name = "icmpv6"
+	default:
+		return fmt.Sprintf("proto-%d %s > %s", proto, src, dst)
+	}
+	if (proto == asm.ProtocolTCP || proto == asm.ProtocolUDP) && len(payload) >= 4 {
+		sport, dport := binary.BigEndian.Uint16(payload[0:2]), binary.BigEndian.Uint16(payload[2:4])
+		return fmt.Sprintf("%s %s > %s", name, netip.AddrPortFrom(src, sport), netip.AddrPortFrom(dst, dport))
+	}
+	return fmt.Sprintf("%s %s > %s", name, src, dst)
+}
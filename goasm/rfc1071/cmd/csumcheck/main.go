@@ -0,0 +1,179 @@
+// Command csumcheck audits pcap and pcapng captures for bad IPv4, TCP, UDP, ICMP and ICMPv6
+// checksums, and prints a per-flow report of the mismatches it finds.
+//
+//	csumcheck [-v] [-strict] capture.pcap...
+//
+// Outgoing packets are often captured before the NIC fills their checksums in (TX checksum
+// offload), those mismatches are reported separately as likely offload artifacts and don't
+// count as failures unless -strict is given. The exit status is 1 if any checksum is bad
+// and 2 if a capture can't be read.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"asm/pcap"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// flowStats is the tally for one direction of a conversation.
+type flowStats struct {
+	name     string
+	packets  int
+	bad      int
+	offloads int
+}
+
+// report is the tally for one capture.
+type report struct {
+	packets  int
+	verified int
+	bad      int
+	offloads int
+	skipped  map[string]int
+	flows    map[string]*flowStats
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("csumcheck", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	verbose := fs.Bool("v", false, "print every mismatch and why checksums were skipped")
+	strict := fs.Bool("strict", false, "count likely offload artifacts as bad checksums")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: csumcheck [-v] [-strict] capture.pcap...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	status := 0
+	for _, name := range fs.Args() {
+		r, err := check(name, stdout, *verbose)
+		if err != nil {
+			fmt.Fprintf(stderr, "csumcheck: %s: %v\n", name, err)
+			status = 2
+			continue
+		}
+		r.print(name, stdout, *verbose)
+		if status == 0 && (r.bad > 0 || (*strict && r.offloads > 0)) {
+			status = 1
+		}
+	}
+	return status
+}
+
+// check verifies every packet in the named capture. With verbose set, each mismatch
+// is printed as it's found.
+func check(name string, out io.Writer, verbose bool) (*report, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	pr, err := pcap.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &report{skipped: map[string]int{}, flows: map[string]*flowStats{}}
+	for {
+		p, err := pr.Next()
+		if errors.Is(err, io.EOF) {
+			return r, nil
+		}
+		if err != nil {
+			return r, fmt.Errorf("packet %d: %w", r.packets+1, err)
+		}
+		r.packets++
+
+		d := decode(p)
+		if d.skipped != "" {
+			r.skipped[d.skipped]++
+		}
+		if d.flow == "" {
+			continue
+		}
+		fl := r.flows[d.flow]
+		if fl == nil {
+			fl = &flowStats{name: d.flow}
+			r.flows[d.flow] = fl
+		}
+		fl.packets++
+		for _, res := range d.results {
+			r.verified++
+			switch res.verdict {
+			case verdictOK:
+				continue
+			case verdictBad:
+				r.bad++
+				fl.bad++
+			case verdictOffload:
+				r.offloads++
+				fl.offloads++
+			}
+			if verbose {
+				fmt.Fprintf(out, "#%d %s: %s checksum %#04x, expected %#04x (%s)\n",
+					r.packets, d.flow, res.layer, res.checksum, res.expected, res.verdict)
+			}
+		}
+	}
+}
+
+func (r *report) print(name string, out io.Writer, verbose bool) {
+	skipped := 0
+	for _, n := range r.skipped {
+		skipped += n
+	}
+	fmt.Fprintf(out, "%s: %d packets, %d checksums verified, %d bad, %d likely offload artifacts, %d packets not fully verified\n",
+		name, r.packets, r.verified, r.bad, r.offloads, skipped)
+
+	if verbose && skipped > 0 {
+		reasons := make([]string, 0, len(r.skipped))
+		for reason := range r.skipped {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+		for _, reason := range reasons {
+			fmt.Fprintf(out, "  skipped %d: %s\n", r.skipped[reason], reason)
+		}
+	}
+
+	var flows []*flowStats
+	for _, fl := range r.flows {
+		if fl.bad > 0 || fl.offloads > 0 {
+			flows = append(flows, fl)
+		}
+	}
+	if len(flows) == 0 {
+		return
+	}
+	// worst first, then by name so the output is stable
+	sort.Slice(flows, func(i, j int) bool {
+		if flows[i].bad != flows[j].bad {
+			return flows[i].bad > flows[j].bad
+		}
+		return flows[i].name < flows[j].name
+	})
+
+	fmt.Fprintln(out)
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "flow\tpackets\tbad\toffload\t")
+	for _, fl := range flows {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t\n", fl.name, fl.packets, fl.bad, fl.offloads)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"asm"
+	"asm/pcap"
+)
+
+const testdata = "../../pcap/testdata/"
+
+func TestRun(t *testing.T) {
+	for _, name := range []string{"capture.pcap", "capture.pcapng"} {
+		t.Run(name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if status := run([]string{"-v", testdata + name}, &stdout, &stderr); status != 1 {
+				t.Fatalf("Expected exit status 1, but got %d: %s", status, stderr.String())
+			}
+			out := stdout.String()
+			for _, expect := range []string{
+				"26 checksums verified, 4 bad, 1 likely offload artifacts, 4 packets not fully verified",
+				"#3 tcp 192.168.1.10:51000 > 93.184.216.34:443: tcp checksum 0xf7cc, expected 0x941e (offload)",
+				"#4 tcp 93.184.216.34:443 > 192.168.1.10:51000: tcp checksum 0x509c, expected 0x12de (bad)",
+				"#9 icmp 93.184.216.34 > 192.168.1.10: icmp checksum 0x66bc, expected 0x24fe (bad)",
+				"#11 udp [2001:db8:1::34]:123 > [2001:db8::10]:52001: udp checksum 0x9a68, expected 0xd82a (bad)",
+				"#13 udp 93.184.216.34:443 > 192.168.1.10:51001: ipv4 checksum 0x300d, expected 0x310c (bad)",
+				"skipped 1: fragment",
+				"skipped 1: truncated capture",
+				"skipped 1: udp without checksum",
+			} {
+				// the pcapng file has an extra copy of the first packet at the end
+				if name == "capture.pcapng" {
+					expect = strings.Replace(expect, "26 checksums", "28 checksums", 1)
+				}
+				if !strings.Contains(out, expect) {
+					t.Errorf("Expected output to contain %q, got:\n%s", expect, out)
+				}
+			}
+			// the flow with the offloaded packet is listed after the ones with bad checksums
+			if strings.Index(out, "tcp 192.168.1.10:51000 > 93.184.216.34:443  ") < strings.Index(out, "udp 93.184.216.34:443 > 192.168.1.10:51001  ") {
+				t.Errorf("flows aren't sorted worst first:\n%s", out)
+			}
+		})
+	}
+}
+
+func TestJudge(t *testing.T) {
+	pseudo := asm.PseudoHeaderIPv4([4]byte{192, 168, 1, 10}, [4]byte{93, 184, 216, 34}, asm.ProtocolTCP, 57)
+	tests := []struct {
+		dir    pcap.Direction
+		got    uint16
+		expect verdict
+	}{
+		{pcap.DirectionOutbound, 0x1234, verdictOffload},
+		{pcap.DirectionInbound, asm.Fold(pseudo), verdictBad},
+		{pcap.DirectionUnknown, asm.Fold(pseudo), verdictOffload},
+		{pcap.DirectionUnknown, ^asm.Fold(pseudo), verdictOffload},
+		{pcap.DirectionUnknown, 0, verdictOffload},
+		{pcap.DirectionUnknown, 0x1234, verdictBad},
+	}
+	for _, tc := range tests {
+		if actual := judge(tc.dir, tc.got, pseudo); actual != tc.expect {
+			t.Errorf("%s %#04x: expected %s, but got %s", tc.dir, tc.got, tc.expect, actual)
+		}
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if status := run(nil, &stdout, &stderr); status != 2 {
+		t.Errorf("Expected exit status 2 without arguments, but got %d", status)
+	}
+	if status := run([]string{"main.go"}, &stdout, &stderr); status != 2 || !strings.Contains(stderr.String(), "not a pcap") {
+		t.Errorf("Expected exit status 2 for a non-capture, but got %d: %s", status, stderr.String())
+	}
+}
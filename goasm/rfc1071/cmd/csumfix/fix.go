@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/binary"
+	"net/netip"
+
+	"asm"
+	"asm/ipv4"
+	"asm/pcap"
+)
+
+// fixer rewrites addresses and checksums of packets in place.
+type fixer struct {
+	mapping map[netip.Addr]netip.Addr
+	// full turns off incremental updates, every checksum we can recompute gets recomputed
+	full bool
+
+	packets     int
+	rewritten   int // addresses
+	incremental int // checksums updated with RFC 1624
+	recomputed  int // checksums computed from scratch, because they were wrong or full is set
+	unfixable   int // checksums that needed recomputing but the data they cover isn't all there
+}
+
+// fix rewrites p.Data.
+func (f *fixer) fix(p *pcap.Packet) {
+	f.packets++
+	etherType, b, _, err := p.Network()
+	if err != nil {
+		return
+	}
+	switch etherType {
+	case pcap.EtherTypeIPv4:
+		f.fixIPv4(b)
+	case pcap.EtherTypeIPv6:
+		f.fixIPv6(b)
+	case etherTypeARP:
+		f.fixARP(b)
+	}
+}
+
+const etherTypeARP = 0x0806
+
+// fixARP rewrites the sender and target addresses of an IPv4 over Ethernet ARP packet,
+// there's no checksum to fix but the addresses would give the mapping away.
+func (f *fixer) fixARP(b []byte) {
+	if len(b) < 28 || binary.BigEndian.Uint16(b[2:4]) != pcap.EtherTypeIPv4 || b[4] != 6 || b[5] != 4 {
+		return
+	}
+	f.rewrite(b[14:18])
+	f.rewrite(b[24:28])
+}
+
+// rewrite maps the address in b, which is 4 or 16 bytes long, and returns the old and the
+// new address bytes, which are the same if the address isn't in the mapping.
+func (f *fixer) rewrite(b []byte) (old, new []byte) {
+	old = append([]byte(nil), b...)
+	addr, _ := netip.AddrFromSlice(b)
+	to, ok := f.mapping[addr]
+	if !ok {
+		return old, old
+	}
+	copy(b, to.AsSlice())
+	f.rewritten++
+	return old, b
+}
+
+func (f *fixer) fixIPv4(b []byte) {
+	if len(b) < ipv4.HeaderLen || b[0]>>4 != ipv4.Version {
+		return
+	}
+	ihl := int(b[0]&0x0F) * 4
+	total := int(binary.BigEndian.Uint16(b[2:4]))
+	if ihl < ipv4.HeaderLen || len(b) < ihl || total < ihl {
+		return
+	}
+	hdr := b[:ihl]
+	hdrOK := ipv4.Checksum(hdr) == 0
+
+	proto, frag := b[9], binary.BigEndian.Uint16(b[6:8])
+	payload := b[ihl:min(total, len(b))]
+	// the transport checksum covers the whole reassembled datagram, all of which
+	// we only have if this isn't a fragment and the capture wasn't snapped
+	complete := frag&0x3FFF == 0 && len(b) >= total
+	if frag&0x1FFF != 0 {
+		// no transport header in later fragments
+		payload = nil
+	}
+
+	src, dst := [4]byte(b[12:16]), [4]byte(b[16:20])
+	t := f.transport(proto, payload, asm.PseudoHeaderIPv4(src, dst, proto, uint16(total-ihl)), complete, false)
+
+	oldSrc, newSrc := f.rewrite(b[12:16])
+	oldDst, newDst := f.rewrite(b[16:20])
+
+	// the header is always complete, so it's incremental only if it was right to begin with
+	sum := binary.BigEndian.Uint16(b[10:12])
+	switch {
+	case !hdrOK || f.full:
+		b[10], b[11] = 0, 0
+		sum = ipv4.Checksum(hdr)
+		f.recomputed++
+	case string(oldSrc) != string(newSrc) || string(oldDst) != string(newDst):
+		sum = asm.UpdateBytes(asm.UpdateBytes(sum, oldSrc, newSrc), oldDst, newDst)
+		f.incremental++
+	}
+	binary.BigEndian.PutUint16(b[10:12], sum)
+
+	t.finish(f, [][2][]byte{{oldSrc, newSrc}, {oldDst, newDst}}, func() uint32 {
+		return asm.PseudoHeaderIPv4([4]byte(newSrc), [4]byte(newDst), proto, uint16(total-ihl))
+	})
+}
+
+func (f *fixer) fixIPv6(b []byte) {
+	if len(b) < 40 || b[0]>>4 != 6 {
+		return
+	}
+	end := 40 + int(binary.BigEndian.Uint16(b[4:6]))
+	complete := len(b) >= end
+	b = b[:min(end, len(b))]
+
+	next, off := b[6], 40
+	for {
+		switch next {
+		case 0, 60: // hop-by-hop and destination options
+			if len(b) < off+8 {
+				next = 59 // no next header, as far as we're concerned
+				break
+			}
+			next, off = b[off], off+(int(b[off+1])+1)*8
+			continue
+		case 44:
+			if len(b) < off+8 {
+				next = 59
+				break
+			}
+			// only the first fragment has the transport header, and none of them the whole datagram
+			complete = false
+			if binary.BigEndian.Uint16(b[off+2:off+4])&0xFFF8 != 0 {
+				next = 59
+				break
+			}
+			next, off = b[off], off+8
+			continue
+		}
+		break
+	}
+	var payload []byte
+	if len(b) >= off && next != 59 {
+		payload = b[off:]
+	}
+
+	// routing headers would put the final destination in the pseudo-header,
+	// ESP and AH don't let us near the transport header; f.transport skips those
+	src, dst := [16]byte(b[8:24]), [16]byte(b[24:40])
+	length := uint32(end - off)
+	t := f.transport(next, payload, asm.PseudoHeaderIPv6(src, dst, next, length), complete, true)
+
+	oldSrc, newSrc := f.rewrite(b[8:24])
+	oldDst, newDst := f.rewrite(b[24:40])
+
+	t.finish(f, [][2][]byte{{oldSrc, newSrc}, {oldDst, newDst}}, func() uint32 {
+		return asm.PseudoHeaderIPv6([16]byte(newSrc), [16]byte(newDst), next, length)
+	})
+}
+
+// transportFix is what we found out about a transport checksum before rewriting addresses.
+type transportFix struct {
+	field    []byte // the checksum field, nil if there's nothing to fix
+	payload  []byte
+	pseudo   bool // whether the checksum covers the pseudo-header
+	udp      bool
+	complete bool // all of the data the checksum covers is here
+	ok       bool // and it verified
+}
+
+// transport looks at the checksum of payload before any address is rewritten.
+func (f *fixer) transport(proto uint8, payload []byte, pseudo uint32, complete, v6 bool) transportFix {
+	var off, hdrLen int
+	t := transportFix{payload: payload, pseudo: true, complete: complete}
+	switch {
+	case proto == asm.ProtocolTCP:
+		off, hdrLen = 16, 20
+	case proto == asm.ProtocolUDP:
+		off, hdrLen, t.udp = 6, 8, true
+	case proto == asm.ProtocolICMP && !v6:
+		off, hdrLen, t.pseudo, pseudo = 2, 8, false, 0
+	case proto == asm.ProtocolICMPv6 && v6:
+		off, hdrLen = 2, 4
+	default:
+		return t
+	}
+	if len(payload) < hdrLen {
+		return t
+	}
+	t.field = payload[off : off+2]
+	// a zero UDP checksum over IPv4 means there isn't one, leave it that way
+	if t.udp && !v6 && binary.BigEndian.Uint16(t.field) == 0 {
+		t.field = nil
+		return t
+	}
+	t.ok = complete && asm.Finalize(asm.PartialSum(payload, pseudo)) == 0
+	return t
+}
+
+// finish updates the transport checksum after the addresses in changes were rewritten.
+// A checksum that verified is updated incrementally, one that didn't is recomputed over the
+// new pseudo-header, and one we couldn't verify because of missing data gets the incremental
+// update as a best effort.
+func (t transportFix) finish(f *fixer, changes [][2][]byte, pseudo func() uint32) {
+	if t.field == nil {
+		return
+	}
+	changed := false
+	for _, c := range changes {
+		changed = changed || string(c[0]) != string(c[1])
+	}
+
+	var sum uint16
+	switch {
+	case t.complete && (!t.ok || f.full):
+		var p uint32
+		if t.pseudo {
+			p = pseudo()
+		}
+		t.field[0], t.field[1] = 0, 0
+		sum = asm.Finalize(asm.PartialSum(t.payload, p))
+		f.recomputed++
+	case t.pseudo && changed:
+		sum = binary.BigEndian.Uint16(t.field)
+		for _, c := range changes {
+			sum = asm.UpdateBytes(sum, c[0], c[1])
+		}
+		f.incremental++
+		if !t.complete && !t.ok {
+			f.unfixable++
+		}
+	default:
+		return
+	}
+	// zero is "no checksum" for UDP
+	if t.udp && sum == 0 {
+		sum = 0xFFFF
+	}
+	binary.BigEndian.PutUint16(t.field, sum)
+}
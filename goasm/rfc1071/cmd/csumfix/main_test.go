@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"asm/pcap"
+)
+
+const testdata = "../../pcap/testdata/"
+
+const mapping = `
+# forward
+192.168.1.10    10.1.2.3
+93.184.216.34   198.51.100.7 # the server
+2001:db8::10    2001:db8:ffff::1
+2001:db8:1::34  2001:db8:ffff::2
+`
+
+// csumfix runs the command and returns the output file.
+func csumfix(t *testing.T, args ...string) []byte {
+	t.Helper()
+	out := filepath.Join(t.TempDir(), "out.pcap")
+	var stderr bytes.Buffer
+	if status := run(append([]string{"-o", out}, args...), &stderr); status != 0 {
+		t.Fatalf("Expected exit status 0, but got %d: %s", status, stderr.String())
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func writeTemp(t *testing.T, name string, b []byte) string {
+	t.Helper()
+	name = filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(name, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func packets(t *testing.T, b []byte) [][]byte {
+	t.Helper()
+	r, err := pcap.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ps [][]byte
+	for {
+		p, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return ps
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ps = append(ps, append([]byte(nil), p.Data...))
+	}
+}
+
+func TestFix(t *testing.T) {
+	orig, err := os.ReadFile(testdata + "capture.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixed := csumfix(t, testdata+"capture.pcap")
+	if len(fixed) != len(orig) {
+		t.Fatalf("Expected %d bytes, but got %d", len(orig), len(fixed))
+	}
+
+	// the bad checksums csumcheck finds in capture.pcap, and what they should be
+	expect := map[int]uint16{3: 0x941e, 4: 0x12de, 9: 0x24fe, 11: 0xd82a, 13: 0x310c}
+	op, fp := packets(t, orig), packets(t, fixed)
+	for i := range op {
+		var diff []int
+		for j := range op[i] {
+			if op[i][j] != fp[i][j] {
+				diff = append(diff, j)
+			}
+		}
+		want, bad := expect[i+1]
+		switch {
+		case !bad && len(diff) > 0:
+			t.Errorf("#%d: unexpected changes at %v", i+1, diff)
+		case bad && (len(diff) == 0 || diff[len(diff)-1]-diff[0] > 1):
+			t.Errorf("#%d: expected one checksum to change, got changes at %v", i+1, diff)
+		case bad:
+			at := diff[0] &^ 1
+			if got := uint16(fp[i][at])<<8 | uint16(fp[i][at+1]); got != want {
+				t.Errorf("#%d: expected checksum %#04x, but got %#04x", i+1, want, got)
+			}
+		}
+	}
+
+	// nothing left to fix
+	if again := csumfix(t, writeTemp(t, "fixed.pcap", fixed)); !bytes.Equal(again, fixed) {
+		t.Error("Fixing a fixed capture changed it")
+	}
+}
+
+func TestMap(t *testing.T) {
+	fixed := writeTemp(t, "fixed.pcap", csumfix(t, testdata+"capture.pcap"))
+	forward := writeTemp(t, "forward.txt", []byte(mapping))
+	var reverse strings.Builder
+	for _, line := range strings.Split(mapping, "\n") {
+		if f := strings.Fields(line); len(f) >= 2 && f[0] != "#" {
+			reverse.WriteString(f[1] + " " + f[0] + "\n")
+		}
+	}
+	backward := writeTemp(t, "backward.txt", []byte(reverse.String()))
+
+	mapped := csumfix(t, "-map", forward, fixed)
+	// the incremental updates agree with recomputing everything
+	if full := csumfix(t, "-full", "-map", forward, fixed); !bytes.Equal(mapped, full) {
+		t.Error("Incremental updates differ from recomputed checksums")
+	}
+	for _, addr := range [][]byte{{192, 168, 1, 10}, {93, 184, 216, 34}} {
+		if bytes.Contains(mapped, addr) {
+			t.Errorf("%v is still in the mapped capture", addr)
+		}
+	}
+
+	// and mapping back gets the original, including the fragment and the truncated packet
+	unmapped := csumfix(t, "-map", backward, writeTemp(t, "mapped.pcap", mapped))
+	want, _ := os.ReadFile(fixed)
+	if !bytes.Equal(unmapped, want) {
+		t.Error("Mapping a capture back and forth changed it")
+	}
+}
+
+func TestParseMapping(t *testing.T) {
+	m, err := parseMapping(strings.NewReader(mapping))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 4 {
+		t.Errorf("Expected 4 mappings, but got %d", len(m))
+	}
+
+	for _, bad := range []string{
+		"192.168.1.10",
+		"192.168.1.10 10.0.0.1 10.0.0.2",
+		"192.168.1.10 2001:db8::1",
+		"192.168.1.10 ::ffff:10.0.0.1",
+		"192.168.1.10 10.0.0.1\n192.168.1.10 10.0.0.2",
+		"fe80::1%eth0 fe80::2",
+		"192.168.1.300 10.0.0.1",
+	} {
+		if _, err := parseMapping(strings.NewReader(bad)); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	var stderr bytes.Buffer
+	if status := run([]string{testdata + "capture.pcap"}, &stderr); status != 2 {
+		t.Errorf("Expected exit status 2 without -o, but got %d", status)
+	}
+	out := filepath.Join(t.TempDir(), "out.pcap")
+	if status := run([]string{"-o", out, "main.go"}, &stderr); status != 1 || !strings.Contains(stderr.String(), "not a pcap") {
+		t.Errorf("Expected exit status 1 for a non-capture, but got %d: %s", status, stderr.String())
+	}
+}
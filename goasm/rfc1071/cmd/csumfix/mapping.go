@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+)
+
+// parseMapping reads an address mapping: one "old new" pair of IPv4 or IPv6 addresses
+// per line, blank lines and everything after a # ignored.
+//
+//	# lab network to documentation prefixes
+//	192.168.1.10   192.0.2.10
+//	2001:db8::10   2001:db8:ffff::10
+func parseMapping(r io.Reader) (map[netip.Addr]netip.Addr, error) {
+	m := make(map[netip.Addr]netip.Addr)
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text, _, _ := strings.Cut(s.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected two addresses, got %d fields", line, len(fields))
+		}
+		from, err := netip.ParseAddr(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		to, err := netip.ParseAddr(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		// 4in6 addresses don't appear in IPv4 headers, and IPv6 ones don't fit
+		if from.Is4() != to.Is4() || from.Is4In6() || to.Is4In6() || from.Zone() != "" || to.Zone() != "" {
+			return nil, fmt.Errorf("line %d: can't map %s to %s", line, from, to)
+		}
+		if _, ok := m[from]; ok {
+			return nil, fmt.Errorf("line %d: %s is mapped twice", line, from)
+		}
+		m[from] = to
+	}
+	return m, s.Err()
+}
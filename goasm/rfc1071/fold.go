@@ -0,0 +1,22 @@
+package asm
+
+import "asm/internal/csum"
+
+// Fold folds the end-around carries of a 32 bit ones' complement sum into 16 bits,
+// like linux's csum_fold minus the complement. Sums produced by partialSum fold cleanly.
+func Fold(sum uint32) uint16 {
+	return csum.Fold(sum)
+}
+
+// Finalize folds sum and returns its ones' complement, producing the value that goes
+// into a checksum field. Segments can be combined by feeding the result of one partialSum
+// as the initial value of the next before finalizing, as long as each one but the last is even.
+func Finalize(sum uint32) uint16 {
+	return ^Fold(sum)
+}
+
+// PartialSum returns the unfolded and uncomplemented ones' complement sum of data added
+// to initial, computed by the assembly partialSum kernel. Pass the result to Finalize.
+func PartialSum(data []byte, initial uint32) uint32 {
+	return partialSum(data, initial)
+}
@@ -2,6 +2,21 @@ module asm
 
 go 1.22.3
 
-require git.tcp.direct/kayos/common v0.9.7
+require (
+	asm/gen v0.0.0-00010101000000-000000000000
+	asm/internal/csum v0.0.0-00010101000000-000000000000
+	git.tcp.direct/kayos/common v0.9.7
+	golang.org/x/sys v0.15.0
+)
 
-require nullprogram.com/x/rng v1.1.0 // indirect
+require (
+	github.com/mmcloughlin/avo v0.6.0 // indirect
+	golang.org/x/mod v0.14.0 // indirect
+	golang.org/x/tools v0.16.1 // indirect
+	nullprogram.com/x/rng v1.1.0 // indirect
+)
+
+replace (
+	asm/gen => ../gen
+	asm/internal/csum => ../internal/csum
+)
@@ -0,0 +1,241 @@
+// Package icmp marshals and parses ICMP (RFC 792) and ICMPv6 (RFC 4443, RFC 4861) messages.
+package icmp
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"asm"
+)
+
+// ICMP message types.
+const (
+	TypeEchoReply              uint8 = 0
+	TypeDestinationUnreachable uint8 = 3
+	TypeEchoRequest            uint8 = 8
+	TypeTimeExceeded           uint8 = 11
+)
+
+// ICMPv6 message types.
+const (
+	TypeDestinationUnreachableV6 uint8 = 1
+	TypeTimeExceededV6           uint8 = 3
+	TypeEchoRequestV6            uint8 = 128
+	TypeEchoReplyV6              uint8 = 129
+	TypeNeighborSolicitation     uint8 = 135
+)
+
+// HeaderLen is the length of the type, code and checksum fields.
+const HeaderLen = 4
+
+var (
+	ErrTruncated = errors.New("icmp: message is truncated")
+	ErrType      = errors.New("icmp: unsupported message type")
+	ErrChecksum  = errors.New("icmp: bad checksum")
+)
+
+// Message is an ICMP or ICMPv6 message.
+type Message struct {
+	Type     uint8
+	Code     uint8
+	Checksum uint16
+	Body     Body
+}
+
+// Body is the part of a message after the checksum field: *Echo, *DestinationUnreachable,
+// *TimeExceeded or *NeighborSolicitation.
+type Body interface {
+	// Len returns the length of the serialized body.
+	Len() int
+	// marshal writes the body into b, which is exactly Len bytes long.
+	marshal(b []byte)
+}
+
+// Echo is the body of an echo request or reply.
+type Echo struct {
+	ID   uint16
+	Seq  uint16
+	Data []byte
+}
+
+func (e *Echo) Len() int {
+	return 4 + len(e.Data)
+}
+
+func (e *Echo) marshal(b []byte) {
+	binary.BigEndian.PutUint16(b[0:2], e.ID)
+	binary.BigEndian.PutUint16(b[2:4], e.Seq)
+	copy(b[4:], e.Data)
+}
+
+// DestinationUnreachable is the body of a destination unreachable message.
+type DestinationUnreachable struct {
+	// MTU is the next-hop MTU of an ICMP fragmentation needed message (code 4, RFC 1191).
+	// It's always zero for ICMPv6, which has a packet too big message instead.
+	MTU uint16
+	// Data is as much of the invoking packet as fits, starting with its IP header.
+	Data []byte
+}
+
+func (d *DestinationUnreachable) Len() int {
+	return 4 + len(d.Data)
+}
+
+func (d *DestinationUnreachable) marshal(b []byte) {
+	binary.BigEndian.PutUint16(b[0:2], 0)
+	binary.BigEndian.PutUint16(b[2:4], d.MTU)
+	copy(b[4:], d.Data)
+}
+
+// TimeExceeded is the body of a time (or hop limit) exceeded message.
+type TimeExceeded struct {
+	// Data is as much of the invoking packet as fits, starting with its IP header.
+	Data []byte
+}
+
+func (t *TimeExceeded) Len() int {
+	return 4 + len(t.Data)
+}
+
+func (t *TimeExceeded) marshal(b []byte) {
+	clear(b[0:4])
+	copy(b[4:], t.Data)
+}
+
+// NeighborSolicitation is the body of an ICMPv6 neighbor solicitation.
+type NeighborSolicitation struct {
+	Target [16]byte
+	// Options as they appear on the wire, typically a source link-layer address.
+	Options []byte
+}
+
+func (n *NeighborSolicitation) Len() int {
+	return 20 + len(n.Options)
+}
+
+func (n *NeighborSolicitation) marshal(b []byte) {
+	clear(b[0:4])
+	copy(b[4:20], n.Target[:])
+	copy(b[20:], n.Options)
+}
+
+// Len returns the length of the serialized message.
+// A message without a body still gets the 4 (zeroed) bytes every message type starts with.
+func (m *Message) Len() int {
+	if m.Body == nil {
+		return HeaderLen + 4
+	}
+	return HeaderLen + m.Body.Len()
+}
+
+// Marshal serializes an ICMP message with a freshly computed checksum, ignoring m.Checksum.
+func (m *Message) Marshal() ([]byte, error) {
+	return m.marshal(false, 0)
+}
+
+// MarshalIPv6 serializes an ICMPv6 message sent from src to dst, its checksum covers
+// the IPv6 pseudo-header. m.Checksum is ignored.
+func (m *Message) MarshalIPv6(src, dst [16]byte) ([]byte, error) {
+	return m.marshal(true, asm.PseudoHeaderIPv6(src, dst, asm.ProtocolICMPv6, uint32(m.Len())))
+}
+
+func (m *Message) marshal(v6 bool, pseudo uint32) ([]byte, error) {
+	if bodyKind(v6, m.Type) == kindUnsupported {
+		return nil, ErrType
+	}
+	b := make([]byte, m.Len())
+	b[0], b[1] = m.Type, m.Code
+	if m.Body != nil {
+		m.Body.marshal(b[HeaderLen:])
+	}
+	binary.BigEndian.PutUint16(b[2:4], asm.Finalize(asm.PartialSum(b, pseudo)))
+	return b, nil
+}
+
+// Parse parses an ICMP message and verifies its checksum.
+// On a checksum mismatch the parsed message is returned along with ErrChecksum.
+// Data and options in the body alias b.
+func Parse(b []byte) (*Message, error) {
+	return parse(b, false, 0)
+}
+
+// ParseIPv6 parses an ICMPv6 message sent from src to dst and verifies its checksum,
+// see Parse.
+func ParseIPv6(b []byte, src, dst [16]byte) (*Message, error) {
+	return parse(b, true, asm.PseudoHeaderIPv6(src, dst, asm.ProtocolICMPv6, uint32(len(b))))
+}
+
+func parse(b []byte, v6 bool, pseudo uint32) (*Message, error) {
+	if len(b) < HeaderLen+4 {
+		return nil, ErrTruncated
+	}
+	m := &Message{Type: b[0], Code: b[1], Checksum: binary.BigEndian.Uint16(b[2:4])}
+	if bodyKind(v6, m.Type) == kindUnsupported {
+		return nil, ErrType
+	}
+
+	body := b[HeaderLen:]
+	switch bodyKind(v6, m.Type) {
+	case kindEcho:
+		m.Body = &Echo{
+			ID:   binary.BigEndian.Uint16(body[0:2]),
+			Seq:  binary.BigEndian.Uint16(body[2:4]),
+			Data: body[4:],
+		}
+	case kindDestinationUnreachable:
+		d := &DestinationUnreachable{Data: body[4:]}
+		if !v6 {
+			d.MTU = binary.BigEndian.Uint16(body[2:4])
+		}
+		m.Body = d
+	case kindTimeExceeded:
+		m.Body = &TimeExceeded{Data: body[4:]}
+	case kindNeighborSolicitation:
+		if len(body) < 20 {
+			return nil, ErrTruncated
+		}
+		m.Body = &NeighborSolicitation{Target: [16]byte(body[4:20]), Options: body[20:]}
+	}
+
+	if asm.Finalize(asm.PartialSum(b, pseudo)) != 0 {
+		return m, ErrChecksum
+	}
+	return m, nil
+}
+
+// kind is the shape of a message body, ICMP and ICMPv6 use different type numbers
+// for the same message and sometimes the same type number for different messages.
+type kind int
+
+const (
+	kindUnsupported kind = iota
+	kindEcho
+	kindDestinationUnreachable
+	kindTimeExceeded
+	kindNeighborSolicitation
+)
+
+func bodyKind(v6 bool, t uint8) kind {
+	if v6 {
+		switch t {
+		case TypeEchoRequestV6, TypeEchoReplyV6:
+			return kindEcho
+		case TypeDestinationUnreachableV6:
+			return kindDestinationUnreachable
+		case TypeTimeExceededV6:
+			return kindTimeExceeded
+		case TypeNeighborSolicitation:
+			return kindNeighborSolicitation
+		}
+		return kindUnsupported
+	}
+	switch t {
+	case TypeEchoRequest, TypeEchoReply:
+		return kindEcho
+	case TypeDestinationUnreachable:
+		return kindDestinationUnreachable
+	case TypeTimeExceeded:
+		return kindTimeExceeded
+	}
+	return kindUnsupported
+}
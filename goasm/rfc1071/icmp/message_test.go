@@ -0,0 +1,206 @@
+package icmp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/netip"
+	"os"
+	"reflect"
+	"testing"
+
+	"asm/pcap"
+)
+
+type golden struct {
+	name     string
+	v6       bool
+	msg      []byte
+	src, dst [16]byte
+}
+
+// captured names the packets of testdata/icmp.pcap, in the order testdata/capture.go
+// keeps them.
+var captured = []string{
+	"neighbor_solicitation_dad",
+	"echo_request_linux",
+	"echo_reply_linux",
+	"time_exceeded_ttl",
+	"port_unreachable",
+	"frag_needed",
+	"neighbor_solicitation",
+	"echo_request_v6",
+	"echo_reply_v6",
+	"hop_limit_exceeded_v6",
+	"address_unreachable_v6",
+}
+
+func loadGolden(t *testing.T) map[string]golden {
+	f, err := os.Open("testdata/icmp.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := pcap.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := make(map[string]golden, len(captured))
+	for _, name := range captured {
+		p, err := r.Next()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		etherType, ip, _, err := p.Network()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		g := golden{name: name, v6: etherType == pcap.EtherTypeIPv6}
+		if g.v6 {
+			copy(g.src[:], ip[8:24])
+			copy(g.dst[:], ip[24:40])
+			g.msg = bytes.Clone(ip[40:])
+		} else {
+			g.msg = bytes.Clone(ip[int(ip[0]&0xf)*4:])
+		}
+		m[name] = g
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Fatalf("Expected the capture to end after %d packets, but got %v", len(captured), err)
+	}
+	return m
+}
+
+func (g golden) parse() (*Message, error) {
+	if g.v6 {
+		return ParseIPv6(g.msg, g.src, g.dst)
+	}
+	return Parse(g.msg)
+}
+
+func (g golden) marshal(m *Message) ([]byte, error) {
+	if g.v6 {
+		return m.MarshalIPv6(g.src, g.dst)
+	}
+	return m.Marshal()
+}
+
+func TestGolden(t *testing.T) {
+	vectors := loadGolden(t)
+
+	// what we expect to find in each vector, data and options are checked by the round trip
+	expect := map[string]struct {
+		typ, code uint8
+		body      Body
+	}{
+		"echo_request_linux":        {TypeEchoRequest, 0, &Echo{ID: 0x1c2e, Seq: 1}},
+		"echo_reply_linux":          {TypeEchoReply, 0, &Echo{ID: 0x1c2e, Seq: 1}},
+		"time_exceeded_ttl":         {TypeTimeExceeded, 0, &TimeExceeded{}},
+		"port_unreachable":          {TypeDestinationUnreachable, 3, &DestinationUnreachable{}},
+		"frag_needed":               {TypeDestinationUnreachable, 4, &DestinationUnreachable{MTU: 1400}},
+		"echo_request_v6":           {TypeEchoRequestV6, 0, &Echo{ID: 0x5d2a, Seq: 3}},
+		"echo_reply_v6":             {TypeEchoReplyV6, 0, &Echo{ID: 0x5d2a, Seq: 3}},
+		"neighbor_solicitation":     {TypeNeighborSolicitation, 0, &NeighborSolicitation{Target: netip.MustParseAddr("fe80::1").As16()}},
+		"neighbor_solicitation_dad": {TypeNeighborSolicitation, 0, &NeighborSolicitation{Target: netip.MustParseAddr("fe80::1c2b:3dff:fe4e:5f60").As16()}},
+		"address_unreachable_v6":    {TypeDestinationUnreachableV6, 3, &DestinationUnreachable{}},
+		"hop_limit_exceeded_v6":     {TypeTimeExceededV6, 0, &TimeExceeded{}},
+	}
+	if len(expect) != len(vectors) {
+		t.Errorf("Expected %d golden vectors, but found %d", len(expect), len(vectors))
+	}
+
+	for name, e := range expect {
+		t.Run(name, func(t *testing.T) {
+			g, ok := vectors[name]
+			if !ok {
+				t.Fatalf("no golden vector named %s", name)
+			}
+			m, err := g.parse()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.Type != e.typ || m.Code != e.code {
+				t.Errorf("Expected type %d code %d, but got type %d code %d", e.typ, e.code, m.Type, m.Code)
+			}
+
+			// blank out the variable length parts before comparing the fixed fields
+			var got Body
+			switch b := m.Body.(type) {
+			case *Echo:
+				c := *b
+				c.Data = nil
+				got = &c
+			case *DestinationUnreachable:
+				c := *b
+				c.Data = nil
+				got = &c
+			case *TimeExceeded:
+				got = &TimeExceeded{}
+			case *NeighborSolicitation:
+				c := *b
+				c.Options = nil
+				got = &c
+			}
+			if !reflect.DeepEqual(got, e.body) {
+				t.Errorf("Expected %+v, but got %+v", e.body, got)
+			}
+
+			m.Checksum = 0
+			b, err := g.marshal(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, g.msg) {
+				t.Errorf("round trip:\nexpected %x\n     got %x", g.msg, b)
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	vectors := loadGolden(t)
+
+	for _, name := range []string{"echo_request_linux", "neighbor_solicitation"} {
+		g := vectors[name]
+		g.msg = bytes.Clone(g.msg)
+		g.msg[len(g.msg)-1]++
+		if m, err := g.parse(); !errors.Is(err, ErrChecksum) || m == nil {
+			t.Errorf("%s: expected the message along with %v, but got %v", name, ErrChecksum, err)
+		}
+	}
+
+	// the ICMPv6 checksum covers the addresses too (but not their order, it's a sum)
+	g := vectors["echo_request_v6"]
+	g.dst = netip.MustParseAddr("fe80::2").As16()
+	if _, err := g.parse(); !errors.Is(err, ErrChecksum) {
+		t.Errorf("wrong destination: expected %v, but got %v", ErrChecksum, err)
+	}
+
+	if _, err := Parse(vectors["echo_request_linux"].msg[:7]); !errors.Is(err, ErrTruncated) {
+		t.Errorf("Expected %v, but got %v", ErrTruncated, err)
+	}
+	ns := vectors["neighbor_solicitation"]
+	if _, err := ParseIPv6(ns.msg[:12], ns.src, ns.dst); !errors.Is(err, ErrTruncated) {
+		t.Errorf("short neighbor solicitation: expected %v, but got %v", ErrTruncated, err)
+	}
+	// neighbor solicitation is ICMPv6 only, 135 means nothing to ICMP
+	if _, err := Parse(ns.msg); !errors.Is(err, ErrType) {
+		t.Errorf("Expected %v, but got %v", ErrType, err)
+	}
+	if _, err := (&Message{Type: TypeEchoRequestV6}).Marshal(); !errors.Is(err, ErrType) {
+		t.Errorf("Expected %v, but got %v", ErrType, err)
+	}
+}
+
+func TestMarshalEmpty(t *testing.T) {
+	b, err := (&Message{Type: TypeEchoRequest}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, []byte{8, 0, 0xf7, 0xff, 0, 0, 0, 0}) {
+		t.Errorf("unexpected empty echo request: %x", b)
+	}
+	if _, err := Parse(b); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,338 @@
+//go:build ignore
+
+// capture records icmp.pcap, the messages TestGolden checks, off a veth of a throwaway
+// network namespace. It needs root, ip(8) and a linux kernel:
+//
+//	go run testdata/capture.go -o testdata/icmp.pcap
+//
+// The namespaces are lined up as
+//
+//	icmp-host h0 ── r0 icmp-router r1 ── f0 icmp-far
+//	     198.51.100.0/24                203.0.113.0/24
+//	     2001:db8:1::/64                2001:db8:2::/64, MTU 1400
+//
+// and the program runs itself again inside icmp-host, where it captures h0 while it
+// brings the link up and provokes each message. Echo requests go out through ping
+// sockets, so every byte of every message, checksums included, comes from the kernels
+// of the three namespaces. The first packet that matches each vector is kept, in the
+// order of vectors below.
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"asm/pcap"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	echoID   = 0x1c2e
+	echoSeq  = 1
+	echoID6  = 0x5d2a
+	echoSeq6 = 3
+
+	traceroutePort = 33434
+)
+
+var (
+	far      = netip.MustParseAddr("203.0.113.2")
+	far6     = netip.MustParseAddr("2001:db8:2::2")
+	missing6 = netip.MustParseAddr("2001:db8:2::99")
+	router6  = netip.MustParseAddr("fe80::1")
+	// h0's link local address, from its MAC address
+	host6 = netip.MustParseAddr("fe80::1c2b:3dff:fe4e:5f60")
+)
+
+// vectors are the messages kept from the capture, the order TestGolden expects them in.
+var vectors = []struct {
+	name  string
+	match func(v6 bool, src netip.Addr, icmp []byte) bool
+}{
+	// the router checks its own link local address when h0 brings the link up too
+	{"neighbor_solicitation_dad", isSolicitation(true, host6)},
+	{"echo_request_linux", isType(false, 8, 0)},
+	{"echo_reply_linux", isType(false, 0, 0)},
+	{"time_exceeded_ttl", isType(false, 11, 0)},
+	{"port_unreachable", isType(false, 3, 3)},
+	{"frag_needed", isType(false, 3, 4)},
+	{"neighbor_solicitation", isSolicitation(false, router6)},
+	{"echo_request_v6", isType(true, 128, 0)},
+	{"echo_reply_v6", isType(true, 129, 0)},
+	{"hop_limit_exceeded_v6", isType(true, 3, 0)},
+	{"address_unreachable_v6", isType(true, 1, 3)},
+}
+
+func isType(v6 bool, typ, code uint8) func(bool, netip.Addr, []byte) bool {
+	return func(isV6 bool, _ netip.Addr, icmp []byte) bool {
+		return isV6 == v6 && icmp[0] == typ && icmp[1] == code
+	}
+}
+
+func isSolicitation(dad bool, target netip.Addr) func(bool, netip.Addr, []byte) bool {
+	return func(v6 bool, src netip.Addr, icmp []byte) bool {
+		return v6 && icmp[0] == 135 && len(icmp) >= 24 && src.IsUnspecified() == dad &&
+			netip.AddrFrom16([16]byte(icmp[8:24])) == target
+	}
+}
+
+func main() {
+	out := flag.String("o", "icmp.pcap", "write the capture to `file`")
+	child := flag.Bool("child", false, "capture inside icmp-host, set up by the parent")
+	flag.Parse()
+
+	if *child {
+		capture(*out)
+		return
+	}
+	path, err := filepath.Abs(*out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer teardown()
+	setup()
+	self, err := os.Executable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	cmd := exec.Command("ip", "netns", "exec", "icmp-host", self, "-child", "-o", path)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		teardown()
+		log.Fatal(err)
+	}
+}
+
+func run(args ...string) {
+	cmd := exec.Command(args[0], args[1:]...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		teardown()
+		log.Fatalf("%v: %v\n%s", args, err, out)
+	}
+}
+
+// setup builds everything but icmp-host's side of the link, which capture brings up
+// once it's listening.
+func setup() {
+	for _, ns := range []string{"icmp-host", "icmp-router", "icmp-far"} {
+		run("ip", "netns", "add", ns)
+		run("ip", "-n", ns, "link", "set", "lo", "up")
+	}
+	run("ip", "link", "add", "h0", "netns", "icmp-host", "address", "1e:2b:3d:4e:5f:60",
+		"type", "veth", "peer", "name", "r0", "netns", "icmp-router")
+	run("ip", "link", "add", "r1", "netns", "icmp-router", "mtu", "1400",
+		"type", "veth", "peer", "name", "f0", "netns", "icmp-far", "mtu", "1400")
+
+	router := func(args ...string) { run(append([]string{"ip", "-n", "icmp-router"}, args...)...) }
+	router("addr", "add", "198.51.100.1/24", "dev", "r0")
+	router("addr", "add", "fe80::1/64", "dev", "r0", "nodad")
+	router("addr", "add", "2001:db8:1::1/64", "dev", "r0", "nodad")
+	router("addr", "add", "203.0.113.1/24", "dev", "r1")
+	router("addr", "add", "2001:db8:2::1/64", "dev", "r1", "nodad")
+	router("link", "set", "r0", "up")
+	router("link", "set", "r1", "up")
+
+	farNS := func(args ...string) { run(append([]string{"ip", "-n", "icmp-far"}, args...)...) }
+	farNS("addr", "add", "203.0.113.2/24", "dev", "f0")
+	farNS("addr", "add", "2001:db8:2::2/64", "dev", "f0", "nodad")
+	farNS("link", "set", "f0", "up")
+	farNS("route", "add", "default", "via", "203.0.113.1")
+	farNS("-6", "route", "add", "default", "via", "2001:db8:2::1")
+
+	// forward, and don't rate limit the errors, several go to the same host in a row
+	for _, ns := range []string{"icmp-router", "icmp-far"} {
+		run("ip", "netns", "exec", ns, "sysctl", "-qw",
+			"net.ipv4.ip_forward=1", "net.ipv6.conf.all.forwarding=1",
+			"net.ipv4.icmp_ratelimit=0", "net.ipv6.icmp.ratelimit=0")
+	}
+	// ping sockets for everyone, so the kernel fills in the checksums
+	run("ip", "netns", "exec", "icmp-host", "sysctl", "-qw", "net.ipv4.ping_group_range=0 2147483647")
+}
+
+func teardown() {
+	for _, ns := range []string{"icmp-host", "icmp-router", "icmp-far"} {
+		exec.Command("ip", "netns", "del", ns).Run()
+	}
+}
+
+// capture runs inside icmp-host.
+func capture(path string) {
+	h0, err := net.InterfaceByName("h0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	all := int(htons(unix.ETH_P_ALL))
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, all)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ALL), Ifindex: h0.Index}); err != nil {
+		log.Fatal(err)
+	}
+	packets := make(chan *pcap.Packet, 256)
+	go func() {
+		for {
+			b := make([]byte, 65536)
+			n, _, err := unix.Recvfrom(fd, b, 0)
+			// h0 is down when we bind, the socket reports that once
+			if err == unix.ENETDOWN {
+				continue
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+			packets <- &pcap.Packet{Timestamp: time.Now(), OrigLen: n, Data: b[:n]}
+		}
+	}()
+
+	// bringing the link up sends the duplicate address detection solicitation
+	run("ip", "link", "set", "h0", "up")
+	time.Sleep(2 * time.Second)
+	run("ip", "addr", "add", "198.51.100.2/24", "dev", "h0")
+	run("ip", "addr", "add", "2001:db8:1::2/64", "dev", "h0", "nodad")
+	run("ip", "route", "add", "default", "via", "198.51.100.1")
+	run("ip", "-6", "route", "add", "default", "via", "fe80::1", "dev", "h0")
+
+	ping(unix.AF_INET, unix.IPPROTO_ICMP, 8, echoID, echoSeq, far)
+	udp(far, traceroutePort, 1, 64)
+	udp(far, traceroutePort, 64, 64)
+	udp(far, traceroutePort, 64, 1400)
+	ping(unix.AF_INET6, unix.IPPROTO_ICMPV6, 128, echoID6, echoSeq6, far6)
+	udp(far6, traceroutePort, 1, 64)
+	// the router gives up resolving the address after three solicitations a second apart
+	udp(missing6, traceroutePort, 64, 64)
+	time.Sleep(5 * time.Second)
+
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	w, err := pcap.NewWriter(f, pcap.FileHeader{Nanosecond: true, SnapLen: 65536, LinkType: pcap.LinkTypeEthernet})
+	if err != nil {
+		log.Fatal(err)
+	}
+	kept := make([]*pcap.Packet, len(vectors))
+	for len(packets) > 0 {
+		p := <-packets
+		p.LinkType = pcap.LinkTypeEthernet
+		v6, src, icmp := parse(p)
+		if icmp == nil {
+			continue
+		}
+		for i, v := range vectors {
+			if kept[i] == nil && v.match(v6, src, icmp) {
+				kept[i] = p
+				break
+			}
+		}
+	}
+	for i, p := range kept {
+		if p == nil {
+			log.Fatalf("nothing captured for %s", vectors[i].name)
+		}
+		if err := w.WritePacket(p); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// parse returns the ICMP or ICMPv6 message in p and its source address, or nil for
+// anything else.
+func parse(p *pcap.Packet) (v6 bool, src netip.Addr, icmp []byte) {
+	etherType, ip, _, err := p.Network()
+	if err != nil {
+		return false, src, nil
+	}
+	switch {
+	case etherType == pcap.EtherTypeIPv4 && len(ip) >= 20 && ip[9] == unix.IPPROTO_ICMP:
+		ihl := int(ip[0]&0xf) * 4
+		if len(ip) < ihl+8 {
+			return false, src, nil
+		}
+		return false, netip.AddrFrom4([4]byte(ip[12:16])), ip[ihl:]
+	case etherType == pcap.EtherTypeIPv6 && len(ip) >= 48 && ip[6] == unix.IPPROTO_ICMPV6:
+		return true, netip.AddrFrom16([16]byte(ip[8:24])), ip[40:]
+	}
+	return false, src, nil
+}
+
+// ping sends an echo request with the payload ping(8) uses, a timeval and the bytes
+// 0x10 on, from a ping socket bound to id.
+func ping(family, proto int, typ uint8, id, seq uint16, to netip.Addr) {
+	fd, err := unix.Socket(family, unix.SOCK_DGRAM, proto)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer unix.Close(fd)
+	var local, remote unix.Sockaddr
+	if family == unix.AF_INET {
+		local, remote = &unix.SockaddrInet4{Port: int(id)}, &unix.SockaddrInet4{Addr: to.As4()}
+	} else {
+		local, remote = &unix.SockaddrInet6{Port: int(id)}, &unix.SockaddrInet6{Addr: to.As16()}
+	}
+	if err := unix.Bind(fd, local); err != nil {
+		log.Fatal(err)
+	}
+	msg := make([]byte, 8+56)
+	msg[0] = typ
+	binary.BigEndian.PutUint16(msg[6:8], seq)
+	now := time.Now()
+	binary.LittleEndian.PutUint64(msg[8:16], uint64(now.Unix()))
+	binary.LittleEndian.PutUint64(msg[16:24], uint64(now.Nanosecond()/1000))
+	for i := range msg[24:] {
+		msg[24+i] = byte(0x10 + i)
+	}
+	if err := unix.Sendto(fd, msg, 0, remote); err != nil {
+		log.Fatal(err)
+	}
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &unix.Timeval{Sec: 2}); err != nil {
+		log.Fatal(err)
+	}
+	if _, _, err := unix.Recvfrom(fd, make([]byte, 1500), 0); err != nil {
+		log.Fatalf("no reply from %s: %v", to, err)
+	}
+}
+
+// udp sends size bytes to a closed port the way traceroute(8) does, with the don't
+// fragment bit set, and waits for the error to come back.
+func udp(to netip.Addr, port, ttl, size int) {
+	var fd int
+	var err error
+	var remote unix.Sockaddr
+	if to.Is4() {
+		if fd, err = unix.Socket(unix.AF_INET, unix.SOCK_DGRAM, 0); err == nil {
+			unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_TTL, ttl)
+			err = unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, unix.IP_PMTUDISC_PROBE)
+		}
+		remote = &unix.SockaddrInet4{Port: port, Addr: to.As4()}
+	} else {
+		if fd, err = unix.Socket(unix.AF_INET6, unix.SOCK_DGRAM, 0); err == nil {
+			err = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_UNICAST_HOPS, ttl)
+		}
+		remote = &unix.SockaddrInet6{Port: port, Addr: to.As16()}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer unix.Close(fd)
+	if err := unix.Sendto(fd, make([]byte, size), 0, remote); err != nil {
+		log.Fatal(fmt.Errorf("udp to %s: %w", to, err))
+	}
+	time.Sleep(500 * time.Millisecond)
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
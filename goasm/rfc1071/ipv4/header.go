@@ -0,0 +1,211 @@
+// Package ipv4 parses, serializes and checksums IPv4 headers (RFC 791).
+package ipv4
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"asm"
+)
+
+const (
+	// Version is the only IP version this package speaks.
+	Version = 4
+	// HeaderLen is the length of a header without options.
+	HeaderLen = 20
+	// MaxHeaderLen is the longest header IHL can describe, leaving 40 bytes for options.
+	MaxHeaderLen = 60
+)
+
+// Flags of the 3 bit flags field.
+const (
+	MoreFragments uint8 = 1 << 0
+	DontFragment  uint8 = 1 << 1
+)
+
+// Header is an IPv4 header.
+type Header struct {
+	TOS      uint8
+	TotalLen uint16
+	ID       uint16
+	Flags    uint8  // 3 bits, DontFragment and MoreFragments
+	FragOff  uint16 // 13 bits, in units of 8 bytes
+	TTL      uint8
+	Protocol uint8
+	Checksum uint16
+	Src      [4]byte
+	Dst      [4]byte
+	// Options as they appear on the wire, Marshal pads them with zeros (end of options
+	// list) to a multiple of 4 bytes.
+	Options []byte
+}
+
+// VersionError is returned when the version nibble isn't 4.
+type VersionError struct {
+	Version uint8
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("ipv4: bad version %d", e.Version)
+}
+
+// HeaderLenError is returned when the header doesn't fit the IHL rules: IHL of at least 5,
+// a header no longer than the buffer holding it, and no more than 40 bytes of options.
+type HeaderLenError struct {
+	HeaderLen int // header length in bytes, as IHL claims or as the options require
+	Len       int // bytes available
+}
+
+func (e *HeaderLenError) Error() string {
+	return fmt.Sprintf("ipv4: bad header length %d with %d bytes available", e.HeaderLen, e.Len)
+}
+
+// TotalLenError is returned when the total length is shorter than the header or longer
+// than the buffer holding the packet.
+type TotalLenError struct {
+	TotalLen  int
+	HeaderLen int
+	Len       int // bytes available, zero when serializing
+}
+
+func (e *TotalLenError) Error() string {
+	if e.Len == 0 {
+		return fmt.Sprintf("ipv4: bad total length %d for a %d byte header", e.TotalLen, e.HeaderLen)
+	}
+	return fmt.Sprintf("ipv4: bad total length %d for a %d byte header with %d bytes available", e.TotalLen, e.HeaderLen, e.Len)
+}
+
+// ChecksumError is returned when the header checksum doesn't verify.
+type ChecksumError struct {
+	Checksum uint16 // the value in the header
+	Expected uint16 // the value it should have been
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("ipv4: bad header checksum %#04x, expected %#04x", e.Checksum, e.Expected)
+}
+
+// Len returns the length of the serialized header, options and padding included.
+func (h *Header) Len() int {
+	return HeaderLen + (len(h.Options)+3)&^3
+}
+
+// Parse parses the header at the start of b and verifies its checksum.
+// On a checksum mismatch the parsed header is returned along with a *ChecksumError,
+// every other error comes with a nil header.
+func Parse(b []byte) (*Header, error) {
+	h := new(Header)
+	if err := h.Unmarshal(b); err != nil {
+		if _, ok := err.(*ChecksumError); !ok {
+			return nil, err
+		}
+		return h, err
+	}
+	return h, nil
+}
+
+// Unmarshal parses the header at the start of b into h, see Parse.
+// Options alias b.
+func (h *Header) Unmarshal(b []byte) error {
+	if len(b) < 1 {
+		return &HeaderLenError{HeaderLen: HeaderLen, Len: len(b)}
+	}
+	if v := b[0] >> 4; v != Version {
+		return &VersionError{Version: v}
+	}
+	ihl := int(b[0]&0x0F) * 4
+	if len(b) < HeaderLen || ihl < HeaderLen || len(b) < ihl {
+		return &HeaderLenError{HeaderLen: ihl, Len: len(b)}
+	}
+	total := int(binary.BigEndian.Uint16(b[2:4]))
+	if total < ihl || len(b) < total {
+		return &TotalLenError{TotalLen: total, HeaderLen: ihl, Len: len(b)}
+	}
+
+	frag := binary.BigEndian.Uint16(b[6:8])
+	*h = Header{
+		TOS:      b[1],
+		TotalLen: uint16(total),
+		ID:       binary.BigEndian.Uint16(b[4:6]),
+		Flags:    uint8(frag >> 13),
+		FragOff:  frag & 0x1FFF,
+		TTL:      b[8],
+		Protocol: b[9],
+		Checksum: binary.BigEndian.Uint16(b[10:12]),
+		Src:      [4]byte(b[12:16]),
+		Dst:      [4]byte(b[16:20]),
+	}
+	if ihl > HeaderLen {
+		h.Options = b[HeaderLen:ihl:ihl]
+	}
+
+	if Checksum(b[:ihl]) != 0 {
+		return &ChecksumError{Checksum: h.Checksum, Expected: checksumWithout(b[:ihl])}
+	}
+	return nil
+}
+
+// Marshal serializes h with a freshly computed checksum, ignoring h.Checksum.
+func (h *Header) Marshal() ([]byte, error) {
+	return h.AppendTo(nil)
+}
+
+// AppendTo appends the serialized header to b, see Marshal.
+func (h *Header) AppendTo(b []byte) ([]byte, error) {
+	n := h.Len()
+	if n > MaxHeaderLen {
+		return b, &HeaderLenError{HeaderLen: n, Len: MaxHeaderLen}
+	}
+	if int(h.TotalLen) < n {
+		return b, &TotalLenError{TotalLen: int(h.TotalLen), HeaderLen: n}
+	}
+
+	off := len(b)
+	b = append(b, make([]byte, n)...)
+	hdr := b[off:]
+	hdr[0] = Version<<4 | uint8(n/4)
+	hdr[1] = h.TOS
+	binary.BigEndian.PutUint16(hdr[2:4], h.TotalLen)
+	binary.BigEndian.PutUint16(hdr[4:6], h.ID)
+	binary.BigEndian.PutUint16(hdr[6:8], uint16(h.Flags&0x7)<<13|h.FragOff&0x1FFF)
+	hdr[8] = h.TTL
+	hdr[9] = h.Protocol
+	copy(hdr[12:16], h.Src[:])
+	copy(hdr[16:20], h.Dst[:])
+	copy(hdr[HeaderLen:], h.Options)
+
+	binary.BigEndian.PutUint16(hdr[10:12], Checksum(hdr))
+	return b, nil
+}
+
+// Checksum returns the ones' complement checksum of the serialized header hdr, checksum
+// field included: zero if hdr verifies, or the value to store if the field is zeroed.
+// Headers without options take an unrolled fast path, the rest goes through the assembly kernel.
+func Checksum(hdr []byte) uint16 {
+	if len(hdr) == HeaderLen {
+		return checksum20(hdr)
+	}
+	return asm.Finalize(asm.PartialSum(hdr, 0))
+}
+
+// checksumWithout returns the checksum hdr should have, ignoring the value of its checksum field.
+func checksumWithout(hdr []byte) uint16 {
+	sum := asm.PartialSum(hdr[:10], 0)
+	return asm.Finalize(asm.PartialSum(hdr[12:], sum))
+}
+
+// checksum20 is Checksum for a 20 byte header: five big endian 32-bit words summed into 64 bits.
+// 2^32 is 1 modulo 0xFFFF just like 2^16 is, so the folded sum comes out the same as
+// summing ten 16 bit words, and no loop or carry handling is needed on the way.
+func checksum20(hdr []byte) uint16 {
+	_ = hdr[19]
+	sum := uint64(binary.BigEndian.Uint32(hdr[0:4])) +
+		uint64(binary.BigEndian.Uint32(hdr[4:8])) +
+		uint64(binary.BigEndian.Uint32(hdr[8:12])) +
+		uint64(binary.BigEndian.Uint32(hdr[12:16])) +
+		uint64(binary.BigEndian.Uint32(hdr[16:20]))
+	// five words carry at most 3 bits over, folding twice gets rid of them
+	sum = sum&0xFFFFFFFF + sum>>32
+	sum = sum&0xFFFFFFFF + sum>>32
+	return asm.Finalize(uint32(sum))
+}
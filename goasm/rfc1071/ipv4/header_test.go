@@ -0,0 +1,168 @@
+package ipv4
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"asm"
+)
+
+// the example header from wikipedia's IPv4 header checksum article, followed by its 95 byte payload
+var wikipedia = append([]byte{
+	0x45, 0x00, 0x00, 0x73, 0x00, 0x00, 0x40, 0x00, 0x40, 0x11,
+	0xb8, 0x61, 0xc0, 0xa8, 0x00, 0x01, 0xc0, 0xa8, 0x00, 0xc7,
+}, make([]byte, 95)...)
+
+func TestParse(t *testing.T) {
+	h, err := Parse(wikipedia)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := &Header{
+		TotalLen: 0x73,
+		Flags:    DontFragment,
+		TTL:      64,
+		Protocol: 17,
+		Checksum: 0xb861,
+		Src:      [4]byte{192, 168, 0, 1},
+		Dst:      [4]byte{192, 168, 0, 199},
+	}
+	if !reflect.DeepEqual(h, expect) {
+		t.Errorf("Expected %+v, but got %+v", expect, h)
+	}
+
+	b, err := h.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, wikipedia[:HeaderLen]) {
+		t.Errorf("round trip: expected %x, but got %x", wikipedia[:HeaderLen], b)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	h := &Header{
+		TotalLen: 64,
+		ID:       0xbeef,
+		Flags:    MoreFragments,
+		FragOff:  0x1234,
+		TTL:      1,
+		Protocol: 6,
+		Src:      [4]byte{10, 0, 0, 1},
+		Dst:      [4]byte{10, 0, 0, 2},
+		// router alert, 4 bytes, plus a lone no-op that needs padding
+		Options: []byte{0x94, 0x04, 0x00, 0x00, 0x01},
+	}
+	b, err := h.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 28 || h.Len() != 28 || b[0] != 0x47 {
+		t.Fatalf("Expected a 28 byte header with IHL 7, but got %d bytes: %x", len(b), b)
+	}
+	if Checksum(b) != 0 {
+		t.Errorf("marshaled header doesn't verify: %x", b)
+	}
+
+	parsed, err := Parse(append(b, make([]byte, 64-28)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(parsed.Options, []byte{0x94, 0x04, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00}) {
+		t.Errorf("unexpected options: %x", parsed.Options)
+	}
+	parsed.Options, h.Options, parsed.Checksum = nil, nil, 0
+	if !reflect.DeepEqual(parsed, h) {
+		t.Errorf("Expected %+v, but got %+v", h, parsed)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	// the fast path has to agree with the kernel, checksum field included or not
+	for i := 0; i < 1000; i++ {
+		hdr := make([]byte, HeaderLen)
+		rand.Read(hdr)
+		if expect, actual := asm.Finalize(asm.PartialSum(hdr, 0)), Checksum(hdr); actual != expect {
+			t.Fatalf("%x: expected %#04x, but got %#04x", hdr, expect, actual)
+		}
+	}
+	ones := bytes.Repeat([]byte{0xFF}, HeaderLen)
+	if expect, actual := asm.Finalize(asm.PartialSum(ones, 0)), Checksum(ones); actual != expect {
+		t.Errorf("saturated: expected %#04x, but got %#04x", expect, actual)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	corrupt := func(f func(b []byte) []byte) []byte {
+		return f(bytes.Clone(wikipedia))
+	}
+
+	var (
+		versionErr  *VersionError
+		headerErr   *HeaderLenError
+		totalErr    *TotalLenError
+		checksumErr *ChecksumError
+	)
+
+	tests := []struct {
+		name   string
+		packet []byte
+		target any
+	}{
+		{"empty", nil, &headerErr},
+		{"version", corrupt(func(b []byte) []byte { b[0] = 0x65; return b }), &versionErr},
+		{"short", wikipedia[:19], &headerErr},
+		{"ihl too small", corrupt(func(b []byte) []byte { b[0] = 0x44; return b }), &headerErr},
+		{"ihl past the end", corrupt(func(b []byte) []byte { b[0] = 0x4F; return b[:40] }), &headerErr},
+		{"total too small", corrupt(func(b []byte) []byte { b[3] = 19; return b }), &totalErr},
+		{"total past the end", wikipedia[:100], &totalErr},
+		{"checksum", corrupt(func(b []byte) []byte { b[8]--; return b }), &checksumErr},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h, err := Parse(tc.packet)
+			if !errors.As(err, tc.target) {
+				t.Fatalf("Expected a %T, but got %v", tc.target, err)
+			}
+			if (h != nil) != (tc.name == "checksum") {
+				t.Errorf("unexpected header alongside %v: %+v", err, h)
+			}
+		})
+	}
+
+	// the checksum error knows what the checksum should have been
+	bad := corrupt(func(b []byte) []byte { b[8]--; return b })
+	_, err := Parse(bad)
+	if !errors.As(err, &checksumErr) || checksumErr.Checksum != 0xb861 {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	bad[10], bad[11] = byte(checksumErr.Expected>>8), byte(checksumErr.Expected)
+	if _, err := Parse(bad); err != nil {
+		t.Errorf("fixing the checksum with the expected value didn't work: %v", err)
+	}
+
+	if _, err := (&Header{TotalLen: 60, Options: make([]byte, 41)}).Marshal(); !errors.As(err, &headerErr) {
+		t.Errorf("Expected a *HeaderLenError for 41 bytes of options, but got %v", err)
+	}
+	if _, err := (&Header{TotalLen: 10}).Marshal(); !errors.As(err, &totalErr) {
+		t.Errorf("Expected a *TotalLenError for a total length of 10, but got %v", err)
+	}
+}
+
+func BenchmarkChecksum(b *testing.B) {
+	hdr := wikipedia[:HeaderLen]
+	b.Run("unrolled", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			checksum20(hdr)
+		}
+	})
+	b.Run("kernel", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			asm.Finalize(asm.PartialSum(hdr, 0))
+		}
+	})
+}
@@ -0,0 +1,52 @@
+package asm
+
+import (
+	"runtime"
+	"sync"
+)
+
+// ParallelThreshold is the smallest input ChecksumParallel splits up between goroutines,
+// anything shorter is checksummed on the calling goroutine. Below a megabyte or so
+// the goroutines cost more than they save.
+var ParallelThreshold = 1 << 20
+
+// ChecksumParallel returns the RFC 1071 internet checksum of data, split across up to
+// workers goroutines (GOMAXPROCS if workers < 1). Every chunk starts at an even offset,
+// so the partial sums of the chunks just add up with their end-around carries.
+func ChecksumParallel(data []byte, workers int) uint16 {
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers == 1 || len(data) == 0 || len(data) < ParallelThreshold {
+		return checksumWide(data)
+	}
+
+	// round the chunk size up to an even number, the last chunk takes the odd byte if there is one
+	chunk := (len(data) + workers - 1) / workers
+	chunk += chunk & 1
+
+	sums := make([]uint32, 0, workers)
+	for off := 0; off < len(data); off += chunk {
+		sums = append(sums, 0)
+	}
+
+	var wg sync.WaitGroup
+	for i := range sums {
+		part := data[i*chunk : min((i+1)*chunk, len(data))]
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// each goroutine only ever touches its own slot
+			sums[i] = partialSum(part, 0)
+		}(i)
+	}
+	wg.Wait()
+
+	var sum uint64
+	for _, s := range sums {
+		sum += uint64(s)
+	}
+	// 64 --> 32, the carry of which can't carry again
+	sum = sum&0xFFFFFFFF + sum>>32
+	return Finalize(uint32(sum&0xFFFFFFFF + sum>>32))
+}
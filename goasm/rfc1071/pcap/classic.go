@@ -0,0 +1,158 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// libpcap file magic, written in the byte order of the host that captured it.
+const (
+	magicMicro = 0xa1b2c3d4
+	magicNano  = 0xa1b23c4d
+)
+
+// FileHeader is the header of a pcap file. Writing a file with the header it was read with
+// and the packets as read reproduces it byte for byte.
+type FileHeader struct {
+	ByteOrder    binary.ByteOrder
+	Nanosecond   bool // nanosecond instead of microsecond timestamps
+	VersionMajor uint16
+	VersionMinor uint16
+	ThisZone     int32  // always 0 in practice
+	SigFigs      uint32 // always 0 in practice
+	SnapLen      uint32
+	LinkType     LinkType
+	// LinkFlags are the upper 16 bits of the link type field, FCS length information.
+	LinkFlags uint16
+}
+
+// fileHeader reads the 24 byte pcap file header.
+func (r *Reader) fileHeader() error {
+	b, err := r.read(24)
+	if err != nil {
+		return ErrFormat
+	}
+	r.header = FileHeader{
+		ByteOrder:    r.order,
+		Nanosecond:   r.order.Uint32(b[0:4]) == magicNano,
+		VersionMajor: r.order.Uint16(b[4:6]),
+		VersionMinor: r.order.Uint16(b[6:8]),
+		ThisZone:     int32(r.order.Uint32(b[8:12])),
+		SigFigs:      r.order.Uint32(b[12:16]),
+		SnapLen:      r.order.Uint32(b[16:20]),
+		LinkType:     LinkType(r.order.Uint32(b[20:24])),
+		LinkFlags:    uint16(r.order.Uint32(b[20:24]) >> 16),
+	}
+	units := uint64(1e6)
+	if r.header.Nanosecond {
+		units = 1e9
+	}
+	r.ifaces = []iface{{linkType: r.header.LinkType, snapLen: r.header.SnapLen, units: units}}
+	return nil
+}
+
+// FileHeader returns the pcap file header. For pcapng files it returns the header a pcap
+// file holding the packets of the first interface would have, with nanosecond timestamps.
+func (r *Reader) FileHeader() FileHeader {
+	if !r.ng {
+		return r.header
+	}
+	h := FileHeader{ByteOrder: binary.LittleEndian, Nanosecond: true, VersionMajor: 2, VersionMinor: 4, SnapLen: 0x40000}
+	if len(r.ifaces) > 0 {
+		h.LinkType = r.ifaces[0].linkType
+		if r.ifaces[0].snapLen != 0 {
+			h.SnapLen = r.ifaces[0].snapLen
+		}
+	}
+	return h
+}
+
+// Writer writes packets to a pcap file.
+type Writer struct {
+	w      io.Writer
+	header FileHeader
+	buf    [16]byte
+}
+
+// NewWriter writes the file header h to w and returns a Writer for the packets.
+// A nil byte order means little endian and a zero version 2.4.
+func NewWriter(w io.Writer, h FileHeader) (*Writer, error) {
+	if h.ByteOrder == nil {
+		h.ByteOrder = binary.LittleEndian
+	}
+	if h.VersionMajor == 0 && h.VersionMinor == 0 {
+		h.VersionMajor, h.VersionMinor = 2, 4
+	}
+	var b [24]byte
+	magic := uint32(magicMicro)
+	if h.Nanosecond {
+		magic = magicNano
+	}
+	h.ByteOrder.PutUint32(b[0:4], magic)
+	h.ByteOrder.PutUint16(b[4:6], h.VersionMajor)
+	h.ByteOrder.PutUint16(b[6:8], h.VersionMinor)
+	h.ByteOrder.PutUint32(b[8:12], uint32(h.ThisZone))
+	h.ByteOrder.PutUint32(b[12:16], h.SigFigs)
+	h.ByteOrder.PutUint32(b[16:20], h.SnapLen)
+	h.ByteOrder.PutUint32(b[20:24], uint32(h.LinkFlags)<<16|uint32(h.LinkType))
+	if _, err := w.Write(b[:]); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, header: h}, nil
+}
+
+// WritePacket writes a record for p. Its link type and interface are ignored, the file
+// only has the one from the header.
+func (w *Writer) WritePacket(p *Packet) error {
+	// packets without a timestamp (pcapng simple packet blocks) go in at the epoch
+	var sec int64
+	var frac int
+	if !p.Timestamp.IsZero() {
+		sec, frac = p.Timestamp.Unix(), p.Timestamp.Nanosecond()
+	}
+	if !w.header.Nanosecond {
+		frac /= int(time.Microsecond)
+	}
+	w.header.ByteOrder.PutUint32(w.buf[0:4], uint32(sec))
+	w.header.ByteOrder.PutUint32(w.buf[4:8], uint32(frac))
+	w.header.ByteOrder.PutUint32(w.buf[8:12], uint32(len(p.Data)))
+	w.header.ByteOrder.PutUint32(w.buf[12:16], uint32(p.OrigLen))
+	if _, err := w.w.Write(w.buf[:]); err != nil {
+		return err
+	}
+	_, err := w.w.Write(p.Data)
+	return err
+}
+
+// nextRecord reads a 16 byte record header and the packet that follows it.
+func (r *Reader) nextRecord() (*Packet, error) {
+	hdr, err := r.read(16)
+	if err == io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("%w: truncated record header", ErrCorrupt)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sec, frac := r.order.Uint32(hdr[0:4]), r.order.Uint32(hdr[4:8])
+	capLen, origLen := r.order.Uint32(hdr[8:12]), r.order.Uint32(hdr[12:16])
+	// checked before they're ints, which a corrupt length would turn negative on 32 bit
+	if capLen > maxPacket || origLen > maxPacket {
+		return nil, fmt.Errorf("%w: %d byte record", ErrCorrupt, max(capLen, origLen))
+	}
+
+	data, err := r.read(int(capLen))
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("%w: truncated packet", ErrCorrupt)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Packet{
+		Timestamp: timestamp(uint64(sec), uint64(frac), r.ifaces[0].units),
+		LinkType:  r.ifaces[0].linkType,
+		OrigLen:   int(origLen),
+		Data:      data,
+	}, nil
+}
@@ -0,0 +1,74 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// EtherTypes of the network layers we know about.
+const (
+	EtherTypeIPv4 uint16 = 0x0800
+	EtherTypeIPv6 uint16 = 0x86dd
+)
+
+var ErrLinkTruncated = errors.New("pcap: truncated link layer header")
+
+// Network strips the link layer header, VLAN tags included, off the packet and returns the
+// EtherType of what's left along with the rest of the packet, which aliases p.Data.
+// Linux cooked captures know which way a packet went, in which case the returned direction
+// says so, otherwise it's p.Direction.
+func (p *Packet) Network() (etherType uint16, payload []byte, dir Direction, err error) {
+	b, dir := p.Data, p.Direction
+	switch p.LinkType {
+	case LinkTypeEthernet:
+		if len(b) < 14 {
+			return 0, nil, dir, ErrLinkTruncated
+		}
+		etherType, b = binary.BigEndian.Uint16(b[12:14]), b[14:]
+		// 802.1Q, 802.1ad and the old QinQ tag, as many as there are
+		for etherType == 0x8100 || etherType == 0x88a8 || etherType == 0x9100 {
+			if len(b) < 4 {
+				return 0, nil, dir, ErrLinkTruncated
+			}
+			etherType, b = binary.BigEndian.Uint16(b[2:4]), b[4:]
+		}
+	case LinkTypeLinuxSLL:
+		if len(b) < 16 {
+			return 0, nil, dir, ErrLinkTruncated
+		}
+		// packet type 4 is "sent by us", 0 "sent to us"
+		switch binary.BigEndian.Uint16(b[0:2]) {
+		case 0:
+			dir = DirectionInbound
+		case 4:
+			dir = DirectionOutbound
+		}
+		etherType, b = binary.BigEndian.Uint16(b[14:16]), b[16:]
+	case LinkTypeNull:
+		if len(b) < 4 {
+			return 0, nil, dir, ErrLinkTruncated
+		}
+		// the address family is in the capturing host's byte order, either way it's a small number
+		family := binary.LittleEndian.Uint32(b[0:4])
+		if family > 0xFFFF {
+			family = binary.BigEndian.Uint32(b[0:4])
+		}
+		switch family {
+		case 2:
+			etherType = EtherTypeIPv4
+		case 24, 28, 30: // AF_INET6 on the BSDs, FreeBSD and darwin
+			etherType = EtherTypeIPv6
+		}
+		b = b[4:]
+	case LinkTypeRaw:
+		if len(b) > 0 && b[0]>>4 == 6 {
+			etherType = EtherTypeIPv6
+		} else {
+			etherType = EtherTypeIPv4
+		}
+	default:
+		return 0, nil, dir, fmt.Errorf("pcap: unsupported link type %d", p.LinkType)
+	}
+	return etherType, b, dir, nil
+}
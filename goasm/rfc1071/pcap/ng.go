@@ -0,0 +1,217 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// pcapng block types, https://www.ietf.org/archive/id/draft-ietf-opsawg-pcapng-02.html
+const (
+	blockSHB          = 0x0A0D0D0A // section header, a palindrome so it reads the same either way
+	blockIDB          = 0x00000001 // interface description
+	blockPacket       = 0x00000002 // obsolete packet block
+	blockSimplePacket = 0x00000003
+	blockEnhanced     = 0x00000006
+
+	byteOrderMagic = 0x1A2B3C4D
+)
+
+// option codes we look at
+const (
+	optEnd     = 0
+	optTSResol = 9 // if_tsresol
+	optFlags   = 2 // epb_flags
+)
+
+// section reads a section header block, which resets the byte order and the interfaces.
+func (r *Reader) section() error {
+	hdr, err := r.read(12)
+	if err != nil {
+		return fmt.Errorf("%w: truncated section header", ErrCorrupt)
+	}
+	switch {
+	case binary.LittleEndian.Uint32(hdr[8:12]) == byteOrderMagic:
+		r.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(hdr[8:12]) == byteOrderMagic:
+		r.order = binary.BigEndian
+	default:
+		return fmt.Errorf("%w: bad byte order magic", ErrCorrupt)
+	}
+	length := r.order.Uint32(hdr[4:8])
+	if length < 28 || length%4 != 0 || length > maxPacket {
+		return fmt.Errorf("%w: bad section header length %d", ErrCorrupt, length)
+	}
+	// version, section length and options, nothing we need
+	if _, err := r.read(int(length) - 12); err != nil {
+		return fmt.Errorf("%w: truncated section header", ErrCorrupt)
+	}
+	r.ifaces = r.ifaces[:0]
+	return nil
+}
+
+// nextBlock reads blocks until it finds a packet, keeping track of sections and interfaces.
+func (r *Reader) nextBlock() (*Packet, error) {
+	for {
+		hdr, err := r.r.Peek(8)
+		if err == io.EOF && len(hdr) == 0 {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: truncated block header", ErrCorrupt)
+		}
+		typ := r.order.Uint32(hdr[0:4])
+		if typ == blockSHB {
+			if err := r.section(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		length := r.order.Uint32(hdr[4:8])
+		if length < 12 || length%4 != 0 || length > maxPacket {
+			return nil, fmt.Errorf("%w: bad block length %d", ErrCorrupt, length)
+		}
+		block, err := r.read(int(length))
+		if err != nil {
+			return nil, fmt.Errorf("%w: truncated block", ErrCorrupt)
+		}
+		if r.order.Uint32(block[length-4:]) != length {
+			return nil, fmt.Errorf("%w: block length mismatch", ErrCorrupt)
+		}
+		body := block[8 : length-4]
+
+		switch typ {
+		case blockIDB:
+			if err := r.interfaceBlock(body); err != nil {
+				return nil, err
+			}
+		case blockEnhanced, blockPacket, blockSimplePacket:
+			return r.packetBlock(typ, body)
+		}
+		// everything else (name resolution, statistics, ...) is skipped
+	}
+}
+
+// interfaceBlock reads an interface description block body.
+func (r *Reader) interfaceBlock(body []byte) error {
+	if len(body) < 8 {
+		return fmt.Errorf("%w: short interface block", ErrCorrupt)
+	}
+	i := iface{
+		linkType: LinkType(r.order.Uint16(body[0:2])),
+		snapLen:  r.order.Uint32(body[4:8]),
+		units:    1e6,
+	}
+	err := r.options(body[8:], func(code uint16, value []byte) {
+		if code != optTSResol || len(value) < 1 {
+			return
+		}
+		// high bit set: a negative power of two, otherwise of ten
+		exp := value[0] & 0x7F
+		if value[0]&0x80 != 0 && exp < 64 {
+			i.units = 1 << exp
+		} else if value[0]&0x80 == 0 && exp <= 19 {
+			i.units = 1
+			for ; exp > 0; exp-- {
+				i.units *= 10
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	r.ifaces = append(r.ifaces, i)
+	return nil
+}
+
+// packetBlock reads an enhanced, simple or obsolete packet block body.
+func (r *Reader) packetBlock(typ uint32, body []byte) (*Packet, error) {
+	// the lengths and interface stay uint32 until they're checked, a corrupt one
+	// would turn negative as an int on 32 bit and get past the checks
+	var (
+		ifaceID         uint32
+		ts              uint64
+		capLen, origLen uint32
+		data, opts      []byte
+	)
+	switch typ {
+	case blockSimplePacket:
+		if len(body) < 4 {
+			return nil, fmt.Errorf("%w: short simple packet block", ErrCorrupt)
+		}
+		origLen = r.order.Uint32(body[0:4])
+		capLen = min(origLen, uint32(len(body)-4))
+		data = body[4:]
+	case blockEnhanced, blockPacket:
+		if len(body) < 20 {
+			return nil, fmt.Errorf("%w: short packet block", ErrCorrupt)
+		}
+		if typ == blockEnhanced {
+			ifaceID = r.order.Uint32(body[0:4])
+		} else {
+			ifaceID = uint32(r.order.Uint16(body[0:2]))
+		}
+		ts = uint64(r.order.Uint32(body[4:8]))<<32 | uint64(r.order.Uint32(body[8:12]))
+		capLen, origLen = r.order.Uint32(body[12:16]), r.order.Uint32(body[16:20])
+		data = body[20:]
+	}
+	if ifaceID >= uint32(len(r.ifaces)) {
+		return nil, fmt.Errorf("%w: packet for undeclared interface %d", ErrCorrupt, ifaceID)
+	}
+	if origLen > maxPacket {
+		return nil, fmt.Errorf("%w: %d byte packet", ErrCorrupt, origLen)
+	}
+	i := r.ifaces[ifaceID]
+	if typ == blockSimplePacket && i.snapLen != 0 {
+		capLen = min(capLen, i.snapLen)
+	}
+	if capLen > uint32(len(data)) {
+		return nil, fmt.Errorf("%w: captured length %d past the end of the block", ErrCorrupt, capLen)
+	}
+	opts, data = data[(capLen+3)&^3:], data[:capLen]
+
+	p := &Packet{
+		LinkType:  i.linkType,
+		Interface: int(ifaceID),
+		OrigLen:   int(origLen),
+		Data:      data,
+	}
+	if typ != blockSimplePacket {
+		p.Timestamp = timestamp(ts/i.units, ts%i.units, i.units)
+	}
+	if typ == blockEnhanced && len(opts) > 0 {
+		err := r.options(opts, func(code uint16, value []byte) {
+			if code == optFlags && len(value) >= 4 {
+				// the low two bits are the direction: 01 inbound, 10 outbound
+				switch r.order.Uint32(value) & 0x3 {
+				case 1:
+					p.Direction = DirectionInbound
+				case 2:
+					p.Direction = DirectionOutbound
+				}
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// options calls f for each option in b, up to the end of options marker or the end of b.
+func (r *Reader) options(b []byte, f func(code uint16, value []byte)) error {
+	for len(b) >= 4 {
+		code, n := r.order.Uint16(b[0:2]), int(r.order.Uint16(b[2:4]))
+		if code == optEnd {
+			return nil
+		}
+		padded := (n + 3) &^ 3
+		if len(b) < 4+padded {
+			return fmt.Errorf("%w: option %d past the end of the block", ErrCorrupt, code)
+		}
+		f(code, b[4:4+n])
+		b = b[4+padded:]
+	}
+	return nil
+}
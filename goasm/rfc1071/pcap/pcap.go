@@ -0,0 +1,159 @@
+// Package pcap reads libpcap (.pcap) and pcapng capture files, no cgo and no libpcap.
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"time"
+)
+
+// LinkType is the link layer header type of the packets in a capture, see
+// https://www.tcpdump.org/linktypes.html.
+type LinkType uint16
+
+const (
+	LinkTypeNull     LinkType = 0 // BSD loopback, a host byte order address family
+	LinkTypeEthernet LinkType = 1
+	LinkTypeRaw      LinkType = 101 // a bare IPv4 or IPv6 packet
+	LinkTypeLinuxSLL LinkType = 113 // linux "any" device cooked capture
+)
+
+// Direction is the direction of a packet relative to the capturing host,
+// when the capture says so (pcapng epb_flags, linux cooked captures).
+type Direction uint8
+
+const (
+	DirectionUnknown Direction = iota
+	DirectionInbound
+	DirectionOutbound
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionInbound:
+		return "inbound"
+	case DirectionOutbound:
+		return "outbound"
+	default:
+		return "unknown"
+	}
+}
+
+var (
+	ErrFormat  = errors.New("pcap: not a pcap or pcapng file")
+	ErrCorrupt = errors.New("pcap: corrupt capture")
+)
+
+// maxPacket is the biggest record we're willing to allocate for, anything bigger is
+// a corrupt file rather than a packet.
+const maxPacket = 16 << 20
+
+// Packet is a captured packet.
+type Packet struct {
+	Timestamp time.Time
+	LinkType  LinkType
+	Interface int // pcapng interface index, always 0 for pcap
+	Direction Direction
+	// OrigLen is the length of the packet on the wire, Data may be shorter if the
+	// capture was snapped.
+	OrigLen int
+	// Data is only valid until the next call to Next.
+	Data []byte
+}
+
+// Truncated reports whether the capture holds less than the whole packet.
+func (p *Packet) Truncated() bool {
+	return len(p.Data) < p.OrigLen
+}
+
+type iface struct {
+	linkType LinkType
+	snapLen  uint32
+	// units is how many timestamp units make up a second
+	units uint64
+}
+
+// Reader reads packets from a pcap or pcapng stream.
+type Reader struct {
+	r     *bufio.Reader
+	order binary.ByteOrder
+	ng    bool
+	// pcap has a single link type and timestamp resolution for the whole file,
+	// pcapng one per interface per section.
+	ifaces []iface
+	header FileHeader
+	// pending is the first pcapng packet, read ahead by NewReader
+	pending *Packet
+	buf     []byte
+}
+
+// NewReader reads the file header from r and returns a Reader for the packets that follow.
+func NewReader(r io.Reader) (*Reader, error) {
+	pr := &Reader{r: bufio.NewReaderSize(r, 64<<10)}
+	magic, err := pr.r.Peek(4)
+	if err != nil {
+		return nil, ErrFormat
+	}
+	switch {
+	case binary.LittleEndian.Uint32(magic) == blockSHB:
+		pr.ng = true
+		if err := pr.section(); err != nil {
+			return nil, err
+		}
+		// read up to the first packet, so that the interfaces are known for FileHeader
+		p, err := pr.nextBlock()
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		pr.pending = p
+		return pr, nil
+	case binary.LittleEndian.Uint32(magic) == magicMicro || binary.LittleEndian.Uint32(magic) == magicNano:
+		pr.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(magic) == magicMicro || binary.BigEndian.Uint32(magic) == magicNano:
+		pr.order = binary.BigEndian
+	default:
+		return nil, ErrFormat
+	}
+	return pr, pr.fileHeader()
+}
+
+// Next returns the next packet, or io.EOF at the end of the capture.
+func (r *Reader) Next() (*Packet, error) {
+	if p := r.pending; p != nil {
+		r.pending = nil
+		return p, nil
+	}
+	if r.ng {
+		return r.nextBlock()
+	}
+	return r.nextRecord()
+}
+
+// read reads exactly n bytes into the reusable buffer. A clean EOF before the first byte
+// is io.EOF, anything else that comes up short is io.ErrUnexpectedEOF.
+func (r *Reader) read(n int) ([]byte, error) {
+	if n > maxPacket {
+		return nil, fmt.Errorf("%w: %d byte record", ErrCorrupt, n)
+	}
+	if cap(r.buf) < n {
+		r.buf = make([]byte, n)
+	}
+	b := r.buf[:n]
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// timestamp converts seconds and a fraction of a second in units (per second) into a time.
+func timestamp(sec, frac, units uint64) time.Time {
+	sec, frac = sec+frac/units, frac%units
+	// frac < units, so the 128 bit product divides without overflowing
+	hi, lo := bits.Mul64(frac, uint64(time.Second))
+	nsec, _ := bits.Div64(hi, lo, units)
+	return time.Unix(int64(sec), int64(nsec))
+}
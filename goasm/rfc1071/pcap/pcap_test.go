@@ -0,0 +1,277 @@
+package pcap
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func readAll(t *testing.T, name string) []*Packet {
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var packets []*Packet
+	for {
+		p, err := r.Next()
+		if err == io.EOF {
+			return packets
+		}
+		if err != nil {
+			t.Fatalf("packet %d: %v", len(packets), err)
+		}
+		c := *p
+		c.Data = bytes.Clone(p.Data)
+		packets = append(packets, &c)
+	}
+}
+
+func TestPcap(t *testing.T) {
+	packets := readAll(t, "testdata/capture.pcap")
+	if len(packets) != 17 {
+		t.Fatalf("Expected 17 packets, but got %d", len(packets))
+	}
+	for i, p := range packets {
+		if p.LinkType != LinkTypeEthernet || p.Direction != DirectionUnknown {
+			t.Errorf("packet %d: unexpected link type %d or direction %s", i, p.LinkType, p.Direction)
+		}
+		if expect := time.Unix(1700000000+int64(i), int64(i*1000+7)*1000); !p.Timestamp.Equal(expect) {
+			t.Errorf("packet %d: expected timestamp %v, but got %v", i, expect, p.Timestamp)
+		}
+	}
+	// the first packet is a 54 byte TCP SYN
+	if len(packets[0].Data) != 54 || packets[0].Data[12] != 0x08 || packets[0].Truncated() {
+		t.Errorf("unexpected first packet: %x", packets[0].Data)
+	}
+	// and the last one was snapped at 54 bytes
+	if last := packets[16]; len(last.Data) != 54 || last.OrigLen != 154 || !last.Truncated() {
+		t.Errorf("Expected a truncated last packet, but got %d of %d bytes", len(last.Data), last.OrigLen)
+	}
+}
+
+func TestPcapBigEndianNano(t *testing.T) {
+	packets := readAll(t, "testdata/bigendian_ns.pcap")
+	if len(packets) != 2 {
+		t.Fatalf("Expected 2 packets, but got %d", len(packets))
+	}
+	if expect := time.Unix(1700000003, 3007000); !packets[1].Timestamp.Equal(expect) {
+		t.Errorf("Expected timestamp %v, but got %v", expect, packets[1].Timestamp)
+	}
+	le := readAll(t, "testdata/capture.pcap")
+	if !bytes.Equal(packets[1].Data, le[3].Data) {
+		t.Errorf("big endian packet doesn't match its little endian twin")
+	}
+}
+
+func TestPcapng(t *testing.T) {
+	packets := readAll(t, "testdata/capture.pcapng")
+	classic := readAll(t, "testdata/capture.pcap")
+	// the same packets plus a trailing simple packet block
+	if len(packets) != len(classic)+1 {
+		t.Fatalf("Expected %d packets, but got %d", len(classic)+1, len(packets))
+	}
+	for i, c := range classic {
+		p := packets[i]
+		if !bytes.Equal(p.Data, c.Data) || p.OrigLen != c.OrigLen {
+			t.Errorf("packet %d doesn't match the pcap version", i)
+		}
+		// interface 0 has nanosecond timestamps, 1 the default microseconds
+		expect := time.Unix(1700000000+int64(i), int64(i*1000+7))
+		if i == 6 {
+			expect = time.Unix(1700000000+int64(i), int64(i*1000+7)*1000)
+			if p.Interface != 1 {
+				t.Errorf("packet %d: expected interface 1, but got %d", i, p.Interface)
+			}
+		}
+		if !p.Timestamp.Equal(expect) {
+			t.Errorf("packet %d: expected timestamp %v, but got %v", i, expect, p.Timestamp)
+		}
+		if p.Direction == DirectionUnknown {
+			t.Errorf("packet %d: direction missing", i)
+		}
+	}
+	if packets[0].Direction != DirectionOutbound || packets[1].Direction != DirectionInbound {
+		t.Errorf("unexpected directions %s and %s", packets[0].Direction, packets[1].Direction)
+	}
+	if spb := packets[len(packets)-1]; !bytes.Equal(spb.Data, classic[0].Data) || !spb.Timestamp.IsZero() {
+		t.Errorf("unexpected simple packet block: %x at %v", spb.Data, spb.Timestamp)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	if _, err := NewReader(bytes.NewReader([]byte("not a capture"))); !errors.Is(err, ErrFormat) {
+		t.Errorf("Expected %v, but got %v", ErrFormat, err)
+	}
+	if _, err := NewReader(bytes.NewReader(nil)); !errors.Is(err, ErrFormat) {
+		t.Errorf("empty: expected %v, but got %v", ErrFormat, err)
+	}
+
+	for _, name := range []string{"testdata/capture.pcap", "testdata/capture.pcapng"} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// cut the file off in the middle of a packet
+		r, err := NewReader(bytes.NewReader(data[:len(data)-30]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for err == nil {
+			_, err = r.Next()
+		}
+		if !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: expected %v for a truncated file, but got %v", name, ErrCorrupt, err)
+		}
+	}
+
+	// a record length that doesn't fit an int on 32 bit platforms, in either byte order
+	data, err := os.ReadFile("testdata/capture.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = bytes.Clone(data[:24+16])
+	copy(data[24+8:24+12], []byte{0xFF, 0xFF, 0xFF, 0xF0})
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Next(); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected %v for a huge record, but got %v", ErrCorrupt, err)
+	}
+
+	// the same for the first enhanced packet block of the pcapng capture, which starts
+	// at 164: its interface, captured and original length, and the block length itself
+	ng, err := os.ReadFile("testdata/capture.pcapng")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []int{164 + 8, 164 + 20, 164 + 24, 164 + 4} {
+		data := bytes.Clone(ng[:164+100])
+		copy(data[field:field+4], []byte{0xFF, 0xFF, 0xFF, 0xF0})
+		// the reader looks ahead for the first packet, so either call can fail
+		r, err := NewReader(bytes.NewReader(data))
+		if err == nil {
+			_, err = r.Next()
+		}
+		if !errors.Is(err, ErrCorrupt) {
+			t.Errorf("Expected %v for a huge field at %d, but got %v", ErrCorrupt, field, err)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	for _, name := range []string{"testdata/capture.pcap", "testdata/bigendian_ns.pcap"} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		w, err := NewWriter(&out, r.FileHeader())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for {
+			p, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := w.WritePacket(p); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !bytes.Equal(out.Bytes(), data) {
+			t.Errorf("%s didn't survive a round trip", name)
+		}
+	}
+
+	// pcapng comes out as a nanosecond pcap with the same packets
+	ng := readAll(t, "testdata/capture.pcapng")
+	f, err := os.Open("testdata/capture.pcapng")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	w, err := NewWriter(&out, r.FileHeader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range ng {
+		if err := w.WritePacket(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	packets := readAll(t, "testdata/capture.pcapng")
+	converted, err := NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range packets {
+		p, err := converted.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect := packets[i].Timestamp
+		if expect.IsZero() {
+			expect = time.Unix(0, 0)
+		}
+		if !bytes.Equal(p.Data, packets[i].Data) || !p.Timestamp.Equal(expect) || p.LinkType != LinkTypeEthernet {
+			t.Errorf("packet %d changed converting to pcap", i)
+		}
+	}
+}
+
+func TestNetwork(t *testing.T) {
+	packets := readAll(t, "testdata/capture.pcap")
+	expect := map[int]struct {
+		etherType uint16
+		offset    int
+	}{
+		0:  {EtherTypeIPv4, 14},
+		6:  {EtherTypeIPv4, 18}, // 802.1Q
+		9:  {EtherTypeIPv6, 14},
+		13: {EtherTypeIPv4, 22}, // 802.1ad + 802.1Q
+		14: {0x0806, 14},        // ARP
+	}
+	for i, e := range expect {
+		etherType, payload, _, err := packets[i].Network()
+		if err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+		if etherType != e.etherType || len(payload) != len(packets[i].Data)-e.offset {
+			t.Errorf("packet %d: expected ethertype %#04x at %d, but got %#04x at %d",
+				i, e.etherType, e.offset, etherType, len(packets[i].Data)-len(payload))
+		}
+	}
+
+	sll := &Packet{LinkType: LinkTypeLinuxSLL, Data: append([]byte{0, 4, 0, 1, 0, 6, 2, 0, 0, 0, 0, 1, 0, 0, 0x86, 0xdd}, 0x60)}
+	if etherType, payload, dir, err := sll.Network(); err != nil || etherType != EtherTypeIPv6 || dir != DirectionOutbound || len(payload) != 1 {
+		t.Errorf("unexpected cooked capture result: %#04x %x %s %v", etherType, payload, dir, err)
+	}
+	null := &Packet{LinkType: LinkTypeNull, Data: []byte{2, 0, 0, 0, 0x45}}
+	if etherType, _, _, err := null.Network(); err != nil || etherType != EtherTypeIPv4 {
+		t.Errorf("unexpected loopback result: %#04x %v", etherType, err)
+	}
+	if _, _, _, err := (&Packet{LinkType: LinkTypeEthernet, Data: make([]byte, 13)}).Network(); !errors.Is(err, ErrLinkTruncated) {
+		t.Errorf("Expected %v, but got %v", ErrLinkTruncated, err)
+	}
+}
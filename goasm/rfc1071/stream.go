@@ -0,0 +1,123 @@
+package asm
+
+import "io"
+
+// streamBuffer is the buffer size ChecksumReader.WriteTo and ChecksumWriter.ReadFrom use
+// when io.Copy hands them the whole stream, a lot bigger than io.Copy's own 32KB so the
+// kernel spends its time summing rather than being called.
+const streamBuffer = 256 << 10
+
+var (
+	_ io.Reader     = (*ChecksumReader)(nil)
+	_ io.WriterTo   = (*ChecksumReader)(nil)
+	_ io.Writer     = (*ChecksumWriter)(nil)
+	_ io.ReaderFrom = (*ChecksumWriter)(nil)
+)
+
+// ChecksumReader checksums everything read through it. Reads can be any length,
+// odd ones included, see Checksum.
+type ChecksumReader struct {
+	r     io.Reader
+	state Checksum
+}
+
+// NewChecksumReader returns a ChecksumReader reading from r.
+func NewChecksumReader(r io.Reader) *ChecksumReader {
+	return &ChecksumReader{r: r}
+}
+
+// Read reads from the underlying reader and adds whatever it got to the checksum.
+func (cr *ChecksumReader) Read(p []byte) (n int, err error) {
+	n, err = cr.r.Read(p)
+	_, _ = cr.state.Write(p[:n])
+	return n, err
+}
+
+// WriteTo reads the underlying reader until EOF in big chunks, writing everything to w.
+// It lets io.Copy use a buffer sized for the kernel instead of its own.
+func (cr *ChecksumReader) WriteTo(w io.Writer) (n int64, err error) {
+	buf := make([]byte, streamBuffer)
+	for {
+		nr, rerr := cr.Read(buf)
+		if nr > 0 {
+			nw, werr := w.Write(buf[:nr])
+			n += int64(nw)
+			if werr != nil {
+				return n, werr
+			}
+			if nw != nr {
+				return n, io.ErrShortWrite
+			}
+		}
+		if rerr == io.EOF {
+			return n, nil
+		}
+		if rerr != nil {
+			return n, rerr
+		}
+	}
+}
+
+// Sum16 returns the checksum of everything read so far.
+func (cr *ChecksumReader) Sum16() uint16 {
+	return cr.state.Sum16()
+}
+
+// Count returns the number of bytes read so far.
+func (cr *ChecksumReader) Count() uint64 {
+	return cr.state.Count()
+}
+
+// ChecksumWriter checksums everything written through it on its way to the underlying
+// writer, like an io.MultiWriter with a Checksum on the side.
+type ChecksumWriter struct {
+	w     io.Writer
+	state Checksum
+}
+
+// NewChecksumWriter returns a ChecksumWriter writing to w.
+func NewChecksumWriter(w io.Writer) *ChecksumWriter {
+	return &ChecksumWriter{w: w}
+}
+
+// Write writes p to the underlying writer and adds the bytes it accepted to the checksum.
+func (cw *ChecksumWriter) Write(p []byte) (n int, err error) {
+	n, err = cw.w.Write(p)
+	_, _ = cw.state.Write(p[:n])
+	return n, err
+}
+
+// ReadFrom copies r to the underlying writer until EOF in big chunks.
+// It lets io.Copy use a buffer sized for the kernel instead of its own.
+func (cw *ChecksumWriter) ReadFrom(r io.Reader) (n int64, err error) {
+	buf := make([]byte, streamBuffer)
+	for {
+		nr, rerr := r.Read(buf)
+		if nr > 0 {
+			nw, werr := cw.Write(buf[:nr])
+			n += int64(nw)
+			if werr != nil {
+				return n, werr
+			}
+			if nw != nr {
+				return n, io.ErrShortWrite
+			}
+		}
+		if rerr == io.EOF {
+			return n, nil
+		}
+		if rerr != nil {
+			return n, rerr
+		}
+	}
+}
+
+// Sum16 returns the checksum of everything written so far.
+func (cw *ChecksumWriter) Sum16() uint16 {
+	return cw.state.Sum16()
+}
+
+// Count returns the number of bytes written so far.
+func (cw *ChecksumWriter) Count() uint64 {
+	return cw.state.Count()
+}
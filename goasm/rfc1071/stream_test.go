@@ -0,0 +1,91 @@
+package asm
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"strconv"
+	"testing"
+	"testing/iotest"
+)
+
+func TestChecksumReader(t *testing.T) {
+	for _, n := range []int{0, 1, 5, 4095, streamBuffer + 3} {
+		data := make([]byte, n)
+		rand.Read(data)
+		expect := rfc1071(data)
+
+		readers := map[string]func(io.Reader) io.Reader{
+			"plain":   func(r io.Reader) io.Reader { return r },
+			"onebyte": iotest.OneByteReader,
+			"half":    iotest.HalfReader,
+		}
+		for name, wrap := range readers {
+			t.Run(name+"/"+strconv.Itoa(n)+"b", func(t *testing.T) {
+				cr := NewChecksumReader(wrap(bytes.NewReader(data)))
+				var out bytes.Buffer
+				if _, err := io.Copy(&out, cr); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(out.Bytes(), data) {
+					t.Fatalf("copied data doesn't match")
+				}
+				if actual := cr.Sum16(); actual != expect {
+					t.Errorf("Expected %v, but got %v", expect, actual)
+				}
+				if cr.Count() != uint64(n) {
+					t.Errorf("Expected a count of %d, but got %d", n, cr.Count())
+				}
+			})
+		}
+	}
+
+	t.Run("error", func(t *testing.T) {
+		boom := errors.New("boom")
+		cr := NewChecksumReader(io.MultiReader(bytes.NewReader([]byte("hello")), iotest.ErrReader(boom)))
+		if _, err := io.Copy(io.Discard, cr); !errors.Is(err, boom) {
+			t.Fatalf("Expected %v, but got %v", boom, err)
+		}
+		if cr.Sum16() != 48173 {
+			t.Errorf("Expected 48173 for what was read before the error, but got %v", cr.Sum16())
+		}
+	})
+}
+
+func TestChecksumWriter(t *testing.T) {
+	for _, n := range []int{0, 1, 5, 4095, streamBuffer + 3} {
+		data := make([]byte, n)
+		rand.Read(data)
+		expect := rfc1071(data)
+
+		t.Run("copy/"+strconv.Itoa(n)+"b", func(t *testing.T) {
+			var out bytes.Buffer
+			cw := NewChecksumWriter(&out)
+			if _, err := io.Copy(cw, iotest.HalfReader(bytes.NewReader(data))); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out.Bytes(), data) {
+				t.Fatalf("written data doesn't match")
+			}
+			if actual := cw.Sum16(); actual != expect {
+				t.Errorf("Expected %v, but got %v", expect, actual)
+			}
+		})
+
+		t.Run("write/"+strconv.Itoa(n)+"b", func(t *testing.T) {
+			cw := NewChecksumWriter(io.Discard)
+			for rest := data; len(rest) > 0; {
+				m := rand.Intn(len(rest)) + 1
+				_, _ = cw.Write(rest[:m])
+				rest = rest[m:]
+			}
+			if actual := cw.Sum16(); actual != expect {
+				t.Errorf("Expected %v, but got %v", expect, actual)
+			}
+			if cw.Count() != uint64(n) {
+				t.Errorf("Expected a count of %d, but got %d", n, cw.Count())
+			}
+		})
+	}
+}
@@ -0,0 +1,7 @@
+//go:build tools
+
+package asm
+
+// asm.go is ignored by go mod tidy like it is by everything else, this keeps the generator's
+// requirements around when the module is tidied so that go generate works without a go get.
+import _ "asm/gen/rfc1071"
@@ -0,0 +1,148 @@
+package asm
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// IP protocol numbers we care about when building pseudo-headers.
+const (
+	ProtocolICMP   uint8 = 1
+	ProtocolTCP    uint8 = 6
+	ProtocolUDP    uint8 = 17
+	ProtocolICMPv6 uint8 = 58
+)
+
+var (
+	ErrTruncated   = errors.New("packet is truncated")
+	ErrIPVersion   = errors.New("unknown IP version")
+	ErrProtocol    = errors.New("unexpected transport protocol")
+	ErrFragmented  = errors.New("fragmented packets can't be verified")
+	ErrUnsupported = errors.New("unsupported IPv6 extension header")
+)
+
+// PseudoHeaderIPv4 returns the partial sum of the RFC 793/768 IPv4 pseudo-header:
+// source and destination address, zero, protocol and the transport length.
+func PseudoHeaderIPv4(src, dst [4]byte, protocol uint8, length uint16) uint32 {
+	sum := uint32(binary.BigEndian.Uint16(src[0:2])) + uint32(binary.BigEndian.Uint16(src[2:4]))
+	sum += uint32(binary.BigEndian.Uint16(dst[0:2])) + uint32(binary.BigEndian.Uint16(dst[2:4]))
+	return sum + uint32(protocol) + uint32(length)
+}
+
+// PseudoHeaderIPv6 returns the partial sum of the RFC 8200 IPv6 pseudo-header:
+// source and destination address, the 32 bit upper-layer length and the next header value.
+func PseudoHeaderIPv6(src, dst [16]byte, nextHeader uint8, length uint32) uint32 {
+	var sum uint32
+	for i := 0; i < 16; i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(src[i:])) + uint32(binary.BigEndian.Uint16(dst[i:]))
+	}
+	return sum + length>>16 + length&0xFFFF + uint32(nextHeader)
+}
+
+// TCPChecksum returns the checksum of a TCP segment given its pseudo-header sum.
+// The checksum field of the segment has to be zeroed beforehand.
+func TCPChecksum(pseudo uint32, segment []byte) uint16 {
+	return Finalize(partialSum(segment, pseudo))
+}
+
+// UDPChecksum returns the checksum of a UDP datagram given its pseudo-header sum.
+// The checksum field of the datagram has to be zeroed beforehand. A computed checksum
+// of zero is sent as 0xFFFF since zero means "no checksum" for UDP.
+func UDPChecksum(pseudo uint32, datagram []byte) uint16 {
+	if sum := Finalize(partialSum(datagram, pseudo)); sum != 0 {
+		return sum
+	}
+	return 0xFFFF
+}
+
+// VerifyTCP parses the IPv4 or IPv6 header of packet and reports whether the checksum
+// of the TCP segment it carries is correct.
+func VerifyTCP(packet []byte) (bool, error) {
+	pseudo, segment, err := transportPayload(packet, ProtocolTCP)
+	if err != nil {
+		return false, err
+	}
+	if len(segment) < 20 {
+		return false, ErrTruncated
+	}
+	return Finalize(partialSum(segment, pseudo)) == 0, nil
+}
+
+// VerifyUDP parses the IPv4 or IPv6 header of packet and reports whether the checksum
+// of the UDP datagram it carries is correct. A zero checksum means the sender didn't
+// compute one, which is fine over IPv4 but not allowed over IPv6.
+func VerifyUDP(packet []byte) (bool, error) {
+	pseudo, datagram, err := transportPayload(packet, ProtocolUDP)
+	if err != nil {
+		return false, err
+	}
+	if len(datagram) < 8 {
+		return false, ErrTruncated
+	}
+	if binary.BigEndian.Uint16(datagram[6:8]) == 0 {
+		return packet[0]>>4 == 4, nil
+	}
+	return Finalize(partialSum(datagram, pseudo)) == 0, nil
+}
+
+// transportPayload walks the IP header of packet and returns the pseudo-header sum
+// along with the transport payload, trimmed to the length the IP header claims.
+func transportPayload(packet []byte, protocol uint8) (uint32, []byte, error) {
+	if len(packet) < 1 {
+		return 0, nil, ErrTruncated
+	}
+
+	switch packet[0] >> 4 {
+	case 4:
+		if len(packet) < 20 {
+			return 0, nil, ErrTruncated
+		}
+		ihl := int(packet[0]&0x0F) * 4
+		total := int(binary.BigEndian.Uint16(packet[2:4]))
+		if ihl < 20 || total < ihl || len(packet) < total {
+			return 0, nil, ErrTruncated
+		}
+		// more fragments flag or a fragment offset
+		if binary.BigEndian.Uint16(packet[6:8])&0x3FFF != 0 {
+			return 0, nil, ErrFragmented
+		}
+		if packet[9] != protocol {
+			return 0, nil, ErrProtocol
+		}
+		payload := packet[ihl:total]
+		pseudo := PseudoHeaderIPv4([4]byte(packet[12:16]), [4]byte(packet[16:20]), protocol, uint16(len(payload)))
+		return pseudo, payload, nil
+	case 6:
+		if len(packet) < 40 {
+			return 0, nil, ErrTruncated
+		}
+		end := 40 + int(binary.BigEndian.Uint16(packet[4:6]))
+		if len(packet) < end {
+			return 0, nil, ErrTruncated
+		}
+		next, offset := packet[6], 40
+		for next != protocol {
+			switch next {
+			case 0, 60: // hop-by-hop and destination options
+				if end < offset+8 {
+					return 0, nil, ErrTruncated
+				}
+				next, offset = packet[offset], offset+(int(packet[offset+1])+1)*8
+			case 44:
+				return 0, nil, ErrFragmented
+			case 43, 50, 51: // routing, ESP, AH
+				return 0, nil, ErrUnsupported
+			default:
+				return 0, nil, ErrProtocol
+			}
+		}
+		if end < offset {
+			return 0, nil, ErrTruncated
+		}
+		payload := packet[offset:end]
+		pseudo := PseudoHeaderIPv6([16]byte(packet[8:24]), [16]byte(packet[24:40]), protocol, uint32(len(payload)))
+		return pseudo, payload, nil
+	default:
+		return 0, nil, ErrIPVersion
+	}
+}
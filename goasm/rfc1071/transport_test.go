@@ -0,0 +1,150 @@
+package asm
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func testIPv4Packet(protocol uint8, payload []byte) []byte {
+	packet := make([]byte, 20+len(payload))
+	packet[0] = 0x45
+	binary.BigEndian.PutUint16(packet[2:4], uint16(len(packet)))
+	packet[8] = 64
+	packet[9] = protocol
+	copy(packet[12:16], []byte{192, 168, 1, 10})
+	copy(packet[16:20], []byte{10, 0, 0, 1})
+	copy(packet[20:], payload)
+	return packet
+}
+
+func testIPv6Packet(protocol uint8, payload []byte) []byte {
+	packet := make([]byte, 40+len(payload))
+	packet[0] = 0x60
+	binary.BigEndian.PutUint16(packet[4:6], uint16(len(payload)))
+	packet[6] = protocol
+	packet[7] = 64
+	copy(packet[8:24], []byte{0x20, 0x01, 0x0d, 0xb8, 15: 1})
+	copy(packet[24:40], []byte{0xfe, 0x80, 8: 0xde, 0xad, 0xbe, 0xef, 15: 2})
+	copy(packet[40:], payload)
+	return packet
+}
+
+func TestPseudoHeader(t *testing.T) {
+	payload := make([]byte, 33)
+	rand.Read(payload)
+
+	t.Run("ipv4", func(t *testing.T) {
+		src, dst := [4]byte{192, 168, 1, 10}, [4]byte{10, 0, 0, 1}
+		explicit := append(append(append(src[:], dst[:]...), 0, ProtocolTCP, 0, byte(len(payload))), payload...)
+		expect := rfc1071(explicit)
+		if actual := TCPChecksum(PseudoHeaderIPv4(src, dst, ProtocolTCP, uint16(len(payload))), payload); actual != expect {
+			t.Errorf("Expected %v, but got %v", expect, actual)
+		}
+	})
+
+	t.Run("ipv6", func(t *testing.T) {
+		src, dst := [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}, [16]byte{0xfe, 0x80, 15: 2}
+		explicit := append(append(src[:], dst[:]...), 0, 0, 0, byte(len(payload)), 0, 0, 0, ProtocolUDP)
+		explicit = append(explicit, payload...)
+		expect := rfc1071(explicit)
+		if actual := UDPChecksum(PseudoHeaderIPv6(src, dst, ProtocolUDP, uint32(len(payload))), payload); actual != expect {
+			t.Errorf("Expected %v, but got %v", expect, actual)
+		}
+	})
+}
+
+func TestVerifyTCP(t *testing.T) {
+	for _, build := range []func(uint8, []byte) []byte{testIPv4Packet, testIPv6Packet} {
+		segment := make([]byte, 20+rand.Intn(1400))
+		rand.Read(segment)
+		binary.BigEndian.PutUint16(segment[16:18], 0)
+		packet := build(ProtocolTCP, segment)
+
+		pseudo, payload, err := transportPayload(packet, ProtocolTCP)
+		if err != nil {
+			t.Fatalf("failed to parse packet: %s", err)
+		}
+		binary.BigEndian.PutUint16(payload[16:18], TCPChecksum(pseudo, payload))
+
+		if ok, err := VerifyTCP(packet); !ok || err != nil {
+			t.Errorf("Expected valid checksum, but got %v (%v)", ok, err)
+		}
+		packet[len(packet)-1]++
+		if ok, err := VerifyTCP(packet); ok || err != nil {
+			t.Errorf("Expected invalid checksum, but got %v (%v)", ok, err)
+		}
+		if _, err := VerifyUDP(packet); !errors.Is(err, ErrProtocol) {
+			t.Errorf("Expected ErrProtocol, but got %v", err)
+		}
+	}
+}
+
+func TestVerifyUDP(t *testing.T) {
+	// all zero payload against a pseudo-header that sums to 0xFFFF must be sent as 0xFFFF
+	t.Run("zero result", func(t *testing.T) {
+		datagram := make([]byte, 8)
+		if actual := UDPChecksum(0xFFFF, datagram); actual != 0xFFFF {
+			t.Errorf("Expected 0xFFFF, but got %#x", actual)
+		}
+	})
+
+	t.Run("no checksum", func(t *testing.T) {
+		datagram := []byte{0x30, 0x39, 0x00, 0x35, 0x00, 0x0c, 0x00, 0x00, 'y', 'e', 'e', 't'}
+		if ok, err := VerifyUDP(testIPv4Packet(ProtocolUDP, datagram)); !ok || err != nil {
+			t.Errorf("Expected zero checksum to be valid over IPv4, but got %v (%v)", ok, err)
+		}
+		if ok, err := VerifyUDP(testIPv6Packet(ProtocolUDP, datagram)); ok || err != nil {
+			t.Errorf("Expected zero checksum to be invalid over IPv6, but got %v (%v)", ok, err)
+		}
+	})
+
+	for _, build := range []func(uint8, []byte) []byte{testIPv4Packet, testIPv6Packet} {
+		datagram := make([]byte, 8+rand.Intn(1400))
+		rand.Read(datagram)
+		binary.BigEndian.PutUint16(datagram[6:8], 0)
+		packet := build(ProtocolUDP, datagram)
+
+		pseudo, payload, err := transportPayload(packet, ProtocolUDP)
+		if err != nil {
+			t.Fatalf("failed to parse packet: %s", err)
+		}
+		binary.BigEndian.PutUint16(payload[6:8], UDPChecksum(pseudo, payload))
+
+		if ok, err := VerifyUDP(packet); !ok || err != nil {
+			t.Errorf("Expected valid checksum, but got %v (%v)", ok, err)
+		}
+		packet[len(packet)-2] ^= 0x80
+		if ok, err := VerifyUDP(packet); ok || err != nil {
+			t.Errorf("Expected invalid checksum, but got %v (%v)", ok, err)
+		}
+	}
+}
+
+func TestVerifyErrors(t *testing.T) {
+	fragment := testIPv4Packet(ProtocolTCP, make([]byte, 20))
+	fragment[6] = 0x20 // more fragments
+
+	options := testIPv6Packet(0, append([]byte{ProtocolTCP, 0, 7: 0}, make([]byte, 20)...))
+
+	for _, tc := range []struct {
+		name   string
+		packet []byte
+		err    error
+	}{
+		{"empty", nil, ErrTruncated},
+		{"bad version", []byte{0x55, 0, 0, 0}, ErrIPVersion},
+		{"short ipv4", testIPv4Packet(ProtocolTCP, nil)[:12], ErrTruncated},
+		{"short segment", testIPv4Packet(ProtocolTCP, make([]byte, 8)), ErrTruncated},
+		{"fragment", fragment, ErrFragmented},
+		{"routing header", testIPv6Packet(43, make([]byte, 28)), ErrUnsupported},
+		{"hop-by-hop", options, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := VerifyTCP(tc.packet); !errors.Is(err, tc.err) {
+				t.Errorf("Expected %v, but got %v", tc.err, err)
+			}
+		})
+	}
+}
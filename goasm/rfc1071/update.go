@@ -0,0 +1,35 @@
+package asm
+
+// RFC 1624 incremental updates, for when a few fields of an already checksummed header
+// change (NAT, TTL decrements, ...) and re-reading the whole packet would be wasteful.
+//
+// All of them implement equation 3 of the RFC:
+//
+//	HC' = ~(~HC + ~m + m')
+//
+// which, unlike the RFC 1141 shortcut (HC' = HC + m + ~m'), agrees with a full recomputation.
+
+// Update16 returns the updated checksum after a 16 bit field changed from oldField to newField.
+func Update16(old uint16, oldField, newField uint16) uint16 {
+	return Finalize(uint32(^old) + uint32(^oldField) + uint32(newField))
+}
+
+// Update32 returns the updated checksum after a 32 bit field (an IPv4 address for example)
+// changed from oldField to newField.
+func Update32(old uint16, oldField, newField uint32) uint16 {
+	sum := uint32(^old)
+	sum += uint32(^uint16(oldField>>16)) + uint32(^uint16(oldField))
+	sum += uint32(uint16(newField>>16)) + uint32(uint16(newField))
+	return Finalize(sum)
+}
+
+// UpdateBytes returns the updated checksum after oldBytes were replaced with newBytes.
+// Both have to be the same length and start at an even offset of the checksummed data,
+// UpdateBytes panics if the lengths differ.
+func UpdateBytes(sum uint16, oldBytes, newBytes []byte) uint16 {
+	if len(oldBytes) != len(newBytes) {
+		panic("asm: UpdateBytes called with slices of different lengths")
+	}
+	// the ones' complement of the folded sum of m is the sum of the complement of each word of m
+	return Finalize(uint32(^sum) + uint32(^Fold(partialSum(oldBytes, 0))) + uint32(Fold(partialSum(newBytes, 0))))
+}
@@ -0,0 +1,165 @@
+// Package virtio parses and serializes the virtio_net_hdr that virtio-net devices, vhost
+// and tun/tap devices opened with IFF_VNET_HDR put in front of every packet, and emulates
+// the checksum offload it describes.
+//
+// A sender that leaves the checksum to the device (VIRTIO_NET_HDR_F_NEEDS_CSUM) seeds the
+// checksum field with the folded pseudo-header sum and says where the checksummed data
+// starts (CsumStart) and where the field is relative to that (CsumOffset). Finishing it is
+// one partial sum from CsumStart to the end of the packet, see CompleteOffload.
+//
+// Fields are little endian, as in virtio 1.0 and on tun devices with TUNSETVNETLE (or
+// legacy devices on little endian hosts).
+package virtio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"asm"
+)
+
+// Header lengths: without and with the NumBuffers field, which is only there with
+// mergeable receive buffers (VIRTIO_NET_F_MRG_RXBUF) or virtio 1.0.
+const (
+	HeaderLen         = 10
+	HeaderLenMrgRxbuf = 12
+)
+
+// Flags.
+const (
+	FlagNeedsCsum uint8 = 1 << 0 // VIRTIO_NET_HDR_F_NEEDS_CSUM: checksum from CsumStart still to be done
+	FlagDataValid uint8 = 1 << 1 // VIRTIO_NET_HDR_F_DATA_VALID: checksum already verified, receive only
+	FlagRSCInfo   uint8 = 1 << 2 // VIRTIO_NET_HDR_F_RSC_INFO: coalesced segment counts in CsumStart and CsumOffset
+)
+
+// GSO types.
+const (
+	GSONone  uint8 = 0
+	GSOTCPv4 uint8 = 1
+	GSOUDP   uint8 = 3
+	GSOTCPv6 uint8 = 4
+	GSOUDPL4 uint8 = 5
+	GSOECN   uint8 = 0x80 // or'ed into the others
+)
+
+var (
+	ErrTruncated = errors.New("virtio: header is truncated")
+	ErrLen       = errors.New("virtio: header length has to be 10 or 12")
+	ErrOffload   = errors.New("virtio: checksum offload outside the packet")
+)
+
+// Header is a virtio_net_hdr.
+type Header struct {
+	Flags      uint8
+	GSOType    uint8
+	HdrLen     uint16 // length of the headers to copy into each segment
+	GSOSize    uint16 // segment payload size
+	CsumStart  uint16 // where the checksummed data starts, from the start of the packet
+	CsumOffset uint16 // where the checksum field is, from CsumStart
+	NumBuffers uint16 // only serialized in HeaderLenMrgRxbuf headers
+}
+
+// Unmarshal parses the n byte header at the start of b into h, n being HeaderLen or HeaderLenMrgRxbuf.
+// NumBuffers is zeroed for a HeaderLen header.
+func (h *Header) Unmarshal(b []byte, n int) error {
+	if n != HeaderLen && n != HeaderLenMrgRxbuf {
+		return ErrLen
+	}
+	if len(b) < n {
+		return ErrTruncated
+	}
+	*h = Header{
+		Flags:      b[0],
+		GSOType:    b[1],
+		HdrLen:     binary.LittleEndian.Uint16(b[2:4]),
+		GSOSize:    binary.LittleEndian.Uint16(b[4:6]),
+		CsumStart:  binary.LittleEndian.Uint16(b[6:8]),
+		CsumOffset: binary.LittleEndian.Uint16(b[8:10]),
+	}
+	if n == HeaderLenMrgRxbuf {
+		h.NumBuffers = binary.LittleEndian.Uint16(b[10:12])
+	}
+	return nil
+}
+
+// Marshal serializes h into an n byte header, see Unmarshal.
+func (h *Header) Marshal(n int) ([]byte, error) {
+	return h.AppendTo(nil, n)
+}
+
+// AppendTo appends the n byte serialized header to b, see Marshal.
+func (h *Header) AppendTo(b []byte, n int) ([]byte, error) {
+	if n != HeaderLen && n != HeaderLenMrgRxbuf {
+		return b, ErrLen
+	}
+	b = append(b, h.Flags, h.GSOType)
+	b = binary.LittleEndian.AppendUint16(b, h.HdrLen)
+	b = binary.LittleEndian.AppendUint16(b, h.GSOSize)
+	b = binary.LittleEndian.AppendUint16(b, h.CsumStart)
+	b = binary.LittleEndian.AppendUint16(b, h.CsumOffset)
+	if n == HeaderLenMrgRxbuf {
+		b = binary.LittleEndian.AppendUint16(b, h.NumBuffers)
+	}
+	return b, nil
+}
+
+// Complete finishes the checksum of pkt, the packet that followed h, if h asks for it,
+// and clears FlagNeedsCsum and the offsets so that h describes the packet as it is now.
+func (h *Header) Complete(pkt []byte) error {
+	if h.Flags&FlagNeedsCsum == 0 {
+		return nil
+	}
+	if err := CompleteOffload(pkt, int(h.CsumStart), int(h.CsumOffset)); err != nil {
+		return err
+	}
+	h.Flags &^= FlagNeedsCsum
+	h.CsumStart, h.CsumOffset = 0, 0
+	return nil
+}
+
+// Offload prepares pkt for checksum offload with PrepareOffload and sets the flag and offsets of h to match.
+func (h *Header) Offload(pkt []byte, start, offset int, pseudo uint32) error {
+	if err := PrepareOffload(pkt, start, offset, pseudo); err != nil {
+		return err
+	}
+	h.Flags |= FlagNeedsCsum
+	h.CsumStart, h.CsumOffset = uint16(start), uint16(offset)
+	return nil
+}
+
+// CompleteOffload does what a device does for a packet with VIRTIO_NET_HDR_F_NEEDS_CSUM:
+// sum pkt from start to the end, the seeded checksum field at start+offset included, and
+// store the complemented result in that field. Like linux's skb_checksum_help, a result of
+// zero is stored as 0xFFFF so that UDP doesn't read it as "no checksum".
+func CompleteOffload(pkt []byte, start, offset int) error {
+	if err := checkOffload(pkt, start, offset); err != nil {
+		return err
+	}
+	sum := asm.Finalize(asm.PartialSum(pkt[start:], 0))
+	if sum == 0 {
+		sum = 0xFFFF
+	}
+	binary.BigEndian.PutUint16(pkt[start+offset:], sum)
+	return nil
+}
+
+// PrepareOffload is the sending side of CompleteOffload: it seeds the checksum field at
+// start+offset with the folded, uncomplemented pseudo-header sum (asm.PseudoHeaderIPv4 or
+// asm.PseudoHeaderIPv6), leaving the rest of the checksum to the device.
+func PrepareOffload(pkt []byte, start, offset int, pseudo uint32) error {
+	if err := checkOffload(pkt, start, offset); err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint16(pkt[start+offset:], asm.Fold(pseudo))
+	return nil
+}
+
+// checkOffload makes sure the checksum field at start+offset is inside pkt, and that both
+// fit the 16 bit header fields.
+func checkOffload(pkt []byte, start, offset int) error {
+	if start < 0 || offset < 0 || start > 0xFFFF || offset > 0xFFFF || len(pkt)-start-2 < offset {
+		return fmt.Errorf("%w: start %d, offset %d, %d bytes", ErrOffload, start, offset, len(pkt))
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package virtio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"asm"
+)
+
+func TestHeader(t *testing.T) {
+	h := Header{
+		Flags:      FlagNeedsCsum,
+		GSOType:    GSOTCPv4 | GSOECN,
+		HdrLen:     66,
+		GSOSize:    1448,
+		CsumStart:  34,
+		CsumOffset: 16,
+		NumBuffers: 3,
+	}
+	wire := []byte{0x01, 0x81, 0x42, 0x00, 0xa8, 0x05, 0x22, 0x00, 0x10, 0x00, 0x03, 0x00}
+
+	for _, n := range []int{HeaderLen, HeaderLenMrgRxbuf} {
+		b, err := h.Marshal(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, wire[:n]) {
+			t.Errorf("Expected % x, but got % x", wire[:n], b)
+		}
+
+		var parsed Header
+		if err := parsed.Unmarshal(wire, n); err != nil {
+			t.Fatal(err)
+		}
+		expect := h
+		if n == HeaderLen {
+			expect.NumBuffers = 0
+		}
+		if parsed != expect {
+			t.Errorf("Expected %+v, but got %+v", expect, parsed)
+		}
+
+		if err := parsed.Unmarshal(wire[:n-1], n); !errors.Is(err, ErrTruncated) {
+			t.Errorf("Expected ErrTruncated, but got %v", err)
+		}
+	}
+
+	if _, err := h.Marshal(11); !errors.Is(err, ErrLen) {
+		t.Errorf("Expected ErrLen, but got %v", err)
+	}
+	if err := h.Unmarshal(wire, 0); !errors.Is(err, ErrLen) {
+		t.Errorf("Expected ErrLen, but got %v", err)
+	}
+}
+
+// ipv4Packet returns an ethernet frame with an IPv4 header (no checksum, the device
+// doesn't care) and a TCP or UDP payload of n bytes, and the pseudo-header sum.
+func ipv4Packet(rng *rand.Rand, proto uint8, n int) ([]byte, uint32) {
+	pkt := make([]byte, 14+20+n)
+	rng.Read(pkt)
+	ip := pkt[14:]
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:4], uint16(20+n))
+	ip[9] = proto
+	return pkt, asm.PseudoHeaderIPv4([4]byte(ip[12:16]), [4]byte(ip[16:20]), proto, uint16(n))
+}
+
+func TestOffload(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, tc := range []struct {
+		name   string
+		proto  uint8
+		offset int
+		hdr    int
+	}{
+		{"tcp", asm.ProtocolTCP, 16, 20},
+		{"udp", asm.ProtocolUDP, 6, 8},
+	} {
+		for n := tc.hdr; n < tc.hdr+300; n += 7 {
+			pkt, pseudo := ipv4Packet(rng, tc.proto, n)
+			payload := pkt[34:]
+
+			// what the sender would have computed itself
+			payload[tc.offset], payload[tc.offset+1] = 0, 0
+			var expect uint16
+			if tc.proto == asm.ProtocolTCP {
+				expect = asm.TCPChecksum(pseudo, payload)
+			} else {
+				expect = asm.UDPChecksum(pseudo, payload)
+			}
+
+			var h Header
+			if err := h.Offload(pkt, 34, tc.offset, pseudo); err != nil {
+				t.Fatal(err)
+			}
+			if got := binary.BigEndian.Uint16(payload[tc.offset:]); got != asm.Fold(pseudo) {
+				t.Fatalf("%s %d: expected the field seeded with %#04x, but got %#04x", tc.name, n, asm.Fold(pseudo), got)
+			}
+			if h.Flags != FlagNeedsCsum || h.CsumStart != 34 || int(h.CsumOffset) != tc.offset {
+				t.Fatalf("%s %d: unexpected header %+v", tc.name, n, h)
+			}
+
+			// through the wire and back
+			b, _ := h.Marshal(HeaderLen)
+			var rx Header
+			if err := rx.Unmarshal(b, HeaderLen); err != nil {
+				t.Fatal(err)
+			}
+			if err := rx.Complete(pkt); err != nil {
+				t.Fatal(err)
+			}
+			if got := binary.BigEndian.Uint16(payload[tc.offset:]); got != expect {
+				t.Errorf("%s %d: expected checksum %#04x, but got %#04x", tc.name, n, expect, got)
+			}
+			if rx != (Header{}) {
+				t.Errorf("%s %d: expected a cleared header, but got %+v", tc.name, n, rx)
+			}
+			// nothing left to do
+			if err := rx.Complete(pkt); err != nil || binary.BigEndian.Uint16(payload[tc.offset:]) != expect {
+				t.Errorf("%s %d: completed twice", tc.name, n)
+			}
+		}
+	}
+}
+
+func TestCompleteOffloadOddStart(t *testing.T) {
+	// the checksummed data doesn't have to start at an even offset of the buffer
+	rng := rand.New(rand.NewSource(2))
+	data := make([]byte, 101)
+	rng.Read(data)
+	data[4], data[5] = 0, 0
+	expect := asm.Finalize(asm.PartialSum(data, 0))
+
+	pkt := append([]byte{0xff}, data...)
+	if err := CompleteOffload(pkt, 1, 4); err != nil {
+		t.Fatal(err)
+	}
+	if got := binary.BigEndian.Uint16(pkt[5:]); got != expect {
+		t.Errorf("Expected %#04x, but got %#04x", expect, got)
+	}
+	if asm.Finalize(asm.PartialSum(pkt[1:], 0)) != 0 {
+		t.Error("Completed data doesn't verify")
+	}
+}
+
+func TestCompleteOffloadZero(t *testing.T) {
+	pkt := []byte{0x12, 0x34, 0x00, 0x00, 0x00, 0x00}
+	// make everything sum to 0xFFFF, a checksum of zero
+	binary.BigEndian.PutUint16(pkt[4:], ^asm.Fold(asm.PartialSum(pkt, 0)))
+	if err := CompleteOffload(pkt, 0, 2); err != nil {
+		t.Fatal(err)
+	}
+	if got := binary.BigEndian.Uint16(pkt[2:]); got != 0xFFFF {
+		t.Errorf("Expected a zero checksum to be stored as 0xffff, but got %#04x", got)
+	}
+}
+
+func TestOffloadBounds(t *testing.T) {
+	pkt := make([]byte, 40)
+	for _, tc := range [][2]int{{-1, 0}, {0, -1}, {34, 6}, {39, 0}, {40, 0}, {0, 1 << 16}, {1 << 16, 0}} {
+		if err := CompleteOffload(pkt, tc[0], tc[1]); !errors.Is(err, ErrOffload) {
+			t.Errorf("start %d offset %d: expected ErrOffload, but got %v", tc[0], tc[1], err)
+		}
+		if err := PrepareOffload(pkt, tc[0], tc[1], 0); !errors.Is(err, ErrOffload) {
+			t.Errorf("start %d offset %d: expected ErrOffload, but got %v", tc[0], tc[1], err)
+		}
+	}
+	if err := CompleteOffload(pkt, 34, 4); err != nil {
+		t.Errorf("Expected the last two bytes to be a valid field, but got %v", err)
+	}
+}
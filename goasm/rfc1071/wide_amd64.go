@@ -0,0 +1,16 @@
+//go:build amd64 && !purego
+
+package asm
+
+import "golang.org/x/sys/cpu"
+
+// checksumWide is the fastest kernel for a whole buffer: checksumADX and its two carry
+// chains where the CPU has ADX, the single ADCQ chain of checksumADC everywhere else.
+// Neither returns an unfolded sum, so partialSum can't hand its work to them.
+var checksumWide = checksumADC
+
+func init() {
+	if cpu.X86.HasADX {
+		checksumWide = checksumADX
+	}
+}
@@ -3,12 +3,10 @@
 package main
 
 import (
-	"fmt"
-
-	"github.com/mmcloughlin/avo/build"
-	"github.com/mmcloughlin/avo/gotypes"
 	"github.com/mmcloughlin/avo/operand"
 	"github.com/mmcloughlin/avo/reg"
+
+	"asm/gen"
 )
 
 /*
@@ -35,233 +33,645 @@ import (
      +--------+ +---------+ +-------+ +--------+ +-------+ +--------+
 */
 
-type label string
-
 const (
-	loop       label = "loop"
-	remainder  label = "remainder"
-	rLoop      label = "remainder_loop"
-	fin        label = "done"
-	early_fail label = "early_fail"
+	loop       gen.Label = "loop"
+	foldBlock  gen.Label = "fold_block"
+	remainder  gen.Label = "remainder"
+	rLoop      gen.Label = "remainder_loop"
+	rOdd       gen.Label = "remainder_odd"
+	fin        gen.Label = "done"
+	early_fail gen.Label = "early_fail"
+
+	zmmLoop      gen.Label = "zmm_loop"
+	zmmFoldBlock gen.Label = "zmm_fold_block"
+	zmmTail      gen.Label = "zmm_tail"
+
+	sseLoop      gen.Label = "sse_loop"
+	sseFoldBlock gen.Label = "sse_fold_block"
+	sseRemainder gen.Label = "sse_remainder"
+	sseRLoop     gen.Label = "sse_remainder_loop"
+	sseROdd      gen.Label = "sse_remainder_odd"
+
+	scalarLoop gen.Label = "scalar_loop"
+	scalarOdd  gen.Label = "scalar_odd"
+	scalarFold gen.Label = "scalar_fold"
+
+	copyLoop      gen.Label = "copy_loop"
+	copyFoldBlock gen.Label = "copy_fold_block"
+	copyReduce    gen.Label = "copy_reduce"
+	copyQuads     gen.Label = "copy_quads"
+	copyWords     gen.Label = "copy_words"
+	copyOdd       gen.Label = "copy_odd"
 )
 
-func gp64() reg.Register {
-	return build.GP64()
-}
+// scalar is the rfc1071 byte pair kernel, kept around for CPUs without AVX2.
+func scalar(file *gen.File) {
+	f := file.Function("checksumScalar", "func(data []byte) uint16", "", 0)
+	input := f.LoadSlice("data")
+	data, length := input.Data, input.Len
 
-func gp32() reg.Register {
-	return build.GP32()
-}
+	f.JumpIfZero(length, early_fail)
 
-func gp8() reg.Register {
-	return build.GP8()
-}
+	sum := f.GP64()
+	f.Zero(sum)
+	word := f.GP64()
 
-func store(src reg.Register, dst gotypes.Component) {
-	build.Store(src, dst)
-}
+	f.Label(scalarLoop)
 
-func define(funcName string, argName string, argType string, ret string) {
-	build.TEXT(funcName, 0, fmt.Sprintf("func(%s %s) %s", argName, argType, ret))
-	build.ConstraintExpr("amd64")
-}
+	// if length < 2: goto scalar_odd
+	f.JumpIfBelow(length, 2, scalarOdd)
 
-func zero(reg reg.Register) {
-	switch reg.Size() * 8 {
-	case 8:
-		build.XORQ(reg, reg)
-	case 16:
-		build.XORW(reg, reg)
-	case 32:
-		build.XORL(reg, reg)
-	case 64:
-		build.XORQ(reg, reg)
-	case 128, 256:
-		// AVX2: vector register zeroing
-		build.VXORPS(reg, reg, reg)
-	}
-}
+	// load a 16 bit word and swap it into network byte order
+	f.MOVWQZX(data, word)
+	f.ROLW(operand.Imm(8), word.As16())
+	f.ADDQ(word, sum)
 
-func lbl(name any) {
-	switch name.(type) {
-	case label:
-		build.Label(string(name.(label)))
-	case string:
-		build.Label(name.(string))
-	default:
-		panic("r u ok")
-	}
-}
+	// data += 2, length -= 2
+	f.Advance(input, 2)
+	f.Jump(scalarLoop)
 
-func to(name label) operand.LabelRef {
-	return operand.LabelRef(name)
-}
+	f.Label(scalarOdd)
 
-func jae(a, b reg.Register, label operand.LabelRef) {
-	build.CMPQ(a, b)
-	build.JAE(label)
-}
+	// the odd byte is the high byte of a zero padded word
+	f.JumpIfZero(length, scalarFold)
+	f.MOVBQZX(data, word)
+	f.SHLQ(operand.Imm(8), word)
+	f.ADDQ(word, sum)
 
-func testqjz(reg reg.Register, label operand.LabelRef) {
-	build.TESTQ(reg, reg)
-	build.JZ(label)
-}
+	f.Label(scalarFold)
 
-func jump(label operand.LabelRef) {
-	build.JMP(label)
-}
+	f.Fold16(sum, word)
+	f.NOTW(sum.As16())
+	f.Return16(sum)
 
-func constant(value uint64) operand.Constant {
-	return operand.Imm(value)
+	f.Label(early_fail)
+	f.ReturnZero16(sum)
 }
 
-func main() {
-	define("checksum", "data", "[]byte", "uint16")
-	input := build.Param("data")
-	data := operand.Mem{Base: build.Load(input.Base(), gp64())}
-	length := build.Load(input.Len(), gp64())
+// avx2Block is how many 32 byte iterations the AVX2 loop runs before folding its lanes.
+// every iteration adds at most 0xFFFF to each 32-bit lane, so after 0x4000 of them a lane
+// holds less than 2^31 and the two accumulators can still be added without overflowing.
+const avx2Block = 0x4000
+
+// sse2 is the avx2 kernel squeezed into 128-bit registers, every amd64 CPU has SSE2
+// so this is the vector path for GOAMD64=v1 machines. same little endian trick as avx2.
+func sse2(file *gen.File) {
+	f := file.Function("checksumSSE2", "func(data []byte) uint16", "", 0)
+	input := f.LoadSlice("data")
+	data, length := input.Data, input.Len
 
-	build.TESTQ(length, length)
-	build.JZ(to(fin))
+	f.JumpIfZero(length, early_fail)
 
 	// ===================================================
 	/*              REGISTER INITIALIZATION:            */
 	// ===================================================
 
-	// 64-bit register for sum
-	sum := build.GP64()
-	zero(sum)
+	// 64-bit register for the scalar sum of the tail
+	sum := f.GP64()
+	f.Zero(sum)
 
-	// 64-bit register for index
-	index := gp64()
-	zero(index)
+	// iterations left until we have to fold the vector lanes, same math as avx2Block
+	block := f.GP64()
+	f.MOVQ(operand.U32(avx2Block), block)
 
-	// 256-bit vector register for sum
-	vectorSum := build.YMM()
-	zero(vectorSum)
+	// two 128-bit accumulators of four 32-bit lanes each
+	acc0, acc1 := f.XMM(), f.XMM()
+	f.PXOR(acc0, acc0)
+	f.PXOR(acc1, acc1)
 
-	// 256-bit vector register for data
-	vectorData := build.YMM()
-	zero(vectorData)
+	// there's no PMOVZXWD before SSE4.1, so we widen by interleaving with zeros
+	zeros := f.XMM()
+	f.PXOR(zeros, zeros)
 
-	// ---------------------------------------------------
+	// 0x0000FFFF in every lane: all ones, shifted right 16 bits
+	mask := f.XMM()
+	f.PCMPEQL(mask, mask)
+	f.PSRLL(operand.Imm(16), mask)
+
+	low, high := f.XMM(), f.XMM()
 
 	// ===================================================
 	/*                      MAIN LOOP:                  */
-	lbl("loop") // =======================================
+	f.Label(sseLoop) // ======================================
 
-	// if index >= length: goto remainder
-	jae(index, length, to(remainder))
+	// if length < 16: goto sse_remainder
+	f.JumpIfBelow(length, 16, sseRemainder)
 
 	/*
-		(V)ector (MOV) (D)ouble (Q)uadword (U)naligned
-		   move 128 bits to 256-bit vector register
+		(MOV)e (O)ctaword (U)naligned: 16 bytes of data, then
+		(P)acked (UNP)ack (L)ow/(H)igh (W)ords to (L)ongs (PUNPCKLWD/PUNPCKHWD in intel speak)
+		   interleave the low and high four words with zero words,
+		   giving us two registers of four zero extended 32-bit lanes
 	*/
-	build.VMOVDQU(vectorData, data.Idx(index, 1))
+	f.MOVOU(data, low)
+	f.MOVO(low, high)
+	f.PUNPCKLWL(zeros, low)
+	f.PUNPCKHWL(zeros, high)
 
-	/*
-		(V)ector (P)acked (ADD) 16-bit (W)ord integers
-				   add the
-		 - low 128-bits of 'vectorData'
-				   and the
-		 - high 128-bits of 'vectorSum'
-		          into the
-		 - lower half (128) of 'vectorSum'
-	*/
-	build.VPADDW(vectorSum, vectorSum, vectorData)
+	// (P)acked (ADD) (L)ongs, PADDD in intel speak
+	f.PADDL(low, acc0)
+	f.PADDL(high, acc1)
+
+	// data += 16 bytes, length -= 16 bytes
+	f.Advance(input, 16)
+
+	f.DECQ(block)
+	f.JNZ(sseLoop.Ref())
 
-	// index += 32 bytes
-	build.ADDQ(operand.U32(32), index)
+	f.Label(sseFoldBlock) /* ---------- LANE FOLDING ---------- */
 
-	// goto loop label to continue accumulating data
-	jump(to(loop))
+	f.FoldLanesSSE(acc0, mask, low)
+	f.FoldLanesSSE(acc1, mask, high)
+	f.MOVQ(operand.U32(avx2Block), block)
+	f.Jump(sseLoop)
 
 	// ===================================================
 	/*            REMAINDER && REMAINDER LOOP:          */
-	lbl(remainder) // ====================================
+	f.Label(sseRemainder) // =================================
+
+	f.PADDL(acc1, acc0)
+	f.FoldLanesSSE(acc0, mask, low)
+
+	// 128 --> 64: swap the quadwords and add
+	f.PSHUFD(operand.Imm(0x4E), acc0, low)
+	f.PADDL(low, acc0)
 
-	remaining := gp64()
+	// both 32-bit halves go into the scalar sum at once, fold16 takes care of adding them together
+	word := f.GP64()
+	f.MOVQ(acc0, word)
+	f.ADDQ(word, sum)
 
-	// calculate remaining bytes by
-	// subtracting the index from the length
-	build.MOVQ(remaining, length)
-	build.SUBQ(remaining, index)
+	f.Label(sseRLoop) /* --------- REMAINDER LOOP --------- */
 
-	lbl(rLoop) /* ----------- REMAINDER LOOP ----------- */
+	// if length < 2: goto sse_remainder_odd
+	f.JumpIfBelow(length, 2, sseROdd)
 
-	// if remaining == 0: goto done
-	testqjz(remaining, to(fin))
+	// little endian, like the vector lanes
+	f.MOVWQZX(data, word)
+	f.ADDQ(word, sum)
+	f.Advance(input, 2)
+	f.Jump(sseRLoop)
 
-	// load odd byte into 8-bit register
-	byteData := gp64()
-	build.MOVB(byteData.(reg.GPVirtual).As8(), data.Idx(index, 1))
-	// add byte to sum
-	build.ADDQ(byteData, sum)
-	// index++
-	build.INCQ(index)
-	// remaining--
-	build.DECQ(remaining)
-	// goto remainder loop
-	jump(to(rLoop))
+	f.Label(sseROdd)
+
+	// the odd byte is the low byte of a zero padded little endian word
+	f.JumpIfZero(length, fin)
+	f.MOVBQZX(data, word)
+	f.ADDQ(word, sum)
 
 	// ===================================================
 	/*                 FINAL WRAP-AROUND:               */
-	lbl(fin) // ==========================================
+	f.Label(fin) // ==========================================
+
+	f.Fold16(sum, word)
+	f.SwapComplement16(sum)
+	f.Return16(sum)
+
+	// ===================================================
+	/*                   EARLY FAIL:                    */
+	f.Label(early_fail) // ===================================
+	f.ReturnZero16(sum)
+}
+
+// avx2 leans on the byte order independence of RFC 1071 (section 2.B):
+// summing little endian words and swapping the bytes of the folded result
+// is the same as summing big endian words. so we sum everything little endian,
+// vector lanes, tail words and the odd byte alike, and swap once at the very end.
+func avx2(file *gen.File) {
+	f := file.Function("checksumAVX2", "func(data []byte) uint16", "", 0)
+	input := f.LoadSlice("data")
+	data, length := input.Data, input.Len
+
+	f.JumpIfZero(length, early_fail)
+
+	// ===================================================
+	/*              REGISTER INITIALIZATION:            */
+	// ===================================================
+
+	// 64-bit register for the scalar sum of the tail
+	sum := f.GP64()
+	f.Zero(sum)
+
+	// iterations left until we have to fold the vector lanes
+	block := f.GP64()
+	f.MOVQ(operand.U32(avx2Block), block)
+
+	// two 256-bit accumulators of eight 32-bit lanes each,
+	// so that consecutive VPADDDs don't wait on each other
+	acc0, acc1 := f.YMM(), f.YMM()
+	f.Zero(acc0)
+	f.Zero(acc1)
+
+	// 0x0000FFFF in every lane: all ones, shifted right 16 bits
+	mask := f.YMM()
+	f.VPCMPEQD(mask, mask, mask)
+	f.VPSRLD(operand.Imm(16), mask, mask)
+
+	// 256-bit vector registers for the widened data
+	vectorData0, vectorData1 := f.YMM(), f.YMM()
+
+	// ---------------------------------------------------
+
+	// ===================================================
+	/*                      MAIN LOOP:                  */
+	f.Label(loop) // =========================================
+
+	// if length < 32: goto remainder
+	f.JumpIfBelow(length, 32, remainder)
+
+	/*
+		(V)ector (P)acked (MOV)e with (Z)ero e(X)tend (W)ord to (D)oubleword
+		   load eight 16-bit words and widen them into eight 32-bit lanes,
+		   the upper half of each lane catches the carries VPADDW used to drop
+	*/
+	f.VPMOVZXWD(data.Offset(0), vectorData0)
+	f.VPMOVZXWD(data.Offset(16), vectorData1)
+
+	// (V)ector (P)acked (ADD) (D)oubleword integers
+	f.VPADDD(vectorData0, acc0, acc0)
+	f.VPADDD(vectorData1, acc1, acc1)
+
+	// data += 32 bytes, length -= 32 bytes
+	f.Advance(input, 32)
+
+	// keep looping until the lanes are due for a fold
+	f.DECQ(block)
+	f.JNZ(loop.Ref())
+
+	f.Label(foldBlock) /* ------------ LANE FOLDING ------------ */
+
+	f.FoldLanes(acc0, mask, vectorData0)
+	f.FoldLanes(acc1, mask, vectorData1)
+	f.MOVQ(operand.U32(avx2Block), block)
+	f.Jump(loop)
+
+	// ===================================================
+	/*            REMAINDER && REMAINDER LOOP:          */
+	f.Label(remainder) // ====================================
 
 	// -----
-	// Reduce vector sum to a scalar sum (to 16 bits)
+	// Reduce the vector sum to a scalar sum
 	// -----
 
-	// 256 --> 128
-	unpacked128 := build.YMM()
-	// (V)ector (PERM)ute (2) (I)nteger (128) - i gotta say i do not yet understand this shit right here
-	// reference: https://www.officedaytime.com/simd512e/simdimg/si.php?f=vperm2f128 (reaction: lmao what)
-	build.VPERM2I128(constant(0x01), vectorSum.AsY(), vectorSum.AsY(), unpacked128.AsY())
-	// add unpacked to vectorSum, storing result in vectorSum
-	build.VPADDW(vectorSum, vectorSum, unpacked128)
+	// combine the accumulators and fold the result so the horizontal adds can't overflow
+	f.VPADDD(acc1, acc0, acc0)
+	f.FoldLanes(acc0, mask, vectorData0)
 
-	/*build.VEXTRACTI128(constant(0), vectorSum, unpacked128)
-	build.VPADDW(vectorSum.AsY(), vectorSum.AsY(), unpacked128.AsY())
-	*/
-	// 128 --> 64
-	tmp128 := build.XMM()
-	build.VMOVDQU(tmp128.AsX(), vectorSum.AsX())
-
-	tmp64 := gp64()
-	// mov lower 32 bits of tmp128 to tmp64
-	build.MOVD(tmp64, tmp128)
-	build.ADDQ(sum, tmp64)
-	// (P)acked (S)hift (R)ight (L)ogical (D)ouble (Q)uadword
-	build.PSRLDQ(constant(8), tmp128.AsX())
-	// mov lower 32 bits of tmp128 to tmp64
-	build.MOVD(tmp128, tmp64)
-	// add tmp64 to sum
-	build.ADDQ(sum, tmp64)
-
-	// 64 --> 32
-	tmp32 := gp32()
-	build.MOVL(tmp32, sum.As32())
-	build.SHRL(constant(16), tmp32)
-	build.ADDL(sum.As32(), tmp32)
-
-	// 32 --> 16
-	tmp16 := gp32()
-	build.MOVL(tmp16, tmp32)
-	build.SHRL(constant(16), tmp16)
-	build.ADDL(sum.As32(), tmp16)
-	build.ANDQ(operand.U32(0xFFFF), sum)
-
-	store(sum.As16(), build.ReturnIndex(0))
-	build.RET()
+	// 256 --> 128 --> 64 --> 32
+	f.ReduceLanes(acc0, vectorData0)
+
+	// VMOVD zero extends into the full 64-bit register
+	word := f.GP64()
+	f.VMOVD(acc0.AsX(), word.As32())
+	f.ADDQ(word, sum)
+
+	// we're done with the YMM registers, avoid the SSE transition penalty for our caller
+	f.VZEROUPPER()
+
+	f.Label(rLoop) /* ----------- REMAINDER LOOP ----------- */
+
+	// if length < 2: goto remainder_odd
+	f.JumpIfBelow(length, 2, rOdd)
+
+	// little endian, like the vector lanes
+	f.MOVWQZX(data, word)
+	f.ADDQ(word, sum)
+	f.Advance(input, 2)
+	f.Jump(rLoop)
+
+	f.Label(rOdd)
+
+	// the odd byte is the low byte of a zero padded little endian word
+	f.JumpIfZero(length, fin)
+	f.MOVBQZX(data, word)
+	f.ADDQ(word, sum)
+
+	// ===================================================
+	/*                 FINAL WRAP-AROUND:               */
+	f.Label(fin) // ==========================================
+
+	f.Fold16(sum, word)
+	f.SwapComplement16(sum)
+	f.Return16(sum)
+
+	// ===================================================
+	/*                   EARLY FAIL:                    */
+	f.Label(early_fail) // ===================================
+	f.ReturnZero16(sum)
+}
+
+// avx512 is avx2 with twice as wide registers, and instead of a remainder loop the last
+// (up to) 63 bytes are loaded with a byte mask, zeroing everything past the end of data.
+// that zero padding is also exactly what the odd byte needs, little endian or not.
+// needs AVX512F, AVX512BW for byte masking and word widening, and BMI2 for BZHI.
+func avx512(file *gen.File) {
+	f := file.Function("checksumAVX512", "func(data []byte) uint16", "", 0)
+	input := f.LoadSlice("data")
+	data, length := input.Data, input.Len
+
+	f.JumpIfZero(length, early_fail)
+
+	// ===================================================
+	/*              REGISTER INITIALIZATION:            */
+	// ===================================================
+
+	// iterations left until we have to fold the vector lanes, same math as avx2Block
+	block := f.GP64()
+	f.MOVQ(operand.U32(avx2Block), block)
+
+	// two 512-bit accumulators of sixteen 32-bit lanes each
+	acc0, acc1 := f.ZMM(), f.ZMM()
+	f.VPXORD(acc0, acc0, acc0)
+	f.VPXORD(acc1, acc1, acc1)
+
+	// 0x0000FFFF in every lane: VPTERNLOGD with 0xFF sets every bit, then shift
+	mask := f.ZMM()
+	f.VPTERNLOGD(operand.Imm(0xFF), mask, mask, mask)
+	f.VPSRLD(operand.Imm(16), mask, mask)
+
+	vectorData0, vectorData1 := f.ZMM(), f.ZMM()
+
+	// ===================================================
+	/*                      MAIN LOOP:                  */
+	f.Label(zmmLoop) // ======================================
+
+	// if length < 64: goto zmm_tail
+	f.JumpIfBelow(length, 64, zmmTail)
+
+	// sixteen 16-bit words widened into sixteen 32-bit lanes, twice
+	f.VPMOVZXWD(data.Offset(0), vectorData0)
+	f.VPMOVZXWD(data.Offset(32), vectorData1)
+	f.VPADDD(vectorData0, acc0, acc0)
+	f.VPADDD(vectorData1, acc1, acc1)
+
+	// data += 64 bytes, length -= 64 bytes
+	f.Advance(input, 64)
+
+	f.DECQ(block)
+	f.JNZ(zmmLoop.Ref())
+
+	f.Label(zmmFoldBlock) /* ---------- LANE FOLDING ---------- */
+
+	f.FoldLanes(acc0, mask, vectorData0)
+	f.FoldLanes(acc1, mask, vectorData1)
+	f.MOVQ(operand.U32(avx2Block), block)
+	f.Jump(zmmLoop)
+
+	// ===================================================
+	/*                 MASKED TAIL:                     */
+	f.Label(zmmTail) // ======================================
+
+	// k = (1 << length) - 1, one bit per byte we're allowed to touch.
+	// (B)it (Z)ero (H)igh from (I)ndex: clear every bit of all ones from index length up
+	ones := f.GP64()
+	f.MOVQ(operand.I32(-1), ones)
+	f.BZHIQ(length, ones, ones)
+	k := f.K()
+	f.KMOVQ(ones, k)
+
+	// masked off bytes are zeroed and never read, so we can't fault past the end of data
+	f.VMOVDQU8_Z(data, k, vectorData0)
+	f.VEXTRACTI64X4(operand.Imm(1), vectorData0, vectorData1.AsY())
+	f.VPMOVZXWD(vectorData0.AsY(), vectorData0)
+	f.VPMOVZXWD(vectorData1.AsY(), vectorData1)
+	f.VPADDD(vectorData0, acc0, acc0)
+	f.VPADDD(vectorData1, acc1, acc1)
+
+	// ===================================================
+	/*                 FINAL WRAP-AROUND:               */
+	f.Label(fin) // ==========================================
+
+	// fold both accumulators before combining them, the tail may have pushed them past 2^31
+	f.FoldLanes(acc0, mask, vectorData0)
+	f.FoldLanes(acc1, mask, vectorData1)
+	f.VPADDD(acc1, acc0, acc0)
+
+	// 512 --> 256 --> 128 --> 64 --> 32
+	f.ReduceLanes(acc0, vectorData0)
+
+	sum := f.GP64()
+	f.VMOVD(acc0.AsX(), sum.As32())
+	f.VZEROUPPER()
+
+	f.Fold16(sum, ones)
+	f.SwapComplement16(sum)
+	f.Return16(sum)
+
+	// ===================================================
+	/*                   EARLY FAIL:                    */
+	f.Label(early_fail) // ===================================
+	f.ReturnZero16(sum)
+}
+
+// defineCopy declares a func(dst, src []byte) uint16 kernel and loads its pointers along with
+// the number of bytes to copy, which is min(len(dst), len(src)) just like the copy builtin.
+func defineCopy(file *gen.File, funcName string) (f *gen.Func, dst, src operand.Mem, n reg.GPVirtual) {
+	f = file.Function(funcName, "func(dst, src []byte) uint16", "", 0)
+	dst = operand.Mem{Base: f.Load(f.Param("dst").Base(), f.GP64())}
+	src = operand.Mem{Base: f.Load(f.Param("src").Base(), f.GP64())}
+	n = f.GP64()
+	f.Load(f.Param("src").Len(), n)
+	dstLen := f.Load(f.Param("dst").Len(), f.GP64())
+	f.CMPQ(dstLen, n)
+	f.CMOVQLT(dstLen, n)
+	return f, dst, src, n
+}
+
+// copyTail copies and sums whatever the main loop of a copy kernel left behind, eight bytes
+// at a time and then word by word, all little endian. every ADDQ is followed by an ADCQ $0
+// so the pointer arithmetic in between is free to trash the carry flag.
+func copyTail(f *gen.Func, dst, src operand.Mem, n, sum, word reg.GPVirtual) {
+	f.Label(copyQuads)
+
+	// if n < 8: goto copy_words
+	f.JumpIfBelow(n, 8, copyWords)
+	f.MOVQ(src, word)
+	f.MOVQ(word, dst)
+	f.ADDQ(word, sum)
+	f.ADCQ(operand.Imm(0), sum)
+	f.ADDQ(operand.Imm(8), src.Base)
+	f.ADDQ(operand.Imm(8), dst.Base)
+	f.SUBQ(operand.Imm(8), n)
+	f.Jump(copyQuads)
+
+	f.Label(copyWords)
+
+	// if n < 2: goto copy_odd
+	f.JumpIfBelow(n, 2, copyOdd)
+	f.MOVWQZX(src, word)
+	f.MOVW(word.As16(), dst)
+	f.ADDQ(word, sum)
+	f.ADCQ(operand.Imm(0), sum)
+	f.ADDQ(operand.Imm(2), src.Base)
+	f.ADDQ(operand.Imm(2), dst.Base)
+	f.SUBQ(operand.Imm(2), n)
+	f.Jump(copyWords)
+
+	f.Label(copyOdd)
+
+	// the odd byte is the low byte of a zero padded little endian word
+	f.JumpIfZero(n, fin)
+	f.MOVBQZX(src, word)
+	f.MOVB(word.As8(), dst)
+	f.ADDQ(word, sum)
+	f.ADCQ(operand.Imm(0), sum)
+
+	// ===================================================
+	/*                 FINAL WRAP-AROUND:               */
+	f.Label(fin) // ==========================================
+
+	f.Fold16(sum, word)
+	f.SwapComplement16(sum)
+	f.Return16(sum)
 
 	// ===================================================
 	/*                   EARLY FAIL:                    */
-	lbl(early_fail) // ===================================
-	retReg := build.GP16()
-	build.XORW(retReg, retReg)
-	build.MOVW(operand.U16(0), retReg)
-	build.Store(retReg, build.ReturnIndex(0))
-	build.RET()
-
-	build.Generate()
+	f.Label(early_fail) // ===================================
+	f.ReturnZero16(sum)
+}
+
+// copyScalar is the fused copy and checksum in general purpose registers, in the spirit of
+// linux's csum_partial_copy_nocheck: every quadword is stored right after it's loaded and
+// added into a 64-bit ones' complement sum with one ADC chain per 32 bytes.
+func copyScalar(file *gen.File) {
+	f, dst, src, n := defineCopy(file, "copyChecksumScalar")
+
+	sum := f.GP64()
+	f.Zero(sum)
+	f.JumpIfZero(n, early_fail)
+
+	w0, w1, w2, w3 := f.GP64(), f.GP64(), f.GP64(), f.GP64()
+
+	// ===================================================
+	/*                      MAIN LOOP:                  */
+	f.Label(copyLoop) // =====================================
+
+	// if n < 32: goto copy_quads
+	f.JumpIfBelow(n, 32, copyQuads)
+
+	for i, w := range []reg.GPVirtual{w0, w1, w2, w3} {
+		f.MOVQ(src.Offset(8*i), w)
+	}
+	for i, w := range []reg.GPVirtual{w0, w1, w2, w3} {
+		f.MOVQ(w, dst.Offset(8*i))
+	}
+	f.ADDQ(w0, sum)
+	f.ADCQ(w1, sum)
+	f.ADCQ(w2, sum)
+	f.ADCQ(w3, sum)
+	f.ADCQ(operand.Imm(0), sum)
+
+	// src += 32, dst += 32, n -= 32
+	f.ADDQ(operand.Imm(32), src.Base)
+	f.ADDQ(operand.Imm(32), dst.Base)
+	f.SUBQ(operand.Imm(32), n)
+	f.Jump(copyLoop)
+
+	copyTail(f, dst, src, n, sum, w0)
+}
+
+// copyAVX2 is the fused copy and checksum for AVX2: 64 bytes per iteration go straight from
+// the loads to the stores, and on the way each 32-bit lane is split into its low word (VPAND)
+// and its high word (VPSRLD) so that both can be summed without ever losing a carry.
+// every accumulator gets one word per lane per iteration, so avx2Block still holds.
+func copyAVX2(file *gen.File) {
+	f, dst, src, n := defineCopy(file, "copyChecksumAVX2")
+
+	sum := f.GP64()
+	f.Zero(sum)
+	f.JumpIfZero(n, early_fail)
+
+	// ===================================================
+	/*              REGISTER INITIALIZATION:            */
+	// ===================================================
+
+	block := f.GP64()
+	f.MOVQ(operand.U32(avx2Block), block)
+
+	acc := []reg.VecVirtual{f.YMM(), f.YMM(), f.YMM(), f.YMM()}
+	for _, a := range acc {
+		f.Zero(a)
+	}
+
+	// 0x0000FFFF in every lane: all ones, shifted right 16 bits
+	mask := f.YMM()
+	f.VPCMPEQD(mask, mask, mask)
+	f.VPSRLD(operand.Imm(16), mask, mask)
+
+	data0, data1 := f.YMM(), f.YMM()
+	tmp0, tmp1 := f.YMM(), f.YMM()
+
+	// ===================================================
+	/*                      MAIN LOOP:                  */
+	f.Label(copyLoop) // =====================================
+
+	// if n < 64: goto copy_reduce
+	f.JumpIfBelow(n, 64, copyReduce)
+
+	f.VMOVDQU(src.Offset(0), data0)
+	f.VMOVDQU(src.Offset(32), data1)
+	f.VMOVDQU(data0, dst.Offset(0))
+	f.VMOVDQU(data1, dst.Offset(32))
+
+	// low words
+	f.VPAND(mask, data0, tmp0)
+	f.VPAND(mask, data1, tmp1)
+	f.VPADDD(tmp0, acc[0], acc[0])
+	f.VPADDD(tmp1, acc[1], acc[1])
+
+	// high words
+	f.VPSRLD(operand.Imm(16), data0, data0)
+	f.VPSRLD(operand.Imm(16), data1, data1)
+	f.VPADDD(data0, acc[2], acc[2])
+	f.VPADDD(data1, acc[3], acc[3])
+
+	// src += 64, dst += 64, n -= 64
+	f.ADDQ(operand.Imm(64), src.Base)
+	f.ADDQ(operand.Imm(64), dst.Base)
+	f.SUBQ(operand.Imm(64), n)
+
+	f.DECQ(block)
+	f.JNZ(copyLoop.Ref())
+
+	f.Label(copyFoldBlock) /* ---------- LANE FOLDING ---------- */
+
+	for _, a := range acc {
+		f.FoldLanes(a, mask, tmp0)
+	}
+	f.MOVQ(operand.U32(avx2Block), block)
+	f.Jump(copyLoop)
+
+	// ===================================================
+	/*                VECTOR REDUCTION:                 */
+	f.Label(copyReduce) // ===================================
+
+	// folded lanes are below 0x20000, four of them can't overflow
+	for _, a := range acc {
+		f.FoldLanes(a, mask, tmp0)
+	}
+	f.VPADDD(acc[1], acc[0], acc[0])
+	f.VPADDD(acc[3], acc[2], acc[2])
+	f.VPADDD(acc[2], acc[0], acc[0])
+
+	// 256 --> 128 --> 64 --> 32, same as avx2
+	f.ReduceLanes(acc[0], tmp0)
+
+	f.VMOVD(acc[0].AsX(), sum.As32())
+	f.VZEROUPPER()
+
+	copyTail(f, dst, src, n, sum, f.GP64())
+}
+
+func main() {
+	// the purego tag opts out of assembly altogether, see dispatch_others.go
+	file := gen.NewFile("asm", "amd64,!purego")
+
+	avx512(file)
+	avx2(file)
+	sse2(file)
+	scalar(file)
+	copyAVX2(file)
+	copyScalar(file)
+
+	file.Main()
 }
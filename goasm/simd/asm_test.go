@@ -4,25 +4,17 @@ package asm
 
 import (
 	"bytes"
-	"context"
+	"crypto/rand"
 	"fmt"
 	"os"
-	"os/exec"
 	"runtime"
 	"strconv"
+	"strings"
 	"testing"
 
 	"git.tcp.direct/kayos/common/entropy"
-)
 
-const (
-	goGen         = "go run asm.go -out checksum_amd64.s -stubs checksum_amd64.go"
-	testEarlyFail = "early_fail"
-	green         = "\033[32m"
-	red           = "\033[31m"
-	reset         = "\033[0m"
-	testPassed    = green + "\n\ntest passed: " + reset + "%v\n"
-	testFailed    = red + "\n\ntest failed: " + reset + "%v\n"
+	"asm/gen/asmtest"
 )
 
 func TestRFC1071(t *testing.T) {
@@ -49,9 +41,9 @@ func TestRFC1071(t *testing.T) {
 		t.Run(testCase.name, func(t *testing.T) {
 			actual := rfc1071(testCase.input)
 			if actual != testCase.expect {
-				t.Errorf(testFailed, fmt.Sprintf("Expected %v, but got %s%v%s", testCase.expect, red, actual, reset))
+				t.Errorf(asmtest.Failed, fmt.Sprintf("Expected %v, but got %s%v%s", testCase.expect, asmtest.Red, actual, asmtest.Reset))
 			} else {
-				t.Logf(testPassed,
+				t.Logf(asmtest.Passed,
 					string(testCase.input)+": "+strconv.Itoa(int(testCase.expect))+"=="+strconv.Itoa(int(actual)))
 			}
 		})
@@ -59,44 +51,43 @@ func TestRFC1071(t *testing.T) {
 
 }
 
-func readPipe(ctx context.Context, pipePath string, u16bChan chan []byte, t *testing.T) {
-	tryRead := func() (goOn bool) {
-		f, err := os.OpenFile(pipePath, os.O_RDONLY, os.ModePerm)
-		if err != nil {
-			t.Errorf("%sfailed to open named pipe: %s%s", red, err, reset)
-			return true
-		}
-		defer func() {
-			if err = f.Close(); err != nil {
-				panic(err)
+// TestASMGenerate runs the generator in a copy of the module and checks what it wrote
+// against the reference, without touching the committed assembly.
+func TestASMGenerate(t *testing.T) {
+	harness := &asmtest.Harness{
+		Generator: []string{"go", "run", "asm.go", "-out", "checksum_amd64.s", "-stubs", "checksum_amd64.go"},
+		Asm:       "checksum_amd64.s",
+		Origins:   "checksum_amd64.json",
+	}
+	generated, err := harness.Generate(t, "")
+	if err != nil {
+		t.Fatalf("%s%s%s", asmtest.Red, err, asmtest.Reset)
+	}
+	committed, err := os.ReadFile("checksum_amd64.s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(generated.Asm, committed) {
+		t.Errorf("checksum_amd64.s isn't what asm.go generates, run go generate")
+	}
+	input := strings.Repeat("hello world", 300)
+	expect := rfc1071([]byte(input))
+	for _, f := range []string{"checksumScalar", "Checksum"} {
+		t.Run(f, func(t *testing.T) {
+			res, err := generated.Run(f + "([]byte(" + strconv.Quote(input) + "))")
+			if err != nil {
+				t.Fatalf("%s%s%s", asmtest.Red, err, asmtest.Reset)
 			}
-		}()
-		b := make([]byte, 2)
-		if _, err = f.Read(b); err != nil {
-			t.Errorf("%sfailed to read from named pipe: %s%s", red, err, reset)
-			return true
-		}
-		u16bChan <- b
-		return false
-	}
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			if !tryRead() {
-				return
+			if res.Failure != nil {
+				t.Fatalf(asmtest.Failed+"%s\nemitted by %s", res.Failure.Message, strings.Join(res.Failure.Context, "\n"), res.Failure.Origin)
 			}
-		}
-	}
-}
-
-func setup(t *testing.T) {
-	for _, avo := range []string{"build", "reg", "operand"} {
-		cmd := exec.Command("go", "get", "-v", "github.com/mmcloughlin/avo/"+avo)
-		if cmdOut, err := cmd.CombinedOutput(); err != nil {
-			t.Fatalf("failed to get avo/%s: %s", avo, cmdOut)
-		}
+			if res.Exit != 0 {
+				t.Fatalf(asmtest.Failed+"\n%s", fmt.Sprintf("exit status %d", res.Exit), res.Output)
+			}
+			if res.Value != strconv.Itoa(int(expect)) {
+				t.Errorf(asmtest.Failed, fmt.Sprintf("Expected %v, but got %s%v%s", expect, asmtest.Red, res.Value, asmtest.Reset))
+			}
+		})
 	}
 }
 
@@ -149,13 +140,34 @@ func TestASMChecksum(t *testing.T) {
 		})
 	}
 
+	// every length up to 4096 bytes, so that we hit each combination of vector loop, tail and odd byte
+	for i := 0; i <= 4096; i++ {
+		r := entropy.AcquireRand()
+		val := make([]byte, i)
+		r.Read(val)
+		entropy.ReleaseRand(r)
+		tests = append(tests, test{
+			name:   "length/" + strconv.Itoa(i) + "b",
+			input:  val,
+			expect: rfc1071(val),
+		})
+	}
+
+	// all ones maxes out every vector lane, and over a megabyte we have to fold them along the way
+	saturated := bytes.Repeat([]byte{0xFF}, 1<<20+37)
+	tests = append(tests, test{
+		name:   "saturated/" + strconv.Itoa(len(saturated)) + "b",
+		input:  saturated,
+		expect: rfc1071(saturated),
+	})
+
 	for _, testCase := range tests {
 		t.Run(testCase.name, func(t *testing.T) {
 			actual := checksum(testCase.input)
 			if actual != testCase.expect {
-				t.Errorf(testFailed, fmt.Sprintf("Expected %v, but got %s%v%s", testCase.expect, red, actual, reset))
+				t.Errorf(asmtest.Failed, fmt.Sprintf("Expected %v, but got %s%v%s", testCase.expect, asmtest.Red, actual, asmtest.Reset))
 			} else {
-				t.Logf(testPassed,
+				t.Logf(asmtest.Passed,
 					string(testCase.input)+": "+strconv.Itoa(int(testCase.expect))+"=="+strconv.Itoa(int(actual)))
 			}
 		})
@@ -190,6 +202,12 @@ func BenchmarkChecksum(b *testing.B) {
 		},
 	}
 
+	for _, i := range implementations() {
+		if i.available {
+			candidates = append(candidates, candidate{name: "goasm/" + i.name, f: i.f})
+		}
+	}
+
 	for _, cn := range candidates {
 		for _, data := range btests {
 			if cn.name == "goasm" && runtime.GOARCH != "amd64" {
@@ -208,3 +226,31 @@ func BenchmarkChecksum(b *testing.B) {
 		}
 	}
 }
+
+// BenchmarkCopyChecksum pits the fused copy kernels against a copy followed by a checksum
+// with the same implementation, the way we used to move payloads out of the receive ring.
+func BenchmarkCopyChecksum(b *testing.B) {
+	for _, i := range implementations() {
+		if !i.available {
+			continue
+		}
+		for _, size := range []int{64, 1500, 9000, 64 << 10, 1 << 20} {
+			src := make([]byte, size)
+			dst := make([]byte, size)
+			_, _ = rand.Read(src)
+			b.Run(i.name+"/copy+checksum/"+strconv.Itoa(size), func(b *testing.B) {
+				b.SetBytes(int64(size))
+				for n := 0; n < b.N; n++ {
+					copy(dst, src)
+					i.f(dst)
+				}
+			})
+			b.Run(i.name+"/fused/"+strconv.Itoa(size), func(b *testing.B) {
+				b.SetBytes(int64(size))
+				for n := 0; n < b.N; n++ {
+					i.copy(dst, src)
+				}
+			})
+		}
+	}
+}
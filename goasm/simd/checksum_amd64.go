@@ -1,7 +1,17 @@
 // Code generated by command: go run asm.go -out checksum_amd64.s -stubs checksum_amd64.go. DO NOT EDIT.
 
-//go:build amd64
+//go:build amd64 && !purego
 
 package asm
 
-func checksum(data []byte) uint16
+func checksumAVX512(data []byte) uint16
+
+func checksumAVX2(data []byte) uint16
+
+func checksumSSE2(data []byte) uint16
+
+func checksumScalar(data []byte) uint16
+
+func copyChecksumAVX2(dst []byte, src []byte) uint16
+
+func copyChecksumScalar(dst []byte, src []byte) uint16
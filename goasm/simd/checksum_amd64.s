@@ -1,59 +1,507 @@
 // Code generated by command: go run asm.go -out checksum_amd64.s -stubs checksum_amd64.go. DO NOT EDIT.
 
-//go:build amd64
-
-// func checksum(data []byte) uint16
-// Requires: AVX, AVX2, SSE2
-TEXT ·checksum(SB), $0-26
-	MOVQ   data_base+0(FP), AX
-	MOVQ   data_len+8(FP), CX
-	TESTQ  CX, CX
-	JZ     done
-	XORQ   DX, DX
-	XORQ   BX, BX
-	VXORPS Y0, Y0, Y0
-	VXORPS Y1, Y1, Y1
+//go:build amd64 && !purego
+
+// func checksumAVX512(data []byte) uint16
+// Requires: AVX, AVX2, AVX512BW, AVX512F, BMI2
+TEXT ·checksumAVX512(SB), $0-26
+	MOVQ       data_base+0(FP), AX
+	MOVQ       data_len+8(FP), CX
+	TESTQ      CX, CX
+	JZ         early_fail
+	MOVQ       $0x00004000, DX
+	VPXORD     Z0, Z0, Z0
+	VPXORD     Z1, Z1, Z1
+	VPTERNLOGD $0xff, Z2, Z2, Z2
+	VPSRLD     $0x10, Z2, Z2
+
+zmm_loop:
+	CMPQ      CX, $0x40
+	JB        zmm_tail
+	VPMOVZXWD (AX), Z3
+	VPMOVZXWD 32(AX), Z4
+	VPADDD    Z3, Z0, Z0
+	VPADDD    Z4, Z1, Z1
+	ADDQ      $0x40, AX
+	SUBQ      $0x40, CX
+	DECQ      DX
+	JNZ       zmm_loop
+	VPSRLD    $0x10, Z0, Z3
+	VPANDD    Z2, Z0, Z0
+	VPADDD    Z3, Z0, Z0
+	VPSRLD    $0x10, Z1, Z4
+	VPANDD    Z2, Z1, Z1
+	VPADDD    Z4, Z1, Z1
+	MOVQ      $0x00004000, DX
+	JMP       zmm_loop
+
+zmm_tail:
+	MOVQ          $-1, DX
+	BZHIQ         CX, DX, DX
+	KMOVQ         DX, K1
+	VMOVDQU8.Z    (AX), K1, Z3
+	VEXTRACTI64X4 $0x01, Z3, Y4
+	VPMOVZXWD     Y3, Z3
+	VPMOVZXWD     Y4, Z4
+	VPADDD        Z3, Z0, Z0
+	VPADDD        Z4, Z1, Z1
+	VPSRLD        $0x10, Z0, Z3
+	VPANDD        Z2, Z0, Z0
+	VPADDD        Z3, Z0, Z0
+	VPSRLD        $0x10, Z1, Z4
+	VPANDD        Z2, Z1, Z1
+	VPADDD        Z4, Z1, Z1
+	VPADDD        Z1, Z0, Z0
+	VEXTRACTI64X4 $0x01, Z0, Y3
+	VPADDD        Y3, Y0, Y0
+	VEXTRACTI128  $0x01, Y0, X3
+	VPADDD        X3, X0, X0
+	VPSHUFD       $0x4e, X0, X3
+	VPADDD        X3, X0, X0
+	VPSHUFD       $0xb1, X0, X3
+	VPADDD        X3, X0, X0
+	VMOVD         X0, AX
+	VZEROUPPER
+	MOVQ          AX, DX
+	SHRQ          $0x20, DX
+	ADDL          DX, AX
+	ADCL          $0x00, AX
+	MOVL          AX, DX
+	SHRL          $0x10, DX
+	ADDW          DX, AX
+	ADCW          $0x00, AX
+	ROLW          $0x08, AX
+	NOTW          AX
+	MOVW          AX, ret+24(FP)
+	RET
+
+early_fail:
+	XORW AX, AX
+	MOVW AX, ret+24(FP)
+	RET
+
+// func checksumAVX2(data []byte) uint16
+// Requires: AVX, AVX2
+TEXT ·checksumAVX2(SB), $0-26
+	MOVQ     data_base+0(FP), AX
+	MOVQ     data_len+8(FP), CX
+	TESTQ    CX, CX
+	JZ       early_fail
+	XORQ     DX, DX
+	MOVQ     $0x00004000, BX
+	VXORPS   Y0, Y0, Y0
+	VXORPS   Y1, Y1, Y1
+	VPCMPEQD Y2, Y2, Y2
+	VPSRLD   $0x10, Y2, Y2
 
 loop:
-	CMPQ    BX, CX
-	JAE     remainder
-	VMOVDQU Y1, (AX)(BX*1)
-	VPADDW  Y0, Y0, Y1
-	ADDQ    $0x00000020, BX
-	JMP     loop
+	CMPQ      CX, $0x20
+	JB        remainder
+	VPMOVZXWD (AX), Y3
+	VPMOVZXWD 16(AX), Y4
+	VPADDD    Y3, Y0, Y0
+	VPADDD    Y4, Y1, Y1
+	ADDQ      $0x20, AX
+	SUBQ      $0x20, CX
+	DECQ      BX
+	JNZ       loop
+	VPSRLD    $0x10, Y0, Y3
+	VPAND     Y2, Y0, Y0
+	VPADDD    Y3, Y0, Y0
+	VPSRLD    $0x10, Y1, Y4
+	VPAND     Y2, Y1, Y1
+	VPADDD    Y4, Y1, Y1
+	MOVQ      $0x00004000, BX
+	JMP       loop
 
 remainder:
-	MOVQ SI, CX
-	SUBQ SI, BX
+	VPADDD       Y1, Y0, Y0
+	VPSRLD       $0x10, Y0, Y3
+	VPAND        Y2, Y0, Y0
+	VPADDD       Y3, Y0, Y0
+	VEXTRACTI128 $0x01, Y0, X3
+	VPADDD       X3, X0, X0
+	VPSHUFD      $0x4e, X0, X3
+	VPADDD       X3, X0, X0
+	VPSHUFD      $0xb1, X0, X3
+	VPADDD       X3, X0, X0
+	VMOVD        X0, BX
+	ADDQ         BX, DX
+	VZEROUPPER
 
 remainder_loop:
-	TESTQ SI, SI
-	JZ    done
-	MOVB  DI, (AX)(BX*1)
-	ADDQ  DI, DX
-	INCQ  BX
-	DECQ  SI
-	JMP   remainder_loop
+	CMPQ    CX, $0x02
+	JB      remainder_odd
+	MOVWQZX (AX), BX
+	ADDQ    BX, DX
+	ADDQ    $0x02, AX
+	SUBQ    $0x02, CX
+	JMP     remainder_loop
+
+remainder_odd:
+	TESTQ   CX, CX
+	JZ      done
+	MOVBQZX (AX), BX
+	ADDQ    BX, DX
 
 done:
-	VPERM2I128 $0x01, Y0, Y0, Y1
-	VPADDW     Y0, Y0, Y1
-	VMOVDQU    X2, X0
-	MOVD       DI, X2
-	ADDQ       DX, DI
-	PSRLDQ     $0x08, X2
-	MOVD       X2, DI
-	ADDQ       DX, DI
-	MOVL       R8, DX
-	SHRL       $0x10, R8
-	ADDL       DX, R8
-	MOVL       R9, R8
-	SHRL       $0x10, R9
-	ADDL       DX, R9
-	ANDQ       $0x0000ffff, DX
-	MOVW       DX, ret+24(FP)
+	MOVQ DX, BX
+	SHRQ $0x20, BX
+	ADDL BX, DX
+	ADCL $0x00, DX
+	MOVL DX, BX
+	SHRL $0x10, BX
+	ADDW BX, DX
+	ADCW $0x00, DX
+	ROLW $0x08, DX
+	NOTW DX
+	MOVW DX, ret+24(FP)
 	RET
-	XORW AX, AX
-	MOVW $0x0000, AX
-	MOVW AX, ret+24(FP)
+
+early_fail:
+	XORW DX, DX
+	MOVW DX, ret+24(FP)
+	RET
+
+// func checksumSSE2(data []byte) uint16
+// Requires: SSE2
+TEXT ·checksumSSE2(SB), $0-26
+	MOVQ    data_base+0(FP), AX
+	MOVQ    data_len+8(FP), CX
+	TESTQ   CX, CX
+	JZ      early_fail
+	XORQ    DX, DX
+	MOVQ    $0x00004000, BX
+	PXOR    X0, X0
+	PXOR    X1, X1
+	PXOR    X2, X2
+	PCMPEQL X3, X3
+	PSRLL   $0x10, X3
+
+sse_loop:
+	CMPQ      CX, $0x10
+	JB        sse_remainder
+	MOVOU     (AX), X4
+	MOVO      X4, X5
+	PUNPCKLWL X2, X4
+	PUNPCKHWL X2, X5
+	PADDL     X4, X0
+	PADDL     X5, X1
+	ADDQ      $0x10, AX
+	SUBQ      $0x10, CX
+	DECQ      BX
+	JNZ       sse_loop
+	MOVO      X0, X4
+	PSRLL     $0x10, X4
+	PAND      X3, X0
+	PADDL     X4, X0
+	MOVO      X1, X5
+	PSRLL     $0x10, X5
+	PAND      X3, X1
+	PADDL     X5, X1
+	MOVQ      $0x00004000, BX
+	JMP       sse_loop
+
+sse_remainder:
+	PADDL  X1, X0
+	MOVO   X0, X4
+	PSRLL  $0x10, X4
+	PAND   X3, X0
+	PADDL  X4, X0
+	PSHUFD $0x4e, X0, X4
+	PADDL  X4, X0
+	MOVQ   X0, BX
+	ADDQ   BX, DX
+
+sse_remainder_loop:
+	CMPQ    CX, $0x02
+	JB      sse_remainder_odd
+	MOVWQZX (AX), BX
+	ADDQ    BX, DX
+	ADDQ    $0x02, AX
+	SUBQ    $0x02, CX
+	JMP     sse_remainder_loop
+
+sse_remainder_odd:
+	TESTQ   CX, CX
+	JZ      done
+	MOVBQZX (AX), BX
+	ADDQ    BX, DX
+
+done:
+	MOVQ DX, BX
+	SHRQ $0x20, BX
+	ADDL BX, DX
+	ADCL $0x00, DX
+	MOVL DX, BX
+	SHRL $0x10, BX
+	ADDW BX, DX
+	ADCW $0x00, DX
+	ROLW $0x08, DX
+	NOTW DX
+	MOVW DX, ret+24(FP)
+	RET
+
+early_fail:
+	XORW DX, DX
+	MOVW DX, ret+24(FP)
+	RET
+
+// func checksumScalar(data []byte) uint16
+TEXT ·checksumScalar(SB), $0-26
+	MOVQ  data_base+0(FP), AX
+	MOVQ  data_len+8(FP), CX
+	TESTQ CX, CX
+	JZ    early_fail
+	XORQ  DX, DX
+
+scalar_loop:
+	CMPQ    CX, $0x02
+	JB      scalar_odd
+	MOVWQZX (AX), BX
+	ROLW    $0x08, BX
+	ADDQ    BX, DX
+	ADDQ    $0x02, AX
+	SUBQ    $0x02, CX
+	JMP     scalar_loop
+
+scalar_odd:
+	TESTQ   CX, CX
+	JZ      scalar_fold
+	MOVBQZX (AX), BX
+	SHLQ    $0x08, BX
+	ADDQ    BX, DX
+
+scalar_fold:
+	MOVQ DX, BX
+	SHRQ $0x20, BX
+	ADDL BX, DX
+	ADCL $0x00, DX
+	MOVL DX, BX
+	SHRL $0x10, BX
+	ADDW BX, DX
+	ADCW $0x00, DX
+	NOTW DX
+	MOVW DX, ret+24(FP)
+	RET
+
+early_fail:
+	XORW DX, DX
+	MOVW DX, ret+24(FP)
+	RET
+
+// func copyChecksumAVX2(dst []byte, src []byte) uint16
+// Requires: AVX, AVX2, CMOV
+TEXT ·copyChecksumAVX2(SB), $0-50
+	MOVQ     dst_base+0(FP), AX
+	MOVQ     src_base+24(FP), CX
+	MOVQ     src_len+32(FP), DX
+	MOVQ     dst_len+8(FP), BX
+	CMPQ     BX, DX
+	CMOVQLT  BX, DX
+	XORQ     BX, BX
+	TESTQ    DX, DX
+	JZ       early_fail
+	MOVQ     $0x00004000, BX
+	VXORPS   Y0, Y0, Y0
+	VXORPS   Y1, Y1, Y1
+	VXORPS   Y2, Y2, Y2
+	VXORPS   Y3, Y3, Y3
+	VPCMPEQD Y4, Y4, Y4
+	VPSRLD   $0x10, Y4, Y4
+
+copy_loop:
+	CMPQ    DX, $0x40
+	JB      copy_reduce
+	VMOVDQU (CX), Y5
+	VMOVDQU 32(CX), Y6
+	VMOVDQU Y5, (AX)
+	VMOVDQU Y6, 32(AX)
+	VPAND   Y4, Y5, Y7
+	VPAND   Y4, Y6, Y8
+	VPADDD  Y7, Y0, Y0
+	VPADDD  Y8, Y1, Y1
+	VPSRLD  $0x10, Y5, Y5
+	VPSRLD  $0x10, Y6, Y6
+	VPADDD  Y5, Y2, Y2
+	VPADDD  Y6, Y3, Y3
+	ADDQ    $0x40, CX
+	ADDQ    $0x40, AX
+	SUBQ    $0x40, DX
+	DECQ    BX
+	JNZ     copy_loop
+	VPSRLD  $0x10, Y0, Y7
+	VPAND   Y4, Y0, Y0
+	VPADDD  Y7, Y0, Y0
+	VPSRLD  $0x10, Y1, Y7
+	VPAND   Y4, Y1, Y1
+	VPADDD  Y7, Y1, Y1
+	VPSRLD  $0x10, Y2, Y7
+	VPAND   Y4, Y2, Y2
+	VPADDD  Y7, Y2, Y2
+	VPSRLD  $0x10, Y3, Y7
+	VPAND   Y4, Y3, Y3
+	VPADDD  Y7, Y3, Y3
+	MOVQ    $0x00004000, BX
+	JMP     copy_loop
+
+copy_reduce:
+	VPSRLD       $0x10, Y0, Y7
+	VPAND        Y4, Y0, Y0
+	VPADDD       Y7, Y0, Y0
+	VPSRLD       $0x10, Y1, Y7
+	VPAND        Y4, Y1, Y1
+	VPADDD       Y7, Y1, Y1
+	VPSRLD       $0x10, Y2, Y7
+	VPAND        Y4, Y2, Y2
+	VPADDD       Y7, Y2, Y2
+	VPSRLD       $0x10, Y3, Y7
+	VPAND        Y4, Y3, Y3
+	VPADDD       Y7, Y3, Y3
+	VPADDD       Y1, Y0, Y0
+	VPADDD       Y3, Y2, Y2
+	VPADDD       Y2, Y0, Y0
+	VEXTRACTI128 $0x01, Y0, X7
+	VPADDD       X7, X0, X0
+	VPSHUFD      $0x4e, X0, X7
+	VPADDD       X7, X0, X0
+	VPSHUFD      $0xb1, X0, X7
+	VPADDD       X7, X0, X0
+	VMOVD        X0, BX
+	VZEROUPPER
+
+copy_quads:
+	CMPQ DX, $0x08
+	JB   copy_words
+	MOVQ (CX), SI
+	MOVQ SI, (AX)
+	ADDQ SI, BX
+	ADCQ $0x00, BX
+	ADDQ $0x08, CX
+	ADDQ $0x08, AX
+	SUBQ $0x08, DX
+	JMP  copy_quads
+
+copy_words:
+	CMPQ    DX, $0x02
+	JB      copy_odd
+	MOVWQZX (CX), SI
+	MOVW    SI, (AX)
+	ADDQ    SI, BX
+	ADCQ    $0x00, BX
+	ADDQ    $0x02, CX
+	ADDQ    $0x02, AX
+	SUBQ    $0x02, DX
+	JMP     copy_words
+
+copy_odd:
+	TESTQ   DX, DX
+	JZ      done
+	MOVBQZX (CX), SI
+	MOVB    SI, (AX)
+	ADDQ    SI, BX
+	ADCQ    $0x00, BX
+
+done:
+	MOVQ BX, SI
+	SHRQ $0x20, SI
+	ADDL SI, BX
+	ADCL $0x00, BX
+	MOVL BX, SI
+	SHRL $0x10, SI
+	ADDW SI, BX
+	ADCW $0x00, BX
+	ROLW $0x08, BX
+	NOTW BX
+	MOVW BX, ret+48(FP)
+	RET
+
+early_fail:
+	XORW BX, BX
+	MOVW BX, ret+48(FP)
+	RET
+
+// func copyChecksumScalar(dst []byte, src []byte) uint16
+// Requires: CMOV
+TEXT ·copyChecksumScalar(SB), $0-50
+	MOVQ    dst_base+0(FP), AX
+	MOVQ    src_base+24(FP), CX
+	MOVQ    src_len+32(FP), DX
+	MOVQ    dst_len+8(FP), BX
+	CMPQ    BX, DX
+	CMOVQLT BX, DX
+	XORQ    BX, BX
+	TESTQ   DX, DX
+	JZ      early_fail
+
+copy_loop:
+	CMPQ DX, $0x20
+	JB   copy_quads
+	MOVQ (CX), SI
+	MOVQ 8(CX), DI
+	MOVQ 16(CX), R8
+	MOVQ 24(CX), R9
+	MOVQ SI, (AX)
+	MOVQ DI, 8(AX)
+	MOVQ R8, 16(AX)
+	MOVQ R9, 24(AX)
+	ADDQ SI, BX
+	ADCQ DI, BX
+	ADCQ R8, BX
+	ADCQ R9, BX
+	ADCQ $0x00, BX
+	ADDQ $0x20, CX
+	ADDQ $0x20, AX
+	SUBQ $0x20, DX
+	JMP  copy_loop
+
+copy_quads:
+	CMPQ DX, $0x08
+	JB   copy_words
+	MOVQ (CX), SI
+	MOVQ SI, (AX)
+	ADDQ SI, BX
+	ADCQ $0x00, BX
+	ADDQ $0x08, CX
+	ADDQ $0x08, AX
+	SUBQ $0x08, DX
+	JMP  copy_quads
+
+copy_words:
+	CMPQ    DX, $0x02
+	JB      copy_odd
+	MOVWQZX (CX), SI
+	MOVW    SI, (AX)
+	ADDQ    SI, BX
+	ADCQ    $0x00, BX
+	ADDQ    $0x02, CX
+	ADDQ    $0x02, AX
+	SUBQ    $0x02, DX
+	JMP     copy_words
+
+copy_odd:
+	TESTQ   DX, DX
+	JZ      done
+	MOVBQZX (CX), SI
+	MOVB    SI, (AX)
+	ADDQ    SI, BX
+	ADCQ    $0x00, BX
+
+done:
+	MOVQ BX, SI
+	SHRQ $0x20, SI
+	ADDL SI, BX
+	ADCL $0x00, BX
+	MOVL BX, SI
+	SHRL $0x10, SI
+	ADDW SI, BX
+	ADCW $0x00, BX
+	ROLW $0x08, BX
+	NOTW BX
+	MOVW BX, ret+48(FP)
+	RET
+
+early_fail:
+	XORW BX, BX
+	MOVW BX, ret+48(FP)
 	RET
@@ -1,6 +1,13 @@
 package asm
 
-import "testing"
+import (
+	"bytes"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+)
 
 func TestChecksum(t *testing.T) {
 	data := []byte("hello")
@@ -10,3 +17,131 @@ func TestChecksum(t *testing.T) {
 		t.Errorf("Expected %v, but got %v", expected, actual)
 	}
 }
+
+func TestDigest(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		c := NewDigest()
+		if c.Sum16() != 0 {
+			t.Errorf("Expected 0, but got %v", c.Sum16())
+		}
+		_, _ = c.Write(nil)
+		if c.Sum16() != 0 {
+			t.Errorf("Expected 0 after empty write, but got %v", c.Sum16())
+		}
+	})
+
+	t.Run("hello", func(t *testing.T) {
+		c := NewDigest()
+		for _, chunk := range []string{"h", "el", "l", "o"} {
+			_, _ = c.Write([]byte(chunk))
+		}
+		if c.Sum16() != 48173 {
+			t.Errorf("Expected 48173, but got %v", c.Sum16())
+		}
+		if sum := c.Sum([]byte{0xFF}); !bytes.Equal(sum, []byte{0xFF, byte(48173 >> 8), byte(48173 & 0xFF)}) {
+			t.Errorf("unexpected Sum output: %x", sum)
+		}
+		c.Reset()
+		if c.Sum16() != 0 {
+			t.Errorf("Expected 0 after reset, but got %v", c.Sum16())
+		}
+	})
+
+	for i := 0; i < 64; i++ {
+		data := make([]byte, rand.Intn(4096))
+		rand.Read(data)
+		expect := rfc1071(data)
+		t.Run("split/"+strconv.Itoa(len(data))+"b", func(t *testing.T) {
+			c := NewDigest()
+			for rest := data; len(rest) > 0; {
+				n := rand.Intn(len(rest)) + 1
+				_, _ = c.Write(rest[:n])
+				rest = rest[n:]
+			}
+			if actual := c.Sum16(); actual != expect {
+				t.Errorf("Expected %v, but got %v", expect, actual)
+			}
+		})
+	}
+}
+
+func TestChecksumVec(t *testing.T) {
+	if actual := ChecksumVec(nil); actual != 0 {
+		t.Errorf("nil: expected 0, but got %v", actual)
+	}
+	if actual := ChecksumVec(net.Buffers{{}, {}}); actual != 0 {
+		t.Errorf("empty fragments: expected 0, but got %v", actual)
+	}
+	if actual := ChecksumVec(net.Buffers{[]byte("h"), nil, []byte("el"), []byte("lo")}); actual != 48173 {
+		t.Errorf("hello: expected 48173, but got %v", actual)
+	}
+
+	for i := 0; i < 64; i++ {
+		data := make([]byte, rand.Intn(4096))
+		rand.Read(data)
+		expect := rfc1071(data)
+		t.Run("split/"+strconv.Itoa(len(data))+"b", func(t *testing.T) {
+			var bufs [][]byte
+			for rest := data; len(rest) > 0; {
+				// lean towards tiny fragments so odd boundaries pile up
+				n := min(rand.Intn(1<<rand.Intn(12))+1, len(rest))
+				bufs = append(bufs, rest[:n])
+				if rand.Intn(8) == 0 {
+					bufs = append(bufs, nil)
+				}
+				rest = rest[n:]
+			}
+			if actual := ChecksumVec(bufs); actual != expect {
+				t.Errorf("%d fragments: expected %v, but got %v", len(bufs), expect, actual)
+			}
+		})
+	}
+}
+
+func TestImplementations(t *testing.T) {
+	t.Cleanup(func() {
+		if !useImplementation(os.Getenv(implEnv)) {
+			useImplementation("")
+		}
+	})
+
+	for _, i := range implementations() {
+		t.Run(i.name, func(t *testing.T) {
+			if !i.available {
+				t.Skipf("skipping %s: not supported by this CPU", i.name)
+			}
+			if !useImplementation(i.name) || Implementation() != i.name {
+				t.Fatalf("failed to switch to %s, using %s", i.name, Implementation())
+			}
+			for n := 0; n < 1024; n++ {
+				data := make([]byte, n)
+				rand.Read(data)
+				if expect, actual := rfc1071(data), Checksum(data); actual != expect {
+					t.Fatalf("%d bytes: expected %v, but got %v", n, expect, actual)
+				}
+				// a destination that's too short cuts the copy (and the checksum) short, like copy does
+				dst := make([]byte, rand.Intn(n+8))
+				m := min(n, len(dst))
+				if expect, actual := rfc1071(data[:m]), CopyChecksum(dst, data); actual != expect {
+					t.Fatalf("copy %d of %d bytes: expected %v, but got %v", m, n, expect, actual)
+				}
+				if !bytes.Equal(dst[:m], data[:m]) {
+					t.Fatalf("copy %d of %d bytes: dst doesn't match src", m, n)
+				}
+			}
+			// big enough for the vector kernels to fold their lanes along the way
+			saturated := bytes.Repeat([]byte{0xFF}, 1<<20+37)
+			if expect, actual := rfc1071(saturated), Checksum(saturated); actual != expect {
+				t.Errorf("saturated: expected %v, but got %v", expect, actual)
+			}
+			dst := make([]byte, len(saturated))
+			if expect, actual := rfc1071(saturated), CopyChecksum(dst, saturated); actual != expect || !bytes.Equal(dst, saturated) {
+				t.Errorf("saturated copy: expected %v, but got %v", expect, actual)
+			}
+		})
+	}
+
+	if useImplementation("yeet") {
+		t.Errorf("switched to an implementation that doesn't exist")
+	}
+}
@@ -0,0 +1,30 @@
+package asm
+
+import (
+	"hash"
+
+	"asm/internal/csum"
+)
+
+var _ hash.Hash = (*Digest)(nil)
+
+// Digest is the running state of an RFC 1071 internet checksum.
+//
+// Data can be fed to it in any number of Write calls and Sum16 will return the same
+// value as checksum would for the concatenation of every write. An odd-length write
+// leaves its last byte dangling until the next write, so chunks don't need to be
+// aligned to 16 bit words. Each (even) chunk is handed to the assembly checksum kernel.
+type Digest = csum.Digest[kernel]
+
+// kernel hands the chunks of a Digest to checksum.
+type kernel struct{}
+
+func (kernel) PartialSum(data []byte, initial uint32) uint32 {
+	// the kernel hands back the complement of the folded sum, flip it back
+	return uint32(csum.Fold(initial)) + uint32(^checksum(data))
+}
+
+// NewDigest returns a new, zeroed Digest.
+func NewDigest() *Digest {
+	return &Digest{}
+}
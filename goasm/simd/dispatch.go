@@ -0,0 +1,78 @@
+// amd64 kernels are generated with avo, arm64 ones with our own little Plan 9 emitter.
+//go:generate go run asm.go -out checksum_amd64.s -stubs checksum_amd64.go
+//go:generate go run neon.go -out checksum_arm64.s -stubs checksum_arm64.go
+
+package asm
+
+import "os"
+
+// implEnv names the environment variable that forces Checksum onto a specific
+// implementation at init time, e.g. ASM_CHECKSUM_IMPL=generic. Unknown names and
+// implementations the CPU doesn't support are ignored in favor of auto-detection.
+const implEnv = "ASM_CHECKSUM_IMPL"
+
+type implementation struct {
+	name      string
+	available bool
+	f         func(data []byte) uint16
+	// copy is the fused copy and checksum that goes with f, see CopyChecksum.
+	copy func(dst, src []byte) uint16
+}
+
+var (
+	checksum     = checksumGeneric
+	copyChecksum = copyThenChecksum(checksumGeneric)
+	impl         = "generic"
+)
+
+func init() {
+	if !useImplementation(os.Getenv(implEnv)) {
+		useImplementation("")
+	}
+}
+
+// implementations returns every checksum implementation for this architecture,
+// fastest first. The portable pure go one is always last and always available.
+func implementations() []implementation {
+	return append(archImplementations(), implementation{name: "generic", available: true, f: checksumGeneric, copy: copyThenChecksum(checksumGeneric)})
+}
+
+// useImplementation switches checksum over to the named implementation, or to the fastest
+// available one if name is empty. It reports false if name is unknown or unavailable.
+func useImplementation(name string) bool {
+	for _, i := range implementations() {
+		if !i.available || (name != "" && name != i.name) {
+			continue
+		}
+		checksum, copyChecksum, impl = i.f, i.copy, i.name
+		return true
+	}
+	return false
+}
+
+// Checksum returns the RFC 1071 internet checksum of data using the fastest
+// implementation the CPU supports.
+func Checksum(data []byte) uint16 {
+	return checksum(data)
+}
+
+// CopyChecksum copies src into dst like the copy builtin and returns the RFC 1071 internet
+// checksum of the bytes it copied, reading every byte only once where the CPU allows it.
+// dst and src must not overlap.
+func CopyChecksum(dst, src []byte) uint16 {
+	return copyChecksum(dst, src)
+}
+
+// copyThenChecksum is the unfused copy and checksum, for kernels without a copy variant.
+func copyThenChecksum(f func(data []byte) uint16) func(dst, src []byte) uint16 {
+	return func(dst, src []byte) uint16 {
+		n := copy(dst, src)
+		return f(dst[:n])
+	}
+}
+
+// Implementation returns the name of the implementation Checksum is using:
+// "avx512", "avx2", "sse2", "scalar", "neon" or "generic".
+func Implementation() string {
+	return impl
+}
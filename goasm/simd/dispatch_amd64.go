@@ -0,0 +1,18 @@
+//go:build amd64 && !purego
+
+package asm
+
+import "golang.org/x/sys/cpu"
+
+// archImplementations returns the amd64 assembly kernels, fastest first.
+// SSE2 is part of the amd64 baseline so it's always there in practice,
+// and the scalar kernel doesn't need anything at all. The fused copy kernels only come
+// in AVX2 and scalar flavors, AVX-512 CPUs all have AVX2 too.
+func archImplementations() []implementation {
+	return []implementation{
+		{name: "avx512", available: cpu.X86.HasAVX512F && cpu.X86.HasAVX512BW && cpu.X86.HasBMI2, f: checksumAVX512, copy: copyChecksumAVX2},
+		{name: "avx2", available: cpu.X86.HasAVX2, f: checksumAVX2, copy: copyChecksumAVX2},
+		{name: "sse2", available: cpu.X86.HasSSE2, f: checksumSSE2, copy: copyChecksumScalar},
+		{name: "scalar", available: true, f: checksumScalar, copy: copyChecksumScalar},
+	}
+}
@@ -0,0 +1,13 @@
+//go:build arm64 && !purego
+
+package asm
+
+import "golang.org/x/sys/cpu"
+
+// archImplementations returns the arm64 assembly kernels, fastest first.
+// ASIMD (NEON) is mandatory on arm64, but we ask anyway.
+func archImplementations() []implementation {
+	return []implementation{
+		{name: "neon", available: cpu.ARM64.HasASIMD, f: checksumNEON, copy: copyThenChecksum(checksumNEON)},
+	}
+}
@@ -0,0 +1,9 @@
+//go:build (!amd64 && !arm64) || purego
+
+package asm
+
+// archImplementations returns nothing, we only have assembly for amd64 and arm64 so far
+// and the purego tag asks us not to use it.
+func archImplementations() []implementation {
+	return nil
+}
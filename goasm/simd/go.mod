@@ -2,7 +2,12 @@ module asm
 
 go 1.22.3
 
-require git.tcp.direct/kayos/common v0.9.7
+require (
+	asm/gen v0.0.0-00010101000000-000000000000
+	asm/internal/csum v0.0.0-00010101000000-000000000000
+	git.tcp.direct/kayos/common v0.9.7
+	golang.org/x/sys v0.15.0
+)
 
 require (
 	github.com/mmcloughlin/avo v0.6.0 // indirect
@@ -10,3 +15,8 @@ require (
 	golang.org/x/tools v0.16.1 // indirect
 	nullprogram.com/x/rng v1.1.0 // indirect
 )
+
+replace (
+	asm/gen => ../gen
+	asm/internal/csum => ../internal/csum
+)
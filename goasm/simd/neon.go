@@ -0,0 +1,241 @@
+//go:build ignore
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+/*
+# arm64 notes
+
+avo only speaks amd64, so this is a tiny stand-in for it: no register allocation,
+no operand types, just a builder that writes Plan 9 arm64 text the same way avo lays out
+amd64 text (one tab indented block per label, mnemonics padded to the widest in the block).
+
+operand order is go's, same as amd64: sources first, destination last.
+
+	VUADDW  Vm.H4, Vn.S4, Vd.S4: Vd = Vn + zero extended low four halfwords of Vm
+	VUADDW2 Vm.H8, Vn.S4, Vd.S4: same thing, high four halfwords of Vm
+
+the go assembler doesn't know UADDLP/UADALP (pairwise widening add), UADDW/UADDW2
+widen the same eight halfwords into 32-bit lanes with two instructions instead of one.
+
+registers:
+	R0-R15 are ours, R18 is the platform register, R26-R30 belong to the runtime (g, tmp, FP, LR).
+	V0-V31 are all caller saved in ABI0.
+*/
+
+type instruction struct {
+	op       string
+	operands []string
+}
+
+type block struct {
+	label        string
+	instructions []instruction
+}
+
+type neonASM struct {
+	name      string
+	doc       string
+	signature string
+	frame     string
+
+	blocks []*block
+}
+
+func newNeonASM(name, signature, frame, doc string) *neonASM {
+	return &neonASM{
+		name:      name,
+		doc:       doc,
+		signature: signature,
+		frame:     frame,
+		blocks:    []*block{{}},
+	}
+}
+
+func (f *neonASM) inst(op string, operands ...string) {
+	cur := f.blocks[len(f.blocks)-1]
+	cur.instructions = append(cur.instructions, instruction{op: op, operands: operands})
+}
+
+func (f *neonASM) AddLabeledFunc(name string, fnc func()) string {
+	f.blocks = append(f.blocks, &block{label: name})
+	fnc()
+	return name
+}
+
+func (f *neonASM) text(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "\n// %s\nTEXT ·%s(SB), NOSPLIT, %s\n", strings.Replace(f.signature, "func(", "func "+f.name+"(", 1), f.name, f.frame)
+	for i, b := range f.blocks {
+		if b.label != "" {
+			if i > 0 && len(f.blocks[i-1].instructions) > 0 {
+				buf.WriteString("\n")
+			}
+			buf.WriteString(b.label + ":\n")
+		}
+		width := 0
+		for _, in := range b.instructions {
+			width = max(width, len(in.op))
+		}
+		for _, in := range b.instructions {
+			if len(in.operands) == 0 {
+				fmt.Fprintf(buf, "\t%s\n", in.op)
+				continue
+			}
+			fmt.Fprintf(buf, "\t%-*s %s\n", width, in.op, strings.Join(in.operands, ", "))
+		}
+	}
+}
+
+func (f *neonASM) stub(buf *bytes.Buffer) {
+	buf.WriteString("\n")
+	if f.doc != "" {
+		buf.WriteString("// " + f.doc + "\n")
+	}
+	buf.WriteString(strings.Replace(f.signature, "func(", "func "+f.name+"(", 1) + "\n")
+}
+
+const (
+	header     = "// Code generated by command: go run neon.go -out checksum_arm64.s -stubs checksum_arm64.go. DO NOT EDIT.\n\n"
+	constraint = "//go:build arm64 && !purego\n"
+
+	// neonBlock is how many 64 byte iterations run before the lanes are folded.
+	// each iteration adds two halfwords to every 32-bit lane, 0x4000 of them stay under 2^31.
+	neonBlock = "$0x4000"
+)
+
+// neon is the arm64 sibling of the avx2 kernel: widen halfwords into 32-bit lanes,
+// fold the lanes every neonBlock iterations and sum little endian (arm64 is little endian
+// as far as go is concerned), swapping into network byte order once at the end.
+func neon() *neonASM {
+	f := newNeonASM("checksumNEON", "func(data []byte) uint16", "$0-26", "")
+
+	// R0: data pointer, R1: remaining length, R2: scalar sum, R3: block counter, R4: scratch
+	// V0-V3: data, V4-V7: accumulators, V8: scratch, V9: 0x0000FFFF in every lane
+	f.inst("MOVD", "data_base+0(FP)", "R0")
+	f.inst("MOVD", "data_len+8(FP)", "R1")
+	f.inst("CBZ", "R1", "early_fail")
+	f.inst("MOVD", "ZR", "R2")
+	f.inst("MOVD", neonBlock, "R3")
+	for _, v := range []string{"V4", "V5", "V6", "V7"} {
+		f.inst("VEOR", v+".B16", v+".B16", v+".B16")
+	}
+	f.inst("MOVD", "$0xffff", "R4")
+	f.inst("VDUP", "R4", "V9.S4")
+
+	f.AddLabeledFunc("loop", func() {
+		// if length < 64: goto remainder
+		f.inst("CMP", "$64", "R1")
+		f.inst("BLO", "remainder")
+		// 64 bytes, post incrementing the pointer
+		f.inst("VLD1.P", "64(R0)", "[V0.H8, V1.H8, V2.H8, V3.H8]")
+		f.inst("VUADDW", "V0.H4", "V4.S4", "V4.S4")
+		f.inst("VUADDW2", "V0.H8", "V5.S4", "V5.S4")
+		f.inst("VUADDW", "V1.H4", "V6.S4", "V6.S4")
+		f.inst("VUADDW2", "V1.H8", "V7.S4", "V7.S4")
+		f.inst("VUADDW", "V2.H4", "V4.S4", "V4.S4")
+		f.inst("VUADDW2", "V2.H8", "V5.S4", "V5.S4")
+		f.inst("VUADDW", "V3.H4", "V6.S4", "V6.S4")
+		f.inst("VUADDW2", "V3.H8", "V7.S4", "V7.S4")
+		f.inst("SUB", "$64", "R1", "R1")
+		f.inst("SUBS", "$1", "R3", "R3")
+		f.inst("BNE", "loop")
+	})
+
+	foldLanes := func() {
+		for _, v := range []string{"V4", "V5", "V6", "V7"} {
+			f.inst("VUSHR", "$16", v+".S4", "V8.S4")
+			f.inst("VAND", "V9.B16", v+".B16", v+".B16")
+			f.inst("VADD", "V8.S4", v+".S4", v+".S4")
+		}
+	}
+
+	f.AddLabeledFunc("fold_block", func() {
+		foldLanes()
+		f.inst("MOVD", neonBlock, "R3")
+		f.inst("B", "loop")
+	})
+
+	f.AddLabeledFunc("remainder", func() {
+		// every lane is below 0x20000 after folding, so adding them all up can't overflow
+		foldLanes()
+		f.inst("VADD", "V5.S4", "V4.S4", "V4.S4")
+		f.inst("VADD", "V7.S4", "V6.S4", "V6.S4")
+		f.inst("VADD", "V6.S4", "V4.S4", "V4.S4")
+		// (U)nsigned (ADD) (L)ong across (V)ector: four 32-bit lanes into one 64-bit sum
+		f.inst("VUADDLV", "V4.S4", "V4")
+		f.inst("VMOV", "V4.D[0]", "R2")
+	})
+
+	f.AddLabeledFunc("remainder_loop", func() {
+		// if length < 2: goto remainder_odd
+		f.inst("CMP", "$2", "R1")
+		f.inst("BLO", "remainder_odd")
+		// little endian, like the vector lanes
+		f.inst("MOVHU.P", "2(R0)", "R4")
+		f.inst("ADD", "R4", "R2", "R2")
+		f.inst("SUB", "$2", "R1", "R1")
+		f.inst("B", "remainder_loop")
+	})
+
+	f.AddLabeledFunc("remainder_odd", func() {
+		// the odd byte is the low byte of a zero padded little endian word
+		f.inst("CBZ", "R1", "done")
+		f.inst("MOVBU", "(R0)", "R4")
+		f.inst("ADD", "R4", "R2", "R2")
+	})
+
+	f.AddLabeledFunc("done", func() {
+		f.inst("LSR", "$16", "R2", "R4")
+		f.inst("AND", "$0xffff", "R2", "R2")
+		f.inst("ADD", "R4", "R2", "R2")
+		f.inst("CMP", "$0xffff", "R2")
+		f.inst("BHI", "done")
+	})
+
+	f.AddLabeledFunc("swap", func() {
+		// swap the bytes back into network byte order and complement
+		f.inst("REV16W", "R2", "R2")
+		f.inst("MVNW", "R2", "R2")
+		f.inst("MOVH", "R2", "ret+24(FP)")
+		f.inst("RET")
+	})
+
+	f.AddLabeledFunc("early_fail", func() {
+		f.inst("MOVH", "ZR", "ret+24(FP)")
+		f.inst("RET")
+	})
+
+	return f
+}
+
+func main() {
+	out := flag.String("out", "", "assembly output file")
+	stubs := flag.String("stubs", "", "go stub output file")
+	flag.Parse()
+
+	kernels := []*neonASM{neon()}
+
+	asm := bytes.NewBufferString(header + constraint + "\n#include \"textflag.h\"\n")
+	stub := bytes.NewBufferString(header + constraint + "\npackage asm\n")
+	for _, k := range kernels {
+		k.text(asm)
+		k.stub(stub)
+	}
+
+	for file, buf := range map[string]*bytes.Buffer{*out: asm, *stubs: stub} {
+		if file == "" {
+			continue
+		}
+		if err := os.WriteFile(file, buf.Bytes(), 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
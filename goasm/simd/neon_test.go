@@ -0,0 +1,40 @@
+package asm
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// TestNEONQemu cross compiles the test binary for arm64 and runs the checksum tests under
+// qemu-aarch64 user emulation, so the neon kernel gets exercised on amd64 boxes too.
+func TestNEONQemu(t *testing.T) {
+	if runtime.GOARCH == "arm64" {
+		t.Skip("skipping: native arm64, TestImplementations already covers neon")
+	}
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	qemu, err := exec.LookPath("qemu-aarch64")
+	if err != nil {
+		if qemu, err = exec.LookPath("qemu-aarch64-static"); err != nil {
+			t.Skip("skipping: qemu-aarch64 not found in PATH")
+		}
+	}
+
+	bin := filepath.Join(t.TempDir(), "simd_arm64.test")
+	build := exec.Command("go", "test", "-c", "-o", bin, ".")
+	build.Env = append(os.Environ(), "GOARCH=arm64", "GOOS=linux", "CGO_ENABLED=0")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("cross compiling for arm64: %v\n%s", err, out)
+	}
+
+	run := exec.Command(qemu, bin, "-test.v", "-test.run", "^(TestChecksum|TestDigest|TestImplementations)$")
+	out, err := run.CombinedOutput()
+	t.Logf("qemu-aarch64:\n%s", out)
+	if err != nil {
+		t.Fatalf("arm64 tests failed under qemu: %v", err)
+	}
+}
@@ -0,0 +1,40 @@
+package asm
+
+import "math/bits"
+
+// ChecksumVec returns the RFC 1071 internet checksum of the concatenation of bufs
+// without copying them together, so a net.Buffers can be passed as is.
+//
+// Each fragment goes through the assembly kernel on its own. A fragment that starts at
+// an odd offset in the concatenation has all of its bytes in the other half of their
+// 16 bit words, and since the ones' complement sum is byte order independent that is
+// fixed up by swapping the bytes of its partial sum before adding it in (RFC 1071 section 2B).
+func ChecksumVec(bufs [][]byte) uint16 {
+	var (
+		sum   uint32
+		odd   bool
+		empty = true
+	)
+	for _, b := range bufs {
+		if len(b) == 0 {
+			continue
+		}
+		empty = false
+		// the kernel hands back the complement of the folded sum, flip it back
+		partial := ^checksum(b)
+		if odd {
+			partial = bits.ReverseBytes16(partial)
+		}
+		sum += uint32(partial)
+		if sum > 0xFFFF {
+			sum = (sum & 0xFFFF) + (sum >> 16)
+		}
+		if len(b)%2 != 0 {
+			odd = !odd
+		}
+	}
+	if empty {
+		return 0
+	}
+	return ^uint16(sum)
+}